@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"strconv"
+	"time"
 )
 
 //----------------------------------------------------------------------------
@@ -23,6 +25,10 @@ type action struct {
 	data   []byte
 	cursor cursor_location
 	lines  []*line
+
+	// 'cursor.line.changed' before the action was applied, restored when
+	// it's reverted
+	line_changed bool
 }
 
 func (a *action) apply(v *view) {
@@ -126,6 +132,10 @@ func (a *action) delete(v *view) {
 }
 
 func (a *action) do(v *view, what action_type) {
+	if what == a.what {
+		a.line_changed = a.cursor.line.changed
+	}
+	digits := len(strconv.Itoa(v.buf.lines_n))
 	switch what {
 	case action_insert:
 		a.insert(v)
@@ -136,6 +146,10 @@ func (a *action) do(v *view, what action_type) {
 		if v.buf.is_mark_set() {
 			v.buf.mark.on_insert_adjust(a)
 		}
+		for r, c := range v.buf.points {
+			c.on_insert_adjust(a)
+			v.buf.points[r] = c
+		}
 	case action_delete:
 		a.delete(v)
 		v.on_delete_adjust_top_line(a)
@@ -145,13 +159,48 @@ func (a *action) do(v *view, what action_type) {
 		if v.buf.is_mark_set() {
 			v.buf.mark.on_delete_adjust(a)
 		}
+		for r, c := range v.buf.points {
+			c.on_delete_adjust(a)
+			v.buf.points[r] = c
+		}
+	}
+	if what == a.what {
+		a.cursor.line.changed = true
+		if what == action_insert {
+			for _, l := range a.lines {
+				l.changed = true
+			}
+		}
+	} else {
+		// lines deleted by the action come back with their own flags
+		a.cursor.line.changed = a.line_changed
 	}
 	v.dirty = dirty_everything
 
+	// the line numbers in the gutter got wider or narrower
+	if len(strconv.Itoa(v.buf.lines_n)) != digits {
+		for _, bv := range v.buf.views {
+			if bv.show_line_numbers {
+				bv.gutter_changed()
+			}
+		}
+	}
+
 	// any change to the buffer causes words cache invalidation
 	v.buf.words_cache_valid = false
 }
 
+// Flags the lines which are in the buffer after the action was reverted as
+// changed.
+func (a *action) mark_changed() {
+	a.cursor.line.changed = true
+	if a.what == action_delete {
+		for _, l := range a.lines {
+			l.changed = true
+		}
+	}
+}
+
 func (a *action) last_line() *line {
 	return a.lines[len(a.lines)-1]
 }
@@ -227,9 +276,11 @@ type action_group struct {
 	prev    *action_group
 	before  cursor_location
 	after   cursor_location
+	last    time.Time // when the last action was added
 }
 
 func (ag *action_group) append(a *action) {
+	ag.last = time.Now()
 	if len(ag.actions) != 0 {
 		// Oh, we have something in the group already, let's try to
 		// merge this action with the last one.
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultAcDecide(t *testing.T) {
+	v := new_test_view(t, "", 40, 10)
+	ptr := func(f ac_func) uintptr { return reflect.ValueOf(f).Pointer() }
+	for path, want := range map[string]ac_func{
+		"/src/main.go":   gocode_ac,
+		"/src/README":    local_ac,
+		"/src/script.py": local_ac,
+		"":               local_ac,
+	} {
+		v.buf.path = path
+		if got := default_ac_decide(v); ptr(got) != ptr(want) {
+			t.Errorf("%q: got the wrong completion source", path)
+		}
+	}
+}
+
+func TestLocalAc(t *testing.T) {
+	v := new_test_view(t, "alpha beta alpine\nal", 40, 10)
+	other := new_test_buffer(t, "alto\nbass")
+	*v.ctx.buffers = []*buffer{v.buf, other}
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+
+	proposals, n := local_ac(v)
+	if n != 2 {
+		t.Errorf("prefix of %d runes, want 2", n)
+	}
+	got := map[string]bool{}
+	for _, p := range proposals {
+		got[string(p.content)] = true
+	}
+	for _, w := range []string{"alpha", "alpine", "alto"} {
+		if !got[w] {
+			t.Errorf("%q isn't proposed, got %v", w, got)
+		}
+	}
+	if got["beta"] || got["bass"] || got["al"] {
+		t.Errorf("words without the prefix are proposed: %v", got)
+	}
+}
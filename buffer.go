@@ -2,10 +2,13 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"time"
 	"unicode/utf8"
 )
 
@@ -17,16 +20,19 @@ type line struct {
 	data []byte
 	next *line
 	prev *line
+
+	// changed since the buffer was loaded or saved, see 'action.do'
+	changed bool
 }
 
 // Find a set of closest offsets for a given visual offset
-func (l *line) find_closest_offsets(voffset int) (bo, co, vo int) {
+func (l *line) find_closest_offsets(voffset, tabstop int) (bo, co, vo int) {
 	data := l.data
 	for len(data) > 0 {
 		var vodif int
 		r, rlen := utf8.DecodeRune(data)
 		data = data[rlen:]
-		vodif = rune_advance_len(r, vo)
+		vodif = rune_advance_len(r, vo, tabstop)
 		if vo+vodif > voffset {
 			return
 		}
@@ -42,6 +48,104 @@ func (l *line) find_closest_offsets(voffset int) (bo, co, vo int) {
 // buffer
 //----------------------------------------------------------------------------
 
+type line_display_mode int
+
+const (
+	// the cursor line scrolls horizontally to keep the cursor visible
+	line_display_scroll line_display_mode = iota
+	// lines are cut at the view width, nothing ever scrolls horizontally
+	line_display_truncate
+)
+
+// Line comment prefixes by file type, see 'filetypes'.
+var filetype_comments = map[string]string{
+	"go":    "//",
+	"c":     "//",
+	"cpp":   "//",
+	"js":    "//",
+	"java":  "//",
+	"rust":  "//",
+	"py":    "#",
+	"sh":    "#",
+	"lua":   "--",
+	"elisp": ";",
+	"ruby":  "#",
+	"perl":  "#",
+	"yaml":  "#",
+	"toml":  "#",
+	"conf":  "#",
+	"make":  "#",
+	"sql":   "--",
+	"lisp":  ";",
+	"vim":   "\"",
+	"tex":   "%",
+}
+
+// Types of files by extension, see 'buffer.filetype'.
+var filetypes = map[string]string{
+	".go":   "go",
+	".c":    "c",
+	".h":    "c",
+	".cc":   "cpp",
+	".cpp":  "cpp",
+	".hpp":  "cpp",
+	".py":   "py",
+	".sh":   "sh",
+	".js":   "js",
+	".java": "java",
+	".rs":   "rust",
+	".lua":  "lua",
+	".el":   "elisp",
+	".md":   "md",
+	".rb":   "ruby",
+	".pl":   "perl",
+	".yml":  "yaml",
+	".yaml": "yaml",
+	".toml": "toml",
+	".conf": "conf",
+	".sql":  "sql",
+	".lisp": "lisp",
+	".scm":  "lisp",
+	".vim":  "vim",
+	".tex":  "tex",
+}
+
+// Types of files by name, for the ones without an extension.
+var filetype_names = map[string]string{
+	"Makefile": "make",
+}
+
+// The type of the file at 'path' from its name or extension, empty if it's
+// unknown.
+func filetype_of(path string) string {
+	if ft, ok := filetype_names[filepath.Base(path)]; ok {
+		return ft
+	}
+	return filetypes[filepath.Ext(path)]
+}
+
+// Reports whether 'ft' is one of the values of 'filetypes' or
+// 'filetype_names'.
+func is_filetype(ft string) bool {
+	for _, known := range filetypes {
+		if known == ft {
+			return true
+		}
+	}
+	for _, known := range filetype_names {
+		if known == ft {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the type of the file, auto indent is on for the types of source code.
+func (b *buffer) set_filetype(ft string) {
+	b.filetype = ft
+	b.auto_indent = ft != "" && ft != "md"
+}
+
 type buffer struct {
 	views      []*view
 	first_line *line
@@ -51,12 +155,61 @@ type buffer struct {
 	bytes_n    int
 	history    *action_group
 	on_disk    *action_group
+	saved_n    int64 // bytes written by the last save
 	mark       cursor_location
 
+	// modification time of the file when it was loaded or saved, zero if
+	// it's unknown (see 'changed_on_disk')
+	mtime time.Time
+
+	// in the transient mark mode the region is valid only while the mark
+	// is active
+	mark_active bool
+
+	// how lines longer than the view width are displayed, unless the view
+	// wraps them (see 'view.wrap')
+	line_display line_display_mode
+
+	// lines are "file:line: text" references, <enter> opens them
+	locations bool
+
+	// commands which modify the contents are refused
+	readonly bool
+
+	// locations saved in position registers ("C-x r SPC"), adjusted by every
+	// action like the mark
+	points map[rune]cursor_location
+
+	// the view whose location is saved into 'loc', it's the one which had
+	// the focus most recently
+	loc_owner *view
+
+	// the file starts with a UTF-8 byte order mark, it's not a part of the
+	// contents, but it's written back on save
+	bom bool
+
+	// line ending of the file, the lines are kept without the '\r' of
+	// "\r\n", it's written back on save
+	eol eol_style
+
+	// width of a tab stop in screen cells
+	tabstop int
+
+	// RET indents the new line as the previous one, like C-j does
+	auto_indent bool
+
+	// C-j indents one more level after an opening bracket and one less
+	// before a closing one
+	electric_indent bool
+
 	// absoulte path of the file, if it's empty string, then the file has no
 	// on-disk representation
 	path string
 
+	// "go", "c", etc. (see 'filetypes'), empty if the type of the file is
+	// unknown or there is no file
+	filetype string
+
 	// buffer name (displayed in the status line), must be unique,
 	// uniqueness is maintained by godit methods
 	name string
@@ -82,6 +235,7 @@ func new_empty_buffer() *buffer {
 			line_num: 1,
 		},
 	}
+	b.tabstop = tabstop_length
 	b.init_history()
 	return b
 }
@@ -93,6 +247,10 @@ func new_buffer(r io.Reader) (*buffer, error) {
 	br := bufio.NewReader(r)
 	l := new(line)
 	b := new(buffer)
+	if prefix, _ := br.Peek(len(utf8_bom)); bytes.Equal(prefix, utf8_bom) {
+		br.Discard(len(utf8_bom))
+		b.bom = true
+	}
 	b.loc = view_location{
 		top_line:     l,
 		top_line_num: 1,
@@ -103,16 +261,21 @@ func new_buffer(r io.Reader) (*buffer, error) {
 	}
 	b.lines_n = 1
 	b.first_line = l
+	crlf_n := 0
 	for {
 		l.data, err = br.ReadBytes('\n')
 		if err != nil {
-			// last line was read
+			// last line was read, it has no '\n'
+			b.bytes_n += len(l.data)
 			break
 		} else {
 			b.bytes_n += len(l.data)
 
 			// cut off the '\n' character
 			l.data = l.data[:len(l.data)-1]
+			if bytes.HasSuffix(l.data, []byte{'\r'}) {
+				crlf_n++
+			}
 		}
 
 		b.lines_n++
@@ -124,11 +287,24 @@ func new_buffer(r io.Reader) (*buffer, error) {
 	l.prev = prevline
 	b.last_line = l
 
+	// most of the lines end with "\r\n"
+	if 2*crlf_n > b.lines_n-1 {
+		b.eol = eol_crlf
+		for l := b.first_line; l != b.last_line; l = l.next {
+			if bytes.HasSuffix(l.data, []byte{'\r'}) {
+				l.data = l.data[:len(l.data)-1]
+				b.bytes_n--
+			}
+		}
+	}
+
 	// io.EOF is not an error
 	if err == io.EOF {
 		err = nil
 	}
 
+	b.tabstop = tabstop_length
+
 	// history
 	b.init_history()
 	return b, err
@@ -176,18 +352,61 @@ func (b *buffer) init_history() {
 	b.on_disk = sentinel
 }
 
+// Discards all the undo information. The buffer stays modified if it was, until
+// it's saved.
+func (b *buffer) clear_history() {
+	synced := b.synced_with_disk()
+	b.init_history()
+	if !synced {
+		b.on_disk = nil
+	}
+}
+
+// Drops the oldest action groups so that at most 'max' can be undone, the last
+// one kept becomes the sentinel. If the file on disk matches one of the dropped
+// states, undo can no longer get there and the buffer stays modified.
+func (b *buffer) trim_history(max int) {
+	if max <= 0 {
+		return
+	}
+	g := b.history
+	for i := 0; i < max; i++ {
+		if g.prev == nil {
+			return
+		}
+		g = g.prev
+	}
+	g.prev = nil
+	g.actions = nil
+}
+
 func (b *buffer) is_mark_set() bool {
 	return b.mark.line != nil
 }
 
+// Classic mode: region exists as long as the mark is set. Transient mark mode:
+// the mark also has to be active.
+func (b *buffer) is_region_active() bool {
+	if !b.is_mark_set() {
+		return false
+	}
+	return !settings.transient_mark || b.mark_active
+}
+
 func (b *buffer) dump_history() {
+	b.write_history(os.Stderr)
+}
+
+// Writes the undo history in a human-readable form, one action group after
+// another from the sentinel, for debugging.
+func (b *buffer) write_history(w io.Writer) {
 	cur := b.history
 	for cur.prev != nil {
 		cur = cur.prev
 	}
 
 	p := func(format string, args ...interface{}) {
-		fmt.Fprintf(os.Stderr, format, args...)
+		fmt.Fprintf(w, format, args...)
 	}
 
 	i := 0
@@ -213,29 +432,223 @@ func (b *buffer) save() error {
 }
 
 func (b *buffer) save_as(filename string) error {
-	r := b.reader()
+	r := b.file_reader()
 	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = io.Copy(f, r)
+	// the last line is written without '\n', just as it was read
+	n, err := io.Copy(f, r)
 	if err != nil {
 		return err
 	}
 
+	b.saved_n = n
+	b.mark_saved()
+	b.record_mtime(filename)
+	return nil
+}
+
+// Remembers the modification time of 'filename', the file of the buffer.
+func (b *buffer) record_mtime(filename string) {
+	b.mtime = time.Time{}
+	if fi, err := os.Stat(filename); err == nil {
+		b.mtime = fi.ModTime()
+	}
+}
+
+// Reports whether the file was modified by someone else since it was loaded or
+// saved.
+func (b *buffer) changed_on_disk() bool {
+	if b.path == "" || b.mtime.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return false
+	}
+	return !fi.ModTime().Equal(b.mtime)
+}
+
+func (b *buffer) mark_saved() {
 	b.on_disk = b.history
+	b.clear_changed_lines()
 	for _, v := range b.views {
 		v.dirty |= dirty_status
 	}
+}
+
+func (b *buffer) clear_changed_lines() {
+	for l := b.first_line; l != nil; l = l.next {
+		l.changed = false
+	}
+	for _, v := range b.views {
+		v.dirty = dirty_everything
+	}
+}
+
+// Reloads the buffer contents from disk, undo history is discarded. Attached
+// views stay as close to their previous locations as possible.
+func (b *buffer) revert() error {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := b.reload(f); err != nil {
+		return err
+	}
+	b.record_mtime(b.path)
+	return nil
+}
+
+// Replaces the buffer contents with the data from 'r', see 'revert'.
+func (b *buffer) reload(r io.Reader) error {
+	nb, err := new_buffer(r)
+	if err != nil {
+		return err
+	}
+
+	b.first_line = nb.first_line
+	b.last_line = nb.last_line
+	b.lines_n = nb.lines_n
+	b.bytes_n = nb.bytes_n
+	b.bom = nb.bom
+	b.eol = nb.eol
+	b.mark = cursor_location{}
+	b.mark_active = false
+	b.words_cache_valid = false
+	b.init_history()
+
+	b.loc = b.clamp_location(b.loc)
+	for r, c := range b.points {
+		b.points[r] = b.clamp_cursor(c)
+	}
+	for _, v := range b.views {
+		v.ac = nil
+		v.view_location = b.clamp_location(v.view_location)
+		v.adjust_line_voffset()
+		v.adjust_top_line()
+		v.dirty = dirty_everything
+	}
 	return nil
 }
 
+// Returns the line with the number 'n' and its actual number, 'n' is clamped
+// to the [1, lines_n] range.
+func (b *buffer) line_at(n int) (*line, int) {
+	if n > b.lines_n {
+		n = b.lines_n
+	}
+	if n < 1 {
+		n = 1
+	}
+	l := b.first_line
+	for i := 1; i < n; i++ {
+		l = l.next
+	}
+	return l, n
+}
+
+// Makes a valid location in this buffer out of the line numbers and offsets
+// of 'loc', pointers of 'loc' are ignored, they may be stale.
+func (b *buffer) clamp_location(loc view_location) view_location {
+	var l view_location
+	l.cursor.line, l.cursor.line_num = b.line_at(loc.cursor.line_num)
+	l.cursor.boffset, l.cursor_coffset, l.cursor_voffset =
+		l.cursor.line.find_closest_offsets(loc.cursor_voffset, b.tabstop)
+	l.last_cursor_voffset = loc.last_cursor_voffset
+	if loc.top_line_num > l.cursor.line_num {
+		l.top_line, l.top_line_num = l.cursor.line, l.cursor.line_num
+	} else {
+		l.top_line, l.top_line_num = b.line_at(loc.top_line_num)
+	}
+	return l
+}
+
+// Same as 'clamp_location', for a cursor. The offset goes back to the
+// beginning of a rune if it's within one.
+func (b *buffer) clamp_cursor(c cursor_location) cursor_location {
+	var l cursor_location
+	l.line, l.line_num = b.line_at(c.line_num)
+	l.boffset = c.boffset
+	if l.boffset > len(l.line.data) {
+		l.boffset = len(l.line.data)
+	}
+	for l.boffset > 0 && l.boffset < len(l.line.data) &&
+		!utf8.RuneStart(l.line.data[l.boffset]) {
+		l.boffset--
+	}
+	return l
+}
+
+// The line comment prefix of the buffer's file, false if it isn't known.
+func (b *buffer) comment_syntax() (string, bool) {
+	p, ok := filetype_comments[b.filetype]
+	return p, ok
+}
+
+// Same as 'comment_syntax', files of unknown types get "//".
+func (b *buffer) comment_prefix() string {
+	if p, ok := b.comment_syntax(); ok {
+		return p
+	}
+	return "//"
+}
+
+// Reports whether the buffer contains C-like code, where indentation follows
+// the nesting of brackets.
+func (b *buffer) has_brace_syntax() bool {
+	switch filepath.Ext(b.path) {
+	case ".go", ".c", ".h", ".cc", ".cpp", ".hpp", ".java", ".js", ".cs",
+		".rs", ".d", ".swift":
+		return true
+	}
+	return false
+}
+
 func (b *buffer) synced_with_disk() bool {
 	return b.on_disk == b.history
 }
 
+// Reports whether the buffer was saved at 'ag' or at a later point of the
+// history.
+func (b *buffer) saved_since(ag *action_group) bool {
+	for ; ag != nil; ag = ag.next {
+		if ag == b.on_disk {
+			return true
+		}
+	}
+	return false
+}
+
+var utf8_bom = []byte{0xEF, 0xBB, 0xBF}
+
+type eol_style int
+
+const (
+	eol_lf eol_style = iota
+	eol_crlf
+)
+
+var eol_bytes = [...][]byte{
+	eol_lf:   []byte("\n"),
+	eol_crlf: []byte("\r\n"),
+}
+
+// The contents as they go to the file, with the byte order mark and the line
+// endings of the file.
+func (b *buffer) file_reader() io.Reader {
+	r := b.reader()
+	r.eol = eol_bytes[b.eol]
+	if b.bom {
+		return io.MultiReader(bytes.NewReader(utf8_bom), r)
+	}
+	return r
+}
+
 func (b *buffer) reader() *buffer_reader {
 	return new_buffer_reader(b)
 }
@@ -272,7 +685,8 @@ func (b *buffer) update_words_cache() {
 type buffer_reader struct {
 	buffer *buffer
 	line   *line
-	offset int
+	offset int    // within the line and its ending
+	eol    []byte // ends every line but the last one
 }
 
 func new_buffer_reader(buffer *buffer) *buffer_reader {
@@ -280,6 +694,7 @@ func new_buffer_reader(buffer *buffer) *buffer_reader {
 	br.buffer = buffer
 	br.line = buffer.first_line
 	br.offset = 0
+	br.eol = eol_bytes[eol_lf]
 	return br
 }
 
@@ -290,28 +705,31 @@ func (br *buffer_reader) Read(data []byte) (int, error) {
 			return nread, io.EOF
 		}
 
-		// how much can we read from current line
-		can_read := len(br.line.data) - br.offset
-		if len(data) <= can_read {
-			// if this is all we need, return
-			n := copy(data, br.line.data[br.offset:])
+		ld := br.line.data
+		eol := br.eol
+		if br.line == br.buffer.last_line {
+			eol = nil
+		}
+
+		// the rest of the line, then as much of its ending as fits
+		if br.offset < len(ld) {
+			n := copy(data, ld[br.offset:])
 			nread += n
 			br.offset += n
-			break
+			data = data[n:]
 		}
-
-		// otherwise try to read '\n' and jump to the next line
-		n := copy(data, br.line.data[br.offset:])
-		nread += n
-		data = data[n:]
-		if len(data) > 0 && br.line != br.buffer.last_line {
-			data[0] = '\n'
-			data = data[1:]
-			nread++
+		if br.offset >= len(ld) {
+			n := copy(data, eol[br.offset-len(ld):])
+			nread += n
+			br.offset += n
+			data = data[n:]
 		}
 
-		br.line = br.line.next
-		br.offset = 0
+		// the line is done, jump to the next one
+		if br.offset == len(ld)+len(eol) {
+			br.line = br.line.next
+			br.offset = 0
+		}
 	}
 	return nread, nil
 }
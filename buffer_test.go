@@ -0,0 +1,381 @@
+package main
+
+import "testing"
+import "strings"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "bytes"
+
+func new_test_buffer(t testing.TB, contents string) *buffer {
+	b, err := new_buffer(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFindClosestOffsets(t *testing.T) {
+	// "a\tb" - 'a' at 0, tab spans 1..7, 'b' at 8
+	// "\té\tx" - tab spans 0..7, 'é' (2 bytes) at 8, tab 9..15, 'x' at 16
+	cases := []struct {
+		data       string
+		voffset    int
+		bo, co, vo int
+	}{
+		{"a\tb", 0, 0, 0, 0},
+		{"a\tb", 1, 1, 1, 1},
+		{"a\tb", 4, 1, 1, 1},
+		{"a\tb", 7, 1, 1, 1},
+		{"a\tb", 8, 2, 2, 8},
+		{"a\tb", 100, 3, 3, 9},
+		{"\té\tx", 3, 0, 0, 0},
+		{"\té\tx", 8, 1, 1, 8},
+		{"\té\tx", 12, 3, 2, 9},
+		{"\té\tx", 16, 4, 3, 16},
+		{"\té\tx", 17, 5, 4, 17},
+	}
+	for _, c := range cases {
+		l := &line{data: []byte(c.data)}
+		bo, co, vo := l.find_closest_offsets(c.voffset, tabstop_length)
+		if bo != c.bo || co != c.co || vo != c.vo {
+			t.Errorf("%q at %d: got (%d, %d, %d), expected (%d, %d, %d)",
+				c.data, c.voffset, bo, co, vo, c.bo, c.co, c.vo)
+		}
+	}
+}
+
+func TestBufferClearHistory(t *testing.T) {
+	v := new_test_view(t, "hello", 40, 10)
+	b := v.buf
+	v.move_cursor_end_of_line()
+	v.on_vcommand(vcommand_insert_rune, '!')
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+
+	b.clear_history()
+	if b.synced_with_disk() {
+		t.Error("modified buffer is synced with disk after clearing the history")
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if s := string(b.contents()); s != "hello!" {
+		t.Errorf("undo after clearing: got %q, expected %q", s, "hello!")
+	}
+	if b.history.prev != nil {
+		t.Error("history is not at the sentinel after undo")
+	}
+
+	// new edits establish a valid history
+	v.on_vcommand(vcommand_insert_rune, '>')
+	v.on_vcommand(vcommand_insert_rune, '>')
+	if s := string(b.contents()); s != ">>hello!" {
+		t.Errorf("insert after clearing: got %q, expected %q", s, ">>hello!")
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if s := string(b.contents()); s != "hello!" {
+		t.Errorf("undo of the new edit: got %q, expected %q", s, "hello!")
+	}
+	v.on_vcommand(vcommand_redo, 0)
+	if s := string(b.contents()); s != ">>hello!" {
+		t.Errorf("redo of the new edit: got %q, expected %q", s, ">>hello!")
+	}
+
+	// clearing a synced buffer keeps it synced
+	b.on_disk = b.history
+	b.clear_history()
+	if !b.synced_with_disk() {
+		t.Error("synced buffer is modified after clearing the history")
+	}
+}
+
+func TestBufferModifiedFollowsUndo(t *testing.T) {
+	v := new_test_view(t, "hello", 40, 10)
+	b := v.buf
+	check := func(what string, modified bool) {
+		if b.synced_with_disk() == modified {
+			t.Errorf("%s: modified is %v, expected %v", what, !modified, modified)
+		}
+	}
+
+	check("loaded", false)
+	v.on_vcommand(vcommand_insert_rune, '!')
+	check("insert", true)
+	v.on_vcommand(vcommand_undo, 0)
+	check("undo to the loaded state", false)
+	v.on_vcommand(vcommand_redo, 0)
+	check("redo", true)
+
+	// a save moves the unmodified point
+	b.mark_saved()
+	check("saved", false)
+	v.on_vcommand(vcommand_undo, 0)
+	check("undo past the save", true)
+	v.on_vcommand(vcommand_redo, 0)
+	check("redo to the save", false)
+}
+
+// Walks the line list and checks it against the bookkeeping of the buffer.
+func check_buffer_lines(t *testing.T, b *buffer, what string) {
+	n := 0
+	var prev *line
+	for l := b.first_line; l != nil; l = l.next {
+		if l.prev != prev {
+			t.Errorf("%s: line %d has a wrong prev pointer", what, n+1)
+		}
+		prev = l
+		n++
+	}
+	if b.last_line != prev {
+		t.Errorf("%s: last_line is not the last line of the list", what)
+	}
+	if b.lines_n != n {
+		t.Errorf("%s: lines_n is %d, the list has %d lines", what, b.lines_n, n)
+	}
+	if b.bytes_n != len(b.contents()) {
+		t.Errorf("%s: bytes_n is %d, contents are %d bytes", what, b.bytes_n, len(b.contents()))
+	}
+}
+
+func TestBufferEditsAtTheEnd(t *testing.T) {
+	v := new_test_view(t, "one\ntwo", 40, 10)
+	b := v.buf
+	check := func(what, contents string, line_num, boffset int) {
+		check_buffer_lines(t, b, what)
+		if s := string(b.contents()); s != contents {
+			t.Errorf("%s: got %q, expected %q", what, s, contents)
+		}
+		if v.cursor.line_num != line_num || v.cursor.boffset != boffset {
+			t.Errorf("%s: cursor at (%d, %d), expected (%d, %d)", what,
+				v.cursor.line_num, v.cursor.boffset, line_num, boffset)
+		}
+		if v.cursor.line != b.last_line {
+			t.Errorf("%s: cursor is not on the last line", what)
+		}
+	}
+
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	for _, r := range "\nthree\n\nfour" {
+		v.on_vcommand(vcommand_insert_rune, r)
+	}
+	check("insert", "one\ntwo\nthree\n\nfour", 5, 4)
+
+	v.on_vcommand(vcommand_undo, 0)
+	check("undo", "one\ntwo", 2, 3)
+
+	v.on_vcommand(vcommand_redo, 0)
+	check("redo", "one\ntwo\nthree\n\nfour", 5, 4)
+
+	// deleting at the end of the last line does nothing
+	v.on_vcommand(vcommand_delete_rune, 0)
+	check("delete at the end", "one\ntwo\nthree\n\nfour", 5, 4)
+
+	for i := 0; i < 6; i++ {
+		v.on_vcommand(vcommand_delete_rune_backward, 0)
+	}
+	check("delete backward", "one\ntwo\nthree", 3, 5)
+
+	v.on_vcommand(vcommand_undo, 0)
+	check("undo delete", "one\ntwo\nthree\n\nfour", 5, 4)
+
+	v.on_vcommand(vcommand_redo, 0)
+	check("redo delete", "one\ntwo\nthree", 3, 5)
+
+	// killing the last line of the buffer from its beginning
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	v.on_vcommand(vcommand_delete_rune_backward, 0)
+	v.on_vcommand(vcommand_kill_line, 0)
+	check("kill line", "one\ntwo", 2, 3)
+
+	// both deletions are in the same undo group
+	v.on_vcommand(vcommand_undo, 0)
+	check("undo kill", "one\ntwo\nthree", 3, 0)
+}
+
+func TestBufferByteOrderMark(t *testing.T) {
+	for _, src := range []string{"\xEF\xBB\xBFhello\nworld", "hello\nworld"} {
+		b := new_test_buffer(t, src)
+		has_bom := strings.HasPrefix(src, "\xEF\xBB\xBF")
+		if b.bom != has_bom {
+			t.Errorf("%q: bom is %v", src, b.bom)
+		}
+		if s := string(b.contents()); s != "hello\nworld" {
+			t.Errorf("%q: contents are %q", src, s)
+		}
+		data, err := ioutil.ReadAll(b.file_reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != src {
+			t.Errorf("%q: written as %q", src, data)
+		}
+	}
+}
+
+// A final newline is the empty line after it, there is nothing else to keep
+// for writing the file back the way it was.
+func TestBufferFinalNewline(t *testing.T) {
+	cases := []struct {
+		src     string
+		lines_n int
+		last    string
+	}{
+		{"one\ntwo\n", 3, ""},
+		{"one\ntwo", 2, "two"},
+		{"\n", 2, ""},
+	}
+	for _, c := range cases {
+		b := new_test_buffer(t, c.src)
+		if b.lines_n != c.lines_n || string(b.last_line.data) != c.last {
+			t.Errorf("%q: %d lines, the last one is %q", c.src, b.lines_n, b.last_line.data)
+		}
+		if b.bytes_n != len(c.src) {
+			t.Errorf("%q: bytes_n is %d, want %d", c.src, b.bytes_n, len(c.src))
+		}
+		data, err := ioutil.ReadAll(b.file_reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.src {
+			t.Errorf("%q: written as %q", c.src, data)
+		}
+	}
+}
+
+func TestFiletype(t *testing.T) {
+	for path, want := range map[string]string{
+		"/src/main.go":  "go",
+		"/src/main.c":   "c",
+		"/src/x.h":      "c",
+		"README.md":     "md",
+		"/src/data.xyz": "",
+		"/src/Makefile": "make",
+		"/src/x.yml":    "yaml",
+		"":              "",
+	} {
+		if got := filetype_of(path); got != want {
+			t.Errorf("%q: got %q, want %q", path, got, want)
+		}
+	}
+	if !is_filetype("py") || !is_filetype("make") || is_filetype("python") || is_filetype(".py") {
+		t.Error("is_filetype doesn't check the names of the types")
+	}
+
+	// comment syntax comes from the file type only
+	b := new_empty_buffer()
+	for path, want := range map[string]string{
+		"Makefile":  "#",
+		"q.sql":     "--",
+		"main.go":   "//",
+		"notes.xyz": "",
+	} {
+		b.set_filetype(filetype_of(path))
+		if got, _ := b.comment_syntax(); got != want {
+			t.Errorf("%q: comment syntax %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestBufferLineEndings(t *testing.T) {
+	cases := []struct {
+		src      string
+		eol      eol_style
+		contents string
+	}{
+		{"one\r\ntwo\r\n", eol_crlf, "one\ntwo\n"},
+		{"one\r\ntwo", eol_crlf, "one\ntwo"},
+		{"one\ntwo\r\n", eol_lf, "one\ntwo\r\n"},
+		{"one\r", eol_lf, "one\r"},
+	}
+	for _, c := range cases {
+		b := new_test_buffer(t, c.src)
+		if b.eol != c.eol {
+			t.Errorf("%q: eol is %d, want %d", c.src, b.eol, c.eol)
+		}
+		if s := string(b.contents()); s != c.contents {
+			t.Errorf("%q: contents are %q, want %q", c.src, s, c.contents)
+		}
+		if b.bytes_n != len(c.contents) {
+			t.Errorf("%q: bytes_n is %d", c.src, b.bytes_n)
+		}
+		data, err := ioutil.ReadAll(b.file_reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.src {
+			t.Errorf("%q: written as %q", c.src, data)
+		}
+	}
+
+	// a reader filling one byte at a time splits "\r\n"
+	b := new_test_buffer(t, "\xEF\xBB\xBFa\r\n\r\nb")
+	r := b.file_reader()
+	var data []byte
+	var p [1]byte
+	for {
+		n, err := r.Read(p[:])
+		data = append(data, p[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(data) != "\xEF\xBB\xBFa\r\n\r\nb" {
+		t.Errorf("read byte by byte: %q", data)
+	}
+
+	v := new_test_view(t, "a\nb", 40, 10)
+	v.on_vcommand(vcommand_toggle_crlf, 0)
+	if v.buf.eol != eol_crlf || v.buf.synced_with_disk() {
+		t.Errorf("after toggling: eol %d, synced %v", v.buf.eol, v.buf.synced_with_disk())
+	}
+	data, _ = ioutil.ReadAll(v.buf.file_reader())
+	if string(data) != "a\r\nb" {
+		t.Errorf("after toggling: written as %q", data)
+	}
+}
+
+func TestBufferSaveAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, src := range []string{"one\ntwo", "one\ntwo\n", "", "\xEF\xBB\xBFone"} {
+		b := new_test_buffer(t, src)
+		filename := filepath.Join(dir, "saved")
+		if err := b.save_as(filename); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != src {
+			t.Errorf("%d: %q saved as %q", i, src, data)
+		}
+		if b.saved_n != int64(len(src)) {
+			t.Errorf("%d: %q: %d bytes reported", i, src, b.saved_n)
+		}
+		if !b.synced_with_disk() {
+			t.Errorf("%d: %q: not in sync with the disk after saving", i, src)
+		}
+	}
+}
+
+func TestBufferWriteHistory(t *testing.T) {
+	v := new_test_view(t, "ab", 40, 10)
+	v.on_vcommand(vcommand_insert_rune, '"')
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_delete_rune_backward, 0)
+
+	var out bytes.Buffer
+	v.buf.write_history(&out)
+	want := "action group 0: 0 actions\n" +
+		"action group 1: 1 actions\n" +
+		" + insert ( 1, 0):\"\\\"\"\n" +
+		"action group 2: 1 actions\n" +
+		" - delete ( 1, 2):\"b\"\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
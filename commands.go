@@ -0,0 +1,430 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"sort"
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// commands
+//
+// Every command that makes sense on its own has a name here, "M-x <name>"
+// runs it. Most of them are just vcommands of the active view.
+//----------------------------------------------------------------------------
+
+type command struct {
+	name string
+	run  func(g *godit)
+}
+
+type command_slice []command
+
+func (s command_slice) Len() int           { return len(s) }
+func (s command_slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s command_slice) Less(i, j int) bool { return s[i].name < s[j].name }
+
+// Sorted by name, filled in 'init', because some of the commands refer to the
+// list itself.
+var commands command_slice
+
+func view_command(name string, cmd vcommand) command {
+	return command{name, func(g *godit) {
+		g.active.leaf.on_vcommand(cmd, 0)
+	}}
+}
+
+func retab_command(name string, cmd vcommand, interior rune) command {
+	return command{name, func(g *godit) {
+		g.active.leaf.on_vcommand(cmd, interior)
+	}}
+}
+
+func lemp_command(name string, lemp func(g *godit) line_edit_mode_params) command {
+	return command{name, func(g *godit) {
+		g.set_overlay_mode(init_line_edit_mode(g, lemp(g)))
+	}}
+}
+
+func init() {
+	commands = command_slice{
+		// movement
+		view_command("forward-char", vcommand_move_cursor_forward),
+		view_command("backward-char", vcommand_move_cursor_backward),
+		view_command("forward-word", vcommand_move_cursor_word_forward),
+		view_command("backward-word", vcommand_move_cursor_word_backward),
+		view_command("next-line", vcommand_move_cursor_next_line),
+		view_command("previous-line", vcommand_move_cursor_prev_line),
+		view_command("beginning-of-line", vcommand_move_cursor_beginning_of_line),
+		view_command("end-of-line", vcommand_move_cursor_end_of_line),
+		view_command("beginning-of-buffer", vcommand_move_cursor_beginning_of_file),
+		view_command("end-of-buffer", vcommand_move_cursor_end_of_file),
+		view_command("scroll-up", vcommand_move_view_page_forward),
+		view_command("scroll-down", vcommand_move_view_page_backward),
+		view_command("set-mark", vcommand_set_mark),
+		view_command("exchange-point-and-mark", vcommand_swap_cursor_and_mark),
+		view_command("recenter", vcommand_recenter),
+		lemp_command("goto-line", (*godit).goto_line_lemp),
+		lemp_command("goto-column", (*godit).goto_column_lemp),
+		lemp_command("goto-percent", (*godit).goto_percent_lemp),
+		view_command("next-changed-line", vcommand_move_cursor_next_changed_line),
+		view_command("previous-changed-line", vcommand_move_cursor_prev_changed_line),
+		view_command("goto-matching-bracket", vcommand_move_cursor_matching_bracket),
+
+		// editing
+		view_command("yank", vcommand_yank),
+		view_command("yank-pop", vcommand_yank_pop),
+		view_command("open-line", vcommand_open_line),
+		view_command("delete-backward-char", vcommand_delete_rune_backward),
+		view_command("delete-char", vcommand_delete_rune),
+		view_command("kill-line", vcommand_kill_line),
+		view_command("kill-whole-line", vcommand_kill_whole_line),
+		view_command("delete-blank-lines", vcommand_delete_blank_lines),
+		view_command("kill-word", vcommand_kill_word),
+		view_command("backward-kill-word", vcommand_kill_word_backward),
+		view_command("kill-region", vcommand_kill_region),
+		view_command("keep-region", vcommand_keep_region),
+		view_command("copy-region", vcommand_copy_region),
+		view_command("kill-rectangle", vcommand_kill_rectangle),
+		view_command("yank-rectangle", vcommand_yank_rectangle),
+		{"copy-to-register", func(g *godit) {
+			// with a numeric argument the region is killed, as in
+			// "C-u C-x r s"
+			cmd := vcommand_copy_to_register
+			if g.active.leaf.prefix_arg != 0 {
+				cmd = vcommand_kill_to_register
+			}
+			if g.active.leaf.check_region() {
+				g.read_register("Copy to register:", register_vcommand(g, cmd))
+			}
+		}},
+		{"kill-to-register", func(g *godit) {
+			if g.active.leaf.check_region() {
+				g.read_register("Kill to register:",
+					register_vcommand(g, vcommand_kill_to_register))
+			}
+		}},
+		{"insert-register", func(g *godit) {
+			g.read_register("Insert register:",
+				register_vcommand(g, vcommand_insert_register))
+		}},
+		{"point-to-register", func(g *godit) {
+			g.read_register("Point to register:", g.point_to_register)
+		}},
+		{"jump-to-register", func(g *godit) {
+			g.read_register("Jump to register:", g.jump_to_register)
+		}},
+		view_command("undo", vcommand_undo),
+		{"redo", func(g *godit) {
+			g.active.leaf.on_vcommand(vcommand_redo, 0)
+			g.set_overlay_mode(init_redo_mode(g))
+		}},
+		{"repeat", func(g *godit) {
+			g.active.leaf.on_vcommand(vcommand_repeat_last, 0)
+			g.set_overlay_mode(init_repeat_mode(g))
+		}},
+		view_command("toggle-comment-line", vcommand_toggle_comment_line),
+		view_command("comment-region", vcommand_comment_region),
+		{"indent-region", func(g *godit) {
+			g.set_overlay_mode(init_region_indent_mode(g, 1))
+		}},
+		{"deindent-region", func(g *godit) {
+			g.set_overlay_mode(init_region_indent_mode(g, -1))
+		}},
+		view_command("reindent-region", vcommand_reindent_region),
+		retab_command("tabify", vcommand_tabify, 0),
+		retab_command("untabify", vcommand_untabify, 0),
+		retab_command("tabify-all", vcommand_tabify, 1),
+		retab_command("untabify-all", vcommand_untabify, 1),
+		view_command("upcase-region", vcommand_region_to_upper),
+		view_command("downcase-region", vcommand_region_to_lower),
+		view_command("upcase-word", vcommand_word_to_upper),
+		view_command("capitalize-word", vcommand_word_to_title),
+		view_command("downcase-word", vcommand_word_to_lower),
+		view_command("transpose-chars", vcommand_transpose_chars),
+		view_command("join-line", vcommand_join_line),
+		view_command("duplicate-line", vcommand_duplicate_line),
+		view_command("move-line-up", vcommand_move_line_up),
+		view_command("move-line-down", vcommand_move_line_down),
+		view_command("complete", vcommand_autocompl_init),
+		{"local-complete", func(g *godit) {
+			g.set_overlay_mode(init_autocomplete_mode(g))
+		}},
+		view_command("fill-paragraph", vcommand_fill_paragraph),
+		view_command("delete-trailing-whitespace", vcommand_delete_trailing_whitespace),
+		lemp_command("set-fill-column", (*godit).fill_column_lemp),
+		{"fill-region", func(g *godit) {
+			if g.active.leaf.check_writable() {
+				g.set_overlay_mode(init_fill_region_mode(g))
+			}
+		}},
+		{"search-and-replace", func(g *godit) {
+			if v := g.active.leaf; v.check_writable() && v.check_region() {
+				g.set_overlay_mode(init_line_edit_mode(g,
+					g.search_and_replace_lemp1("Replace string", g.replace_in_region)))
+			}
+		}},
+		{"replace-string", func(g *godit) {
+			if !g.active.leaf.check_writable() {
+				return
+			}
+			g.set_overlay_mode(init_line_edit_mode(g,
+				g.search_and_replace_lemp1("Replace all", g.replace_string)))
+		}},
+		{"query-replace", func(g *godit) {
+			if !g.active.leaf.check_writable() {
+				return
+			}
+			g.set_overlay_mode(init_line_edit_mode(g,
+				g.search_and_replace_lemp1("Query replace", g.query_replace)))
+		}},
+		{"filter-region", func(g *godit) {
+			if v := g.active.leaf; v.check_writable() && v.check_region() {
+				g.set_overlay_mode(init_line_edit_mode(g, g.filter_region_lemp()))
+			}
+		}},
+		lemp_command("shell-command-insert", (*godit).shell_command_lemp),
+		{"isearch-forward", func(g *godit) {
+			g.set_overlay_mode(init_isearch_mode(g, false))
+		}},
+		{"isearch-backward", func(g *godit) {
+			g.set_overlay_mode(init_isearch_mode(g, true))
+		}},
+		lemp_command("grep", (*godit).grep_lemp1),
+		{"clear-search-highlight", (*godit).clear_search_highlight},
+		{"describe-char", (*godit).describe_char},
+		{"keyboard-quit", (*godit).keyboard_quit},
+		{"suspend", suspend},
+		{"describe-key", (*godit).describe_key},
+		{"describe-bindings", (*godit).describe_bindings},
+		{"global-set-key", (*godit).global_set_key},
+
+		// files and buffers
+		lemp_command("find-file", (*godit).open_buffer_lemp),
+		lemp_command("switch-buffer", (*godit).switch_buffer_lemp),
+		{"switch-to-previous-buffer", (*godit).switch_to_previous_buffer},
+		{"kill-buffer", func(g *godit) {
+			g.kill_buffer_maybe(g.active.leaf.buf)
+		}},
+		{"reopen-killed-buffer", (*godit).reopen_closed_buffer},
+		{"save-buffer", func(g *godit) {
+			g.save_active_buffer(false)
+		}},
+		{"save-buffer-raw", func(g *godit) {
+			g.save_active_buffer(true)
+		}},
+		lemp_command("write-file", func(g *godit) line_edit_mode_params {
+			return g.save_as_buffer_lemp(false)
+		}),
+		lemp_command("write-file-raw", func(g *godit) line_edit_mode_params {
+			return g.save_as_buffer_lemp(true)
+		}),
+		lemp_command("write-region", func(g *godit) line_edit_mode_params {
+			return g.write_region_lemp(false)
+		}),
+		lemp_command("append-to-file", func(g *godit) line_edit_mode_params {
+			return g.write_region_lemp(true)
+		}),
+		{"save-some-buffers", (*godit).save_all_buffers},
+		{"revert-buffer", (*godit).revert_buffer},
+		{"revert-all-buffers", (*godit).revert_all_buffers},
+		lemp_command("write-undo-history", (*godit).write_history_lemp),
+		{"clear-undo-history", func(g *godit) {
+			b := g.active.leaf.buf
+			g.set_overlay_mode(init_key_press_mode(
+				g,
+				map[rune]func(){
+					'y': func() {
+						b.clear_history()
+						g.set_status("Undo history of %s is cleared", b.name)
+					},
+					'n': func() {},
+				},
+				0,
+				"Discard undo history of "+b.name+"? (y or n)",
+			))
+		}},
+		{"quit", func(g *godit) {
+			g.quit_maybe()
+		}},
+
+		// views
+		{"split-window-vertically", (*godit).split_vertically},
+		{"split-window-horizontally", (*godit).split_horizontally},
+		{"delete-window", (*godit).kill_active_view},
+		{"delete-other-windows", (*godit).kill_all_views_but_active},
+		{"other-window", (*godit).other_view},
+		{"balance-windows", (*godit).balance_views},
+		{"enlarge-window", func(g *godit) {
+			g.resize_active_view(1, true)
+		}},
+		{"shrink-window", func(g *godit) {
+			g.resize_active_view(-1, true)
+		}},
+		{"enlarge-window-horizontally", func(g *godit) {
+			g.resize_active_view(1, false)
+		}},
+		{"shrink-window-horizontally", func(g *godit) {
+			g.resize_active_view(-1, false)
+		}},
+		{"toggle-maximize-window", (*godit).toggle_maximize_view},
+		{"view-operations", func(g *godit) {
+			g.set_overlay_mode(init_view_op_mode(g))
+		}},
+
+		// keyboard macros
+		{"start-kbd-macro", (*godit).start_recording},
+		{"end-kbd-macro", func(g *godit) {
+			g.stop_recording(g.command_keys)
+		}},
+		{"call-last-kbd-macro", func(g *godit) {
+			g.stop_recording(g.command_keys)
+			if len(g.keymacros) > 0 {
+				g.set_overlay_mode(init_macro_repeat_mode(g))
+			}
+		}},
+
+		// settings
+		view_command("toggle-transient-mark-mode", vcommand_toggle_transient_mark),
+		view_command("toggle-full-page-scroll", vcommand_toggle_full_page_scroll),
+		view_command("toggle-smart-tab", vcommand_toggle_smart_tab),
+		view_command("toggle-auto-indent", vcommand_toggle_auto_indent),
+		view_command("toggle-electric-indent", vcommand_toggle_electric_indent),
+		view_command("toggle-truncate-lines", vcommand_toggle_truncate_lines),
+		view_command("toggle-wrap-lines", vcommand_toggle_wrap_lines),
+		view_command("toggle-line-numbers", vcommand_toggle_line_numbers),
+		view_command("toggle-crlf-line-endings", vcommand_toggle_crlf),
+		view_command("toggle-read-only", vcommand_toggle_readonly),
+		{"toggle-unicode-glyphs", (*godit).toggle_unicode_glyphs},
+		{"toggle-one-based-column", (*godit).toggle_one_based_column},
+		{"toggle-character-column", (*godit).toggle_character_column},
+		{"toggle-both-columns", (*godit).toggle_both_columns},
+		{"toggle-status-character-column", (*godit).toggle_status_character_column},
+		lemp_command("set-scroll-overlap", (*godit).scroll_overlap_lemp),
+		lemp_command("set-undo-pause", (*godit).undo_pause_lemp),
+		lemp_command("set-undo-limit", (*godit).undo_limit_lemp),
+		{"toggle-expand-tabs", (*godit).toggle_expand_tabs},
+		{"toggle-isearch-recenter", (*godit).toggle_isearch_recenter},
+		{"toggle-trim-trailing-whitespace", (*godit).toggle_trim_trailing_whitespace},
+		{"toggle-location-sync", (*godit).toggle_location_sync},
+		{"toggle-lazy-highlight", (*godit).toggle_lazy_highlight},
+		{"toggle-changed-lines-gutter", (*godit).toggle_changed_lines_gutter},
+		lemp_command("set-indentation-width", (*godit).shift_width_lemp),
+		lemp_command("set-tab-width", (*godit).tabstop_lemp),
+		lemp_command("set-filetype", (*godit).filetype_lemp),
+
+		{"ctl-x-prefix", func(g *godit) {
+			g.set_overlay_mode(init_extended_mode(g, "C-x", ctl_x_keys))
+		}},
+		{"ctl-x-r-prefix", func(g *godit) {
+			g.set_overlay_mode(init_extended_mode(g, "C-x r", ctl_x_r_keys))
+		}},
+		{"toggle", func(g *godit) {
+			g.set_overlay_mode(init_toggle_mode(g))
+		}},
+		lemp_command("execute-command", (*godit).execute_command_lemp),
+		{"quoted-insert", func(g *godit) {
+			g.set_overlay_mode(init_quoted_insert_mode(g))
+		}},
+		{"universal-argument", func(g *godit) {
+			g.set_overlay_mode(init_universal_argument_mode(g))
+		}},
+	}
+	sort.Sort(commands)
+}
+
+// Runs 'f' with the numeric argument 'n' (see 'view.prefix_arg') set for the
+// active view.
+func (g *godit) with_prefix_arg(n int, f func()) {
+	v := g.active.leaf
+	v.prefix_arg = n
+	f()
+	v.prefix_arg = 0
+}
+
+// Runs the named command, 'keys' is the number of key events it was invoked
+// with (see 'stop_recording').
+func (g *godit) run_command(name string, keys int) {
+	cmd, ok := find_command(name)
+	if !ok {
+		g.set_status("No command named %q", name)
+		return
+	}
+	g.command_keys = keys
+	cmd.run(g)
+}
+
+// Reads a register name, any character, and passes it to 'f'.
+func (g *godit) read_register(prompt string, f func(r rune)) {
+	g.set_overlay_mode(init_key_read_mode(g, prompt, func(ev *termbox.Event) {
+		if ev.Mod != 0 || ev.Ch == 0 {
+			g.set_status("Registers are named by characters")
+			return
+		}
+		f(ev.Ch)
+	}))
+}
+
+// Runs the vcommand in the active view with the register name as argument.
+func register_vcommand(g *godit, cmd vcommand) func(r rune) {
+	v := g.active.leaf
+	return func(r rune) {
+		v.on_vcommand(cmd, r)
+	}
+}
+
+func find_command(name string) (command, bool) {
+	i := sort.Search(len(commands), func(i int) bool {
+		return commands[i].name >= name
+	})
+	if i < len(commands) && commands[i].name == name {
+		return commands[i], true
+	}
+	return command{}, false
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) execute_command_lemp() line_edit_mode_params {
+	// index of the recorded "M-x" key event
+	beg := len(g.keymacros) - 1
+	return line_edit_mode_params{
+		ac_decide:      command_ac_decide,
+		prompt:         "M-x",
+		init_autocompl: true,
+
+		on_apply: func(buf *buffer) {
+			name := strings.TrimSpace(string(buf.contents()))
+			keys := 0
+			if g.recording {
+				keys = len(g.keymacros) - beg
+			}
+			g.run_command(name, keys)
+		},
+	}
+}
+
+func command_ac_decide(view *view) ac_func {
+	return command_ac
+}
+
+// Command names with the typed prefix, or containing it when none has it as
+// a prefix.
+func command_ac(view *view) ([]ac_proposal, int) {
+	typed := string(view.buf.contents()[:view.cursor.boffset])
+	proposals := make([]ac_proposal, 0, 20)
+	match := func(f func(s, sub string) bool) {
+		for _, c := range commands {
+			if f(c.name, typed) {
+				proposals = append(proposals, ac_proposal{
+					display: []byte(c.name),
+					content: []byte(c.name),
+				})
+			}
+		}
+	}
+	match(strings.HasPrefix)
+	if len(proposals) == 0 {
+		match(strings.Contains)
+	}
+	return proposals, view.cursor_coffset
+}
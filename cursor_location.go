@@ -63,24 +63,24 @@ func (a cursor_location) distance(b cursor_location) int {
 }
 
 // Find a visual and a character offset for a given cursor
-func (c *cursor_location) voffset_coffset() (vo, co int) {
+func (c *cursor_location) voffset_coffset(tabstop int) (vo, co int) {
 	data := c.line.data[:c.boffset]
 	for len(data) > 0 {
 		r, rlen := utf8.DecodeRune(data)
 		data = data[rlen:]
 		co += 1
-		vo += rune_advance_len(r, vo)
+		vo += rune_advance_len(r, vo, tabstop)
 	}
 	return
 }
 
 // Find a visual offset for a given cursor
-func (c *cursor_location) voffset() (vo int) {
+func (c *cursor_location) voffset(tabstop int) (vo int) {
 	data := c.line.data[:c.boffset]
 	for len(data) > 0 {
 		r, rlen := utf8.DecodeRune(data)
 		data = data[rlen:]
-		vo += rune_advance_len(r, vo)
+		vo += rune_advance_len(r, vo, tabstop)
 	}
 	return
 }
@@ -310,6 +310,7 @@ func (c *cursor_location) on_delete_adjust(a *action) {
 			}
 			*c = a.cursor
 			c.boffset += n
+			return
 		} else {
 			// phew.. no worries
 			c.line_num -= len(a.lines)
@@ -330,39 +331,6 @@ func (c *cursor_location) on_delete_adjust(a *action) {
 	c.boffset = a.cursor.boffset + n
 }
 
-func (c cursor_location) search_forward(word []byte) (cursor_location, bool) {
-	for c.line != nil {
-		i := bytes.Index(c.line.data[c.boffset:], word)
-		if i != -1 {
-			c.boffset += i
-			return c, true
-		}
-
-		c.line = c.line.next
-		c.line_num++
-		c.boffset = 0
-	}
-	return c, false
-}
-
-func (c cursor_location) search_backward(word []byte) (cursor_location, bool) {
-	for {
-		i := bytes.LastIndex(c.line.data[:c.boffset], word)
-		if i != -1 {
-			c.boffset = i
-			return c, true
-		}
-
-		c.line = c.line.prev
-		if c.line == nil {
-			break
-		}
-		c.line_num--
-		c.boffset = len(c.line.data)
-	}
-	return c, false
-}
-
 func swap_cursors_maybe(c1, c2 cursor_location) (r1, r2 cursor_location) {
 	if c1.line_num == c2.line_num {
 		if c1.boffset > c2.boffset {
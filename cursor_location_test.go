@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A deletion of 'nbytes' at 'c', like 'view.action_delete' makes it, but not
+// applied.
+func test_delete_action(c cursor_location, nbytes int) *action {
+	d := c.extract_bytes(nbytes)
+	a := &action{
+		what:   action_delete,
+		data:   d,
+		cursor: c,
+		lines:  make([]*line, bytes.Count(d, []byte{'\n'})),
+	}
+	for i := range a.lines {
+		a.lines[i] = c.line.next
+		c.line = c.line.next
+	}
+	return a
+}
+
+func TestCursorOnMultiLineDelete(t *testing.T) {
+	v := new_test_view(t, "one\ntwo\nthree\nfour", 40, 10)
+	first := v.buf.first_line
+	third := first.next.next
+
+	// "wo\nth" goes, "three" joins "t" and the location follows its 'e'
+	a := test_delete_action(cursor_location{first.next, 2, 1}, 5)
+	c := cursor_location{third, 3, 4}
+	c.on_delete_adjust(a)
+	if c.line != first.next || c.line_num != 2 || c.boffset != 3 {
+		t.Errorf("within the deletion's last line: got %d:%d", c.line_num, c.boffset)
+	}
+
+	// the location is within the deleted text
+	c = cursor_location{third, 3, 1}
+	c.on_delete_adjust(a)
+	if c.line != first.next || c.line_num != 2 || c.boffset != 1 {
+		t.Errorf("inside the deletion: got %d:%d", c.line_num, c.boffset)
+	}
+
+	// below the deletion only the line number changes
+	c = cursor_location{third.next, 4, 2}
+	c.on_delete_adjust(a)
+	if c.line != third.next || c.line_num != 3 || c.boffset != 2 {
+		t.Errorf("after the deletion: got %d:%d", c.line_num, c.boffset)
+	}
+}
@@ -27,11 +27,23 @@ var fill_region_prefixes = [][]byte{
 	// TODO: more?
 }
 
+// The comment prefix (one of 'fill_region_prefixes') the line starts with after
+// its indentation, nil if there is none.
+func fill_prefix(data []byte) []byte {
+	data = data[index_first_non_space(data):]
+	for _, prefix := range fill_region_prefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return prefix
+		}
+	}
+	return nil
+}
+
 func (f *fill_region_context) maxv_lemp() line_edit_mode_params {
 	v := f.g.active.leaf
 	return line_edit_mode_params{
 		prompt:          "Fill width:",
-		initial_content: "80",
+		initial_content: strconv.Itoa(f.maxv),
 		on_apply: func(buf *buffer) {
 			if i, err := strconv.Atoi(string(buf.contents())); err == nil {
 				f.maxv = i
@@ -57,15 +69,8 @@ func (f *fill_region_context) prefix_lemp() line_edit_mode_params {
 
 func init_fill_region_mode(godit *godit) *line_edit_mode {
 	v := godit.active.leaf
-	f := fill_region_context{g: godit, maxv: 80}
+	f := fill_region_context{g: godit, maxv: settings.fill_column}
 	beg, _ := v.line_region()
-	data := beg.line.data
-	data = data[index_first_non_space(data):]
-	for _, prefix := range fill_region_prefixes {
-		if bytes.HasPrefix(data, prefix) {
-			f.prefix = prefix
-			break
-		}
-	}
+	f.prefix = fill_prefix(beg.line.data)
 	return init_line_edit_mode(godit, f.prefix_lemp())
 }
@@ -9,6 +9,8 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -23,6 +25,10 @@ type key_event struct {
 	_   [1]byte
 	key termbox.Key
 	ch  rune
+
+	// a burst of keys handled as pasted text is recorded as one event with
+	// the text, see 'godit.paste'
+	text []byte
 }
 
 func create_key_event(ev *termbox.Event) key_event {
@@ -53,7 +59,7 @@ type godit struct {
 	uibuf             tulib.Buffer
 	active            *view_tree // this one is always a leaf node
 	views             *view_tree // a root node
-	buffers           []*buffer
+	buffers           []*buffer  // the most recently shown one is the first
 	lastcmdclass      vcommand_class
 	statusbuf         bytes.Buffer
 	quitflag          bool
@@ -61,22 +67,76 @@ type godit struct {
 	termbox_event     chan termbox.Event
 	keymacros         []key_event
 	recording         bool
-	killbuffer        []byte
+	kill_ring         kill_ring
+	rectangle         [][]byte
+	registers         map[rune][]byte
 	isearch_last_word []byte
 	s_and_r_last_word []byte
 	s_and_r_last_repl []byte
+
+	// number of the recorded key events which invoked the command being
+	// executed by "M-x"
+	command_keys int
+
+	// most recently killed buffers are at the end
+	closed_buffers []closed_buffer
+
+	// position registers of killed buffers, the rest are in 'buffer.points'
+	file_points map[rune]file_point
+
+	// views layout saved by 'toggle_maximize_view', nil if the active view
+	// isn't maximized
+	unmaximized        *view_tree
+	unmaximized_active *view_tree
+}
+
+// What's needed to reopen a killed buffer.
+type closed_buffer struct {
+	path string
+	loc  view_location
+}
+
+const max_closed_buffers = 16
+
+// A position register of a killed buffer, the file is opened again when the
+// register is jumped to.
+type file_point struct {
+	path     string
+	line_num int
+	boffset  int
 }
 
 func new_godit(filenames []string) *godit {
 	g := new(godit)
 	g.buffers = make([]*buffer, 0, 20)
+	g.registers = make(map[rune][]byte)
+	g.file_points = make(map[rune]file_point)
+	// a file that fails to load doesn't stop the rest, the first error is
+	// reported along with the number of failures
+	var firsterr error
+	failed := 0
 	for _, filename := range filenames {
-		g.new_buffer_from_file(filename)
+		var err error
+		if filename == "-" {
+			_, err = g.new_buffer_from_stdin()
+		} else {
+			_, err = g.new_buffer_from_file(filename)
+		}
+		if err != nil {
+			if firsterr == nil {
+				firsterr = err
+			}
+			failed++
+		}
+	}
+	switch {
+	case failed == 1:
+		g.set_status(firsterr.Error())
+	case failed > 1:
+		g.set_status("%s (%d files failed to load)", firsterr, failed)
 	}
 	if len(g.buffers) == 0 {
-		buf := new_empty_buffer()
-		buf.name = g.buffer_name("unnamed")
-		g.buffers = append(g.buffers, buf)
+		g.new_scratch_buffer()
 	}
 	g.views = new_view_tree_leaf(nil, new_view(g.view_context(), g.buffers[0]))
 	g.active = g.views
@@ -90,6 +150,13 @@ func (g *godit) kill_buffer(buf *buffer) {
 	views := make([]*view, len(buf.views))
 	copy(views, buf.views)
 
+	g.remember_closed_buffer(buf)
+	if buf.path != "" {
+		for r, c := range buf.points {
+			g.file_points[r] = file_point{buf.path, c.line_num, c.boffset}
+		}
+	}
+
 	// find replacement buffer
 	if len(views) > 0 {
 		for _, gbuf := range g.buffers {
@@ -100,9 +167,7 @@ func (g *godit) kill_buffer(buf *buffer) {
 			break
 		}
 		if replacement == nil {
-			replacement = new_empty_buffer()
-			replacement.name = g.buffer_name("unnamed")
-			g.buffers = append(g.buffers, replacement)
+			replacement = g.new_scratch_buffer()
 		}
 	}
 
@@ -127,6 +192,99 @@ func (g *godit) kill_buffer(buf *buffer) {
 
 	copy(g.buffers[bi:], g.buffers[bi+1:])
 	g.buffers = g.buffers[:len(g.buffers)-1]
+
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.forget_buffer(buf)
+	})
+}
+
+func (g *godit) remember_closed_buffer(buf *buffer) {
+	if buf.path == "" {
+		return
+	}
+
+	loc := buf.loc
+	if v := buf.loc_owner; v != nil {
+		loc = v.view_location
+	}
+
+	if len(g.closed_buffers) == max_closed_buffers {
+		copy(g.closed_buffers, g.closed_buffers[1:])
+		g.closed_buffers = g.closed_buffers[:max_closed_buffers-1]
+	}
+	g.closed_buffers = append(g.closed_buffers, closed_buffer{buf.path, loc})
+}
+
+// Loads the most recently killed buffer from disk again and shows it in the
+// active view at the location it had.
+func (g *godit) reopen_closed_buffer() {
+	n := len(g.closed_buffers)
+	if n == 0 {
+		g.set_status("No killed buffers to reopen")
+		return
+	}
+	cb := g.closed_buffers[n-1]
+	g.closed_buffers = g.closed_buffers[:n-1]
+
+	buf := g.find_buffer_by_full_path(cb.path)
+	if buf == nil {
+		var err error
+		buf, err = g.new_buffer_from_file(cb.path)
+		if err != nil {
+			return
+		}
+		buf.loc = buf.clamp_location(cb.loc)
+	}
+	g.active.leaf.attach(buf)
+	g.set_status("Reopened %s", buf.name)
+}
+
+// Saves the cursor location of the active view in the position register 'r'.
+func (g *godit) point_to_register(r rune) {
+	for _, b := range g.buffers {
+		delete(b.points, r)
+	}
+	delete(g.file_points, r)
+
+	v := g.active.leaf
+	if v.buf.points == nil {
+		v.buf.points = make(map[rune]cursor_location)
+	}
+	v.buf.points[r] = v.cursor
+	g.set_status("Saved the position to register %c", r)
+}
+
+// Shows the buffer of the position register 'r' in the active view and moves
+// the cursor there, the file of a killed buffer is opened again.
+func (g *godit) jump_to_register(r rune) {
+	v := g.active.leaf
+	for _, b := range g.buffers {
+		if c, ok := b.points[r]; ok {
+			v.attach(b)
+			v.jump_to(c)
+			v.finalize_action_group()
+			return
+		}
+	}
+
+	fp, ok := g.file_points[r]
+	if !ok {
+		g.set_status("Register %c doesn't hold a position", r)
+		return
+	}
+	b, err := g.new_buffer_from_file(fp.path)
+	if err != nil {
+		return
+	}
+	delete(g.file_points, r)
+	if b.points == nil {
+		b.points = make(map[rune]cursor_location)
+	}
+	c := b.clamp_cursor(cursor_location{line_num: fp.line_num, boffset: fp.boffset})
+	b.points[r] = c
+	v.attach(b)
+	v.jump_to(c)
+	v.finalize_action_group()
 }
 
 func (g *godit) find_buffer_by_full_path(path string) *buffer {
@@ -138,10 +296,20 @@ func (g *godit) find_buffer_by_full_path(path string) *buffer {
 	return nil
 }
 
+func (g *godit) find_buffer_by_name(name string) *buffer {
+	for _, buf := range g.buffers {
+		if buf.name == name {
+			return buf
+		}
+	}
+	return nil
+}
+
 func (g *godit) open_buffers_from_pattern(pattern string) {
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
-		panic(err)
+		g.set_status(err.Error())
+		return
 	}
 
 	var buf *buffer
@@ -152,12 +320,25 @@ func (g *godit) open_buffers_from_pattern(pattern string) {
 		buf, _ = g.new_buffer_from_file(pattern)
 	}
 	if buf == nil {
-		buf = new_empty_buffer()
-		buf.name = g.buffer_name("unnamed")
+		buf = g.new_scratch_buffer()
 	}
 	g.active.leaf.attach(buf)
 }
 
+// Registers a new buffer, it goes to the end of 'buffers' until a view shows
+// it.
+func (g *godit) add_buffer(buf *buffer) {
+	g.buffers = append(g.buffers, buf)
+}
+
+// An empty buffer without a file, saving it asks for a file name.
+func (g *godit) new_scratch_buffer() *buffer {
+	buf := new_empty_buffer()
+	buf.name = g.buffer_name("*scratch*")
+	g.add_buffer(buf)
+	return buf
+}
+
 func (g *godit) buffer_name_exists(name string) bool {
 	for _, buf := range g.buffers {
 		if buf.name == name {
@@ -189,10 +370,14 @@ func (g *godit) new_buffer_from_file(filename string) (*buffer, error) {
 	}
 
 	_, err := os.Stat(fullpath)
-	if err != nil {
-		// assume the file is just not there
+	if os.IsNotExist(err) {
+		// saving the buffer creates the file
 		g.set_status("(New file)")
 		buf = new_empty_buffer()
+		buf.path = fullpath
+	} else if err != nil {
+		g.set_status(err.Error())
+		return nil, err
 	} else {
 		f, err := os.Open(fullpath)
 		if err != nil {
@@ -206,10 +391,25 @@ func (g *godit) new_buffer_from_file(filename string) (*buffer, error) {
 			return nil, err
 		}
 		buf.path = fullpath
+		buf.record_mtime(fullpath)
 	}
 
+	buf.set_filetype(filetype_of(fullpath))
 	buf.name = g.buffer_name(filename)
-	g.buffers = append(g.buffers, buf)
+	g.add_buffer(buf)
+	return buf, nil
+}
+
+// The buffer has no path, therefore saving it asks for a file name.
+func (g *godit) new_buffer_from_stdin() (*buffer, error) {
+	buf, err := new_buffer(os.Stdin)
+	if err != nil {
+		g.set_status(err.Error())
+		return nil, err
+	}
+
+	buf.name = g.buffer_name("*stdin*")
+	g.add_buffer(buf)
 	return buf, nil
 }
 
@@ -222,6 +422,7 @@ func (g *godit) split_horizontally() {
 	if g.active.Width == 0 {
 		return
 	}
+	g.drop_unmaximized()
 	g.active.split_horizontally()
 	g.active = g.active.left
 	g.resize()
@@ -231,6 +432,7 @@ func (g *godit) split_vertically() {
 	if g.active.Height == 0 {
 		return
 	}
+	g.drop_unmaximized()
 	g.active.split_vertically()
 	g.active = g.active.top
 	g.resize()
@@ -257,6 +459,7 @@ func (g *godit) kill_active_view() {
 }
 
 func (g *godit) kill_all_views_but_active() {
+	g.drop_unmaximized()
 	g.views.traverse(func(v *view_tree) {
 		if v == g.active {
 			return
@@ -270,6 +473,72 @@ func (g *godit) kill_all_views_but_active() {
 	g.resize()
 }
 
+// Grows (n > 0) or shrinks (n < 0) the active view by 'n' lines (vertical) or
+// columns using the nearest split of that direction.
+func (g *godit) resize_active_view(n int, vertical bool) {
+	var node *view_tree
+	if vertical {
+		node = g.active.nearest_vsplit()
+	} else {
+		node = g.active.nearest_hsplit()
+	}
+	if node == nil {
+		g.set_status("No split to resize")
+		return
+	}
+
+	if node.bottom.contains(g.active) || node.right.contains(g.active) {
+		// growing the second half means moving the splitter back
+		n = -n
+	}
+	node.step_resize(n)
+}
+
+func (g *godit) balance_views() {
+	g.views.balance()
+	g.resize()
+}
+
+// Makes the active view fill the whole screen, the second call brings the
+// layout back. Changing the layout in the meantime forgets the old one.
+func (g *godit) toggle_maximize_view() {
+	if g.unmaximized != nil {
+		g.views = g.unmaximized
+		g.active = g.unmaximized_active
+		g.unmaximized = nil
+		g.unmaximized_active = nil
+		g.resize()
+		g.set_status("Views layout restored")
+		return
+	}
+
+	if g.active.parent == nil {
+		g.set_status("The only view is already maximized")
+		return
+	}
+	g.unmaximized = g.views
+	g.unmaximized_active = g.active
+	g.views = new_view_tree_leaf(nil, g.active.leaf)
+	g.active = g.views
+	g.resize()
+	g.set_status("View maximized, C-x M restores the layout")
+}
+
+// The active view is the same in both layouts, the other views of the saved
+// one are gone for good.
+func (g *godit) drop_unmaximized() {
+	if g.unmaximized == nil {
+		return
+	}
+	g.unmaximized.traverse(func(t *view_tree) {
+		if t != g.unmaximized_active {
+			t.leaf.detach()
+		}
+	})
+	g.unmaximized = nil
+	g.unmaximized_active = nil
+}
+
 // Call it manually only when views layout has changed.
 func (g *godit) resize() {
 	g.uibuf = tulib.TermboxBuffer()
@@ -349,7 +618,7 @@ func (g *godit) composite_recursively(v *view_tree) {
 		g.uibuf.Fill(splitter, termbox.Cell{
 			Fg: termbox.AttrReverse,
 			Bg: termbox.AttrReverse,
-			Ch: '|',
+			Ch: settings.glyphs.splitter,
 		})
 	} else {
 		g.composite_recursively(v.top)
@@ -363,47 +632,33 @@ func (g *godit) cursor_position() (int, int) {
 }
 
 func (g *godit) on_sys_key(ev *termbox.Event) {
-	switch ev.Key {
-	case termbox.KeyCtrlG:
-		v := g.active.leaf
-		v.ac = nil
-		g.set_overlay_mode(nil)
-		g.set_status("Quit")
-	case termbox.KeyCtrlZ:
-		suspend(g)
+	if name, ok := sys_keys.lookup(key_of(ev)); ok {
+		g.run_command(name, 1)
 	}
 }
 
-func (g *godit) on_alt_key(ev *termbox.Event) bool {
-	switch ev.Ch {
-	case 'g':
-		g.set_overlay_mode(init_line_edit_mode(g, g.goto_line_lemp()))
-		return true
-	case '/':
-		g.set_overlay_mode(init_autocomplete_mode(g))
-		return true
-	case 'q':
-		g.set_overlay_mode(init_fill_region_mode(g))
-		return true
+// Cancels whatever is in progress: a prompt or a micromode, keyboard macro
+// definition, autocompletion and the active region.
+func (g *godit) keyboard_quit() {
+	g.set_overlay_mode(nil)
+	if g.recording {
+		g.recording = false
+		g.keymacros = g.keymacros[:0]
 	}
-	return false
+	g.active.leaf.on_vcommand(vcommand_keyboard_quit, 0)
 }
 
 func (g *godit) on_key(ev *termbox.Event) {
 	v := g.active.leaf
-	switch ev.Key {
-	case termbox.KeyCtrlX:
-		g.set_overlay_mode(init_extended_mode(g))
-	case termbox.KeyCtrlS:
-		g.set_overlay_mode(init_isearch_mode(g, false))
-	case termbox.KeyCtrlR:
-		g.set_overlay_mode(init_isearch_mode(g, true))
-	default:
-		if ev.Mod&termbox.ModAlt != 0 && g.on_alt_key(ev) {
-			break
-		}
-		v.on_key(ev)
+	if ev.Key == termbox.KeyEnter && v.buf.locations && v.ac == nil {
+		g.jump_to_location()
+		return
 	}
+	if name, ok := global_keys.lookup(key_of(ev)); ok {
+		g.run_command(name, 1)
+		return
+	}
+	v.on_key(ev)
 }
 
 func (g *godit) main_loop() {
@@ -416,7 +671,7 @@ func (g *godit) main_loop() {
 	for {
 		select {
 		case ev := <-g.termbox_event:
-			ok := g.handle_event(&ev)
+			ok := g.handle_events(&ev)
 			if !ok {
 				return
 			}
@@ -431,7 +686,7 @@ func (g *godit) consume_more_events() bool {
 	for {
 		select {
 		case ev := <-g.termbox_event:
-			ok := g.handle_event(&ev)
+			ok := g.handle_events(&ev)
 			if !ok {
 				return false
 			}
@@ -442,6 +697,99 @@ func (g *godit) consume_more_events() bool {
 	panic("unreachable")
 }
 
+// A burst of at least this many character keys already waiting to be handled
+// is pasted text, nobody types that fast. Typing over a lagging connection may
+// arrive in such bursts as well, then it's inserted like pasted text too: as
+// it is, without autoindent or autocompletion, and undone at once. Bursts are
+// handled key by key while any of the keys is rebound (see
+// 'paste_keys_rebound') and in the buffers of locations, where RET jumps.
+const paste_burst_min = 8
+
+// The byte a key inserts when it's a part of pasted text, false for the keys
+// which can't be.
+func paste_byte(ev *termbox.Event) (rune, bool) {
+	if ev.Type != termbox.EventKey || ev.Mod != 0 {
+		return 0, false
+	}
+	switch ev.Key {
+	case termbox.KeySpace:
+		return ' ', true
+	case termbox.KeyTab:
+		return '\t', true
+	case termbox.KeyEnter, termbox.KeyCtrlJ:
+		return '\n', true
+	case 0:
+		return ev.Ch, ev.Ch != 0
+	}
+	return 0, false
+}
+
+// Handles 'ev' and, if it's a character key, the ones queued after it. A burst
+// of them (see 'paste_burst_min') is inserted as pasted text in one go.
+func (g *godit) handle_events(ev *termbox.Event) bool {
+	if _, ok := paste_byte(ev); !ok || g.overlay != nil {
+		return g.handle_event(ev)
+	}
+
+	evs := []termbox.Event{*ev}
+	var next *termbox.Event
+collect:
+	for {
+		select {
+		case e := <-g.termbox_event:
+			if _, ok := paste_byte(&e); !ok {
+				next = &e
+				break collect
+			}
+			evs = append(evs, e)
+		default:
+			break collect
+		}
+	}
+
+	if len(evs) >= paste_burst_min && !g.active.leaf.buf.locations && !paste_keys_rebound() {
+		g.paste(evs)
+	} else {
+		for i := range evs {
+			if !g.handle_event(&evs[i]) {
+				return false
+			}
+		}
+	}
+	if next != nil {
+		return g.handle_event(next)
+	}
+	return true
+}
+
+// Inserts the text of the keys at the cursor, see 'paste_text'.
+func (g *godit) paste(evs []termbox.Event) {
+	var data bytes.Buffer
+	for i := range evs {
+		r, _ := paste_byte(&evs[i])
+		data.WriteRune(r)
+	}
+	g.paste_text(data.Bytes())
+}
+
+// Inserts pasted text at the cursor, see 'view.insert_pasted'. A keyboard
+// macro records it as one event, so that it's replayed the same way, "C-x z"
+// pastes it again.
+func (g *godit) paste_text(text []byte) {
+	if g.recording {
+		g.keymacros = append(g.keymacros, key_event{text: text})
+	}
+	v := g.active.leaf
+	if !v.check_writable() {
+		return
+	}
+
+	g.set_status("")
+	v.repeat_text = text
+	v.on_vcommand(vcommand_insert_pasted, 0)
+	v.save_location()
+}
+
 func (g *godit) handle_event(ev *termbox.Event) bool {
 	switch ev.Type {
 	case termbox.EventKey:
@@ -459,6 +807,8 @@ func (g *godit) handle_event(ev *termbox.Event) bool {
 		if g.quitflag {
 			return false
 		}
+	case termbox.EventMouse:
+		g.on_mouse(ev)
 	case termbox.EventResize:
 		termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 		g.resize()
@@ -471,11 +821,184 @@ func (g *godit) handle_event(ev *termbox.Event) bool {
 
 	// just dump the current view location from the view to the buffer
 	// after each event, it's cheap and does what it needs to be done
-	v := g.active.leaf
-	v.buf.loc = v.view_location
+	g.active.leaf.save_location()
 	return true
 }
 
+// Number of lines the mouse wheel scrolls a view by.
+const mouse_wheel_lines = 3
+
+// Left click activates the view under the pointer and moves the cursor there,
+// clicks on a status bar only activate the view. The wheel scrolls the view
+// under the pointer without activating it. Ignored while an overlay mode is
+// active.
+func (g *godit) on_mouse(ev *termbox.Event) {
+	if g.overlay != nil {
+		return
+	}
+	switch ev.Key {
+	case termbox.MouseLeft, termbox.MouseWheelUp, termbox.MouseWheelDown:
+	default:
+		return
+	}
+
+	var target *view_tree
+	g.views.traverse(func(t *view_tree) {
+		if ev.MouseX >= t.X && ev.MouseX < t.X+t.Width &&
+			ev.MouseY >= t.Y && ev.MouseY < t.Y+t.Height {
+			target = t
+		}
+	})
+	if target == nil {
+		return
+	}
+
+	switch ev.Key {
+	case termbox.MouseWheelUp:
+		target.leaf.move_view_n_lines(-mouse_wheel_lines)
+		return
+	case termbox.MouseWheelDown:
+		target.leaf.move_view_n_lines(mouse_wheel_lines)
+		return
+	}
+
+	if target != g.active {
+		g.active.leaf.deactivate()
+		g.active = target
+		g.active.leaf.activate()
+		g.active.leaf.dirty = dirty_everything
+	}
+
+	v := g.active.leaf
+	y := ev.MouseY - target.Y
+	if y >= v.height() {
+		return
+	}
+	v.move_cursor_to(v.location_at(ev.MouseX-target.X, y))
+	v.finalize_action_group()
+}
+
+// Switches between ASCII and Unicode UI glyphs, the latter don't render with
+// every font.
+func (g *godit) toggle_unicode_glyphs() {
+	unicode := settings.glyphs != unicode_glyphs
+	if unicode {
+		settings.glyphs = unicode_glyphs
+	} else {
+		settings.glyphs = ascii_glyphs
+	}
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty = dirty_everything
+	})
+	g.set_status("Unicode glyphs %s", enabled_or_disabled(unicode))
+}
+
+func (g *godit) toggle_one_based_column() {
+	settings.column_one_based = !settings.column_one_based
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty |= dirty_status
+	})
+	if settings.column_one_based {
+		g.set_status("Columns are counted from 1")
+	} else {
+		g.set_status("Columns are counted from 0")
+	}
+}
+
+func (g *godit) toggle_character_column() {
+	settings.column_chars = !settings.column_chars
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty |= dirty_status
+	})
+	if settings.column_chars {
+		g.set_status("Columns are counted in characters")
+	} else {
+		g.set_status("Columns are counted in screen cells")
+	}
+}
+
+func (g *godit) toggle_expand_tabs() {
+	settings.expand_tabs = !settings.expand_tabs
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty |= dirty_status
+	})
+	if settings.expand_tabs {
+		g.set_status("Indenting with spaces (%d per level)", settings.shift_width)
+	} else {
+		g.set_status("Indenting with tabs")
+	}
+}
+
+func (g *godit) toggle_isearch_recenter() {
+	settings.isearch_recenter = !settings.isearch_recenter
+	g.set_status("Centering on isearch matches %s",
+		enabled_or_disabled(settings.isearch_recenter))
+}
+
+func (g *godit) toggle_trim_trailing_whitespace() {
+	settings.trim_trailing_whitespace = !settings.trim_trailing_whitespace
+	g.set_status("Trimming trailing whitespace on save %s",
+		enabled_or_disabled(settings.trim_trailing_whitespace))
+}
+
+func (g *godit) toggle_status_character_column() {
+	settings.status_char_column = !settings.status_char_column
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty |= dirty_status
+	})
+	if settings.status_char_column {
+		g.set_status("The status bar shows the character column")
+	} else {
+		g.set_status("The status bar shows the column as it's counted")
+	}
+}
+
+func (g *godit) toggle_both_columns() {
+	settings.column_both = !settings.column_both
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.dirty |= dirty_status
+	})
+	g.set_status("Showing both columns %s", enabled_or_disabled(settings.column_both))
+}
+
+func (g *godit) toggle_changed_lines_gutter() {
+	settings.changed_lines_gutter = !settings.changed_lines_gutter
+	g.gutter_changed()
+	g.set_status("Changed lines gutter %s",
+		enabled_or_disabled(settings.changed_lines_gutter))
+}
+
+// The gutters of all the views changed, see 'view.gutter_changed'.
+func (g *godit) gutter_changed() {
+	g.views.traverse(func(t *view_tree) {
+		t.leaf.gutter_changed()
+	})
+}
+
+func (g *godit) toggle_lazy_highlight() {
+	settings.lazy_highlight = !settings.lazy_highlight
+	if !settings.lazy_highlight {
+		g.clear_search_highlight()
+	}
+	g.set_status("Lazy highlight %s", enabled_or_disabled(settings.lazy_highlight))
+}
+
+// Removes the highlight of the last search matches (see
+// 'settings.lazy_highlight') from all the views.
+func (g *godit) clear_search_highlight() {
+	g.views.traverse(func(t *view_tree) {
+		if t.leaf.highlight_bytes != nil {
+			t.leaf.highlight_bytes = nil
+			t.leaf.dirty = dirty_everything
+		}
+	})
+}
+
+func (g *godit) toggle_location_sync() {
+	settings.sync_location = !settings.sync_location
+	g.set_status("Location sync %s", enabled_or_disabled(settings.sync_location))
+}
+
 func (g *godit) set_overlay_mode(m overlay_mode) {
 	if g.overlay != nil {
 		g.overlay.exit()
@@ -495,29 +1018,152 @@ func (g *godit) save_active_buffer(raw bool) {
 			return
 		}
 
-		v.presave_cleanup(raw)
+		save := func() {
+			v.presave_cleanup(raw)
+			err := b.save()
+			if err != nil {
+				g.save_failed(b, b.path, err, nil)
+			} else {
+				g.set_status("Wrote %s (%d bytes)", b.path, b.saved_n)
+			}
+		}
+		g.set_overlay_mode(nil)
+		if b.changed_on_disk() {
+			g.set_overlay_mode(init_key_press_mode(
+				g,
+				map[rune]func(){
+					'y': save,
+					'n': func() {},
+				},
+				0,
+				"File changed on disk since visited; save anyway? (y or n)",
+			))
+			return
+		}
+		save()
+		return
+	}
+
+	g.set_overlay_mode(init_line_edit_mode(g, g.save_as_buffer_lemp(raw)))
+}
+
+// Saves all modified buffers (with cleanup, like "C-x C-s"), buffers without a
+// file name are skipped, so are the ones whose files changed on disk since
+// they were visited (C-x C-s asks about those). Buffers without views get a
+// temporary one for the cleanup.
+func (g *godit) save_all_buffers() {
+	saved, skipped, changed := 0, 0, 0
+	for _, b := range g.buffers {
+		if b.synced_with_disk() {
+			continue
+		}
+		if b.path == "" {
+			skipped++
+			continue
+		}
+		if b.changed_on_disk() {
+			changed++
+			continue
+		}
+
+		var v *view
+		temporary := len(b.views) == 0
+		if temporary {
+			v = new_view(g.view_context(), b)
+		} else {
+			v = b.views[0]
+		}
+		v.presave_cleanup(false)
 		err := b.save()
+		if temporary {
+			b.loc = v.view_location
+			v.detach()
+		}
 		if err != nil {
 			g.set_status(err.Error())
-		} else {
-			g.set_status("Wrote %s", b.path)
+			return
 		}
-		g.set_overlay_mode(nil)
+		saved++
+	}
+
+	if saved == 0 && skipped == 0 && changed == 0 {
+		g.set_status("(No files need saving)")
+		return
+	}
+	status := fmt.Sprintf("Saved %d buffer(s)", saved)
+	if skipped > 0 {
+		status += fmt.Sprintf(", skipped %d without a file name", skipped)
+	}
+	if changed > 0 {
+		status += fmt.Sprintf(", skipped %d changed on disk", changed)
+	}
+	g.set_status("%s", status)
+}
+
+// Reloads the active buffer from disk, asks first if it has unsaved changes.
+func (g *godit) revert_buffer() {
+	b := g.active.leaf.buf
+	if b.path == "" {
+		g.set_status("Buffer %s has no file", b.name)
+		return
+	}
+	revert := func() {
+		if err := b.revert(); err != nil {
+			g.set_status(err.Error())
+			return
+		}
+		g.set_status("Reverted %s", b.name)
+	}
+	if b.synced_with_disk() {
+		revert()
 		return
 	}
+	g.set_overlay_mode(init_key_press_mode(
+		g,
+		map[rune]func(){
+			'y': revert,
+			'n': func() {},
+		},
+		0,
+		"Buffer "+b.name+" modified; revert anyway? (y or n)",
+	))
+}
 
-	g.set_overlay_mode(init_line_edit_mode(g, g.save_as_buffer_lemp(raw)))
+// Reloads from disk all the buffers that have no unsaved changes.
+func (g *godit) revert_all_buffers() {
+	reverted := 0
+	var lasterr error
+	for _, b := range g.buffers {
+		if b.path == "" || !b.synced_with_disk() {
+			continue
+		}
+		if err := b.revert(); err != nil {
+			lasterr = err
+			continue
+		}
+		reverted++
+	}
+
+	if lasterr != nil {
+		g.set_status("Reverted %d buffer(s), %s", reverted, lasterr)
+		return
+	}
+	g.set_status("Reverted %d buffer(s)", reverted)
 }
 
 // "lemp" stands for "line edit mode params"
 func (g *godit) switch_buffer_lemp() line_edit_mode_params {
 	return line_edit_mode_params{
 		ac_decide:      make_godit_buffer_ac_decide(g),
-		prompt:         "Buffer:",
+		prompt:         "Buffer (empty for the previous one):",
 		init_autocompl: true,
 
 		on_apply: func(buf *buffer) {
 			bufname := string(buf.contents())
+			if bufname == "" {
+				g.switch_to_previous_buffer()
+				return
+			}
 			for _, buf := range g.buffers {
 				if buf.name == bufname {
 					g.active.leaf.attach(buf)
@@ -529,6 +1175,18 @@ func (g *godit) switch_buffer_lemp() line_edit_mode_params {
 	}
 }
 
+// Shows the buffer the active view showed before the current one, repeating it
+// flips between the two.
+func (g *godit) switch_to_previous_buffer() {
+	v := g.active.leaf
+	b := v.previous_buffer()
+	if b == nil {
+		g.set_status("No previous buffer in this window")
+		return
+	}
+	v.attach(b)
+}
+
 // "lemp" stands for "line edit mode params"
 func (g *godit) open_buffer_lemp() line_edit_mode_params {
 	return line_edit_mode_params{
@@ -559,16 +1217,78 @@ func (g *godit) save_as_buffer_lemp(raw bool) line_edit_mode_params {
 			v.presave_cleanup(raw)
 			name := string(linebuf.contents())
 			fullpath := abs_path(name)
-			err := b.save_as(fullpath)
-			if err != nil {
-				g.set_status(err.Error())
-			} else {
+			saved := func() {
 				b.name = ""
 				b.name = g.buffer_name(name)
 				b.path = fullpath
+				b.set_filetype(filetype_of(fullpath))
 				v.dirty |= dirty_status
-				g.set_status("Wrote %s", b.path)
 			}
+			err := b.save_as(fullpath)
+			if err != nil {
+				g.save_failed(b, fullpath, err, saved)
+			} else {
+				saved()
+				g.set_status("Wrote %s (%d bytes)", b.path, b.saved_n)
+			}
+		},
+	}
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) write_region_lemp(appending bool) line_edit_mode_params {
+	v := g.active.leaf
+	prompt := "Write region to file:"
+	if appending {
+		prompt = "Append region to file:"
+	}
+	return line_edit_mode_params{
+		ac_decide: filesystem_line_ac_decide,
+		prompt:    prompt,
+
+		on_apply: func(linebuf *buffer) {
+			name := string(linebuf.contents())
+			if name == "" {
+				g.set_status("(No file name given)")
+				return
+			}
+			fullpath := abs_path(substitute_home(name))
+			data := v.region_or_buffer_contents()
+			err := write_file(fullpath, data, appending)
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if appending {
+				g.set_status("Appended %d bytes to %s", len(data), fullpath)
+			} else {
+				g.set_status("Wrote %d bytes to %s", len(data), fullpath)
+			}
+		},
+	}
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) write_history_lemp() line_edit_mode_params {
+	b := g.active.leaf.buf
+	return line_edit_mode_params{
+		ac_decide: filesystem_line_ac_decide,
+		prompt:    "Write undo history to file:",
+
+		on_apply: func(linebuf *buffer) {
+			name := string(linebuf.contents())
+			if name == "" {
+				g.set_status("(No file name given)")
+				return
+			}
+			fullpath := abs_path(substitute_home(name))
+			var out bytes.Buffer
+			b.write_history(&out)
+			if err := write_file(fullpath, out.Bytes(), false); err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			g.set_status("Wrote undo history of %s to %s", b.name, fullpath)
 		},
 	}
 }
@@ -583,27 +1303,66 @@ func (g *godit) filter_region_lemp() line_edit_mode_params {
 			v.finalize_action_group()
 			cmdstr := string(linebuf.contents())
 			v.region_to(func(data []byte) []byte {
-				// TODO: not portable
-				cmd := exec.Command("/bin/sh", "-c", cmdstr)
-				in, err := cmd.StdinPipe()
-				if err != nil {
-					return clone_byte_slice(data)
-				}
-
-				in.Write(data)
-				in.Close()
-
-				out, err := cmd.Output()
+				out, _, err := run_shell_command(cmdstr, data)
 				if err != nil {
 					return clone_byte_slice(data)
 				}
 				return out
 			})
 			v.finalize_action_group()
+			v.buf.mark_active = false
+		},
+	}
+}
+
+// Inserts the output of a shell command at the cursor, as one undo step. Errors
+// and whatever the command writes to stderr go to the status bar.
+func (g *godit) shell_command_lemp() line_edit_mode_params {
+	v := g.active.leaf
+	return line_edit_mode_params{
+		ac_decide: filesystem_line_ac_decide,
+		prompt:    "Insert output of:",
+		on_apply: func(linebuf *buffer) {
+			if !v.check_writable() {
+				return
+			}
+
+			stdout, stderr, err := run_shell_command(string(linebuf.contents()), nil)
+			if len(stdout) > 0 {
+				v.finalize_action_group()
+				v.insert_text(stdout)
+				v.finalize_action_group()
+			}
+
+			msg := string(bytes.TrimSpace(stderr))
+			msg = strings.Replace(msg, "\n", "; ", -1)
+			switch {
+			case err != nil && msg != "":
+				g.set_status("%s: %s", err, msg)
+			case err != nil:
+				g.set_status(err.Error())
+			case msg != "":
+				g.set_status(msg)
+			default:
+				g.set_status("Inserted %d bytes", len(stdout))
+			}
 		},
 	}
 }
 
+// Runs 'cmdline' with the shell, 'input' is its stdin. Returns what it wrote to
+// stdout and stderr.
+func run_shell_command(cmdline string, input []byte) ([]byte, []byte, error) {
+	// TODO: not portable
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", cmdline)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
 // "lemp" stands for "line edit mode params"
 func (g *godit) goto_line_lemp() line_edit_mode_params {
 	v := g.active.leaf
@@ -621,13 +1380,209 @@ func (g *godit) goto_line_lemp() line_edit_mode_params {
 	}
 }
 
+// Reads a column the same way it's shown in the status bar.
+func (g *godit) goto_column_lemp() line_edit_mode_params {
+	v := g.active.leaf
+	return line_edit_mode_params{
+		prompt: "Goto column:",
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if settings.column_one_based {
+				num--
+			}
+			if num < 0 {
+				g.set_status("Column is out of range")
+				return
+			}
+			v.on_vcommand(vcommand_move_cursor_to_column, rune(num))
+		},
+	}
+}
+
+// Accepts the percentage with or without the '%' sign.
+func (g *godit) goto_percent_lemp() line_edit_mode_params {
+	v := g.active.leaf
+	return line_edit_mode_params{
+		prompt: "Goto percent:",
+		on_apply: func(buf *buffer) {
+			numstr := strings.TrimSuffix(strings.TrimSpace(string(buf.contents())), "%")
+			num, err := strconv.Atoi(numstr)
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 0 || num > 100 {
+				g.set_status("Percentage is out of range")
+				return
+			}
+			v.on_vcommand(vcommand_move_cursor_to_percent, rune(num))
+		},
+	}
+}
+
+func (g *godit) scroll_overlap_lemp() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Scroll overlap [%d]:", settings.scroll_overlap),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 0 {
+				g.set_status("Scroll overlap can't be negative")
+				return
+			}
+			settings.scroll_overlap = num
+			g.set_status("Scroll overlap is %d lines", num)
+		},
+	}
+}
+
+func (g *godit) undo_pause_lemp() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Undo pause in milliseconds [%d]:",
+			settings.undo_pause/time.Millisecond),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 0 {
+				g.set_status("Undo pause can't be negative")
+				return
+			}
+			settings.undo_pause = time.Duration(num) * time.Millisecond
+			if num == 0 {
+				g.set_status("Pauses don't start undo groups")
+				return
+			}
+			g.set_status("Undo pause is %d milliseconds", num)
+		},
+	}
+}
+
+func (g *godit) fill_column_lemp() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Fill column [%d]:", settings.fill_column),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 1 {
+				g.set_status("Fill column must be positive")
+				return
+			}
+			settings.fill_column = num
+			g.set_status("Fill column is %d", num)
+		},
+	}
+}
+
+func (g *godit) undo_limit_lemp() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Undo limit [%d]:", settings.undo_limit),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 0 {
+				g.set_status("Undo limit can't be negative")
+				return
+			}
+			settings.undo_limit = num
+			if num == 0 {
+				g.set_status("Undo history is unlimited")
+				return
+			}
+			g.set_status("Undo limit is %d steps", num)
+		},
+	}
+}
+
+func (g *godit) shift_width_lemp() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Indentation width [%d]:", settings.shift_width),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 1 {
+				g.set_status("Indentation width must be positive")
+				return
+			}
+			settings.shift_width = num
+			g.set_status("Indentation width is %d columns", num)
+		},
+	}
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) tabstop_lemp() line_edit_mode_params {
+	v := g.active.leaf
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("Tab width [%d]:", v.buf.tabstop),
+		on_apply: func(buf *buffer) {
+			num, err := strconv.Atoi(string(buf.contents()))
+			if err != nil {
+				g.set_status(err.Error())
+				return
+			}
+			if num < 1 {
+				g.set_status("Tab width must be positive")
+				return
+			}
+			v.on_vcommand(vcommand_set_tabstop, rune(num))
+		},
+	}
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) filetype_lemp() line_edit_mode_params {
+	v := g.active.leaf
+	return line_edit_mode_params{
+		prompt: fmt.Sprintf("File type [%s]:", v.buf.filetype),
+		on_apply: func(buf *buffer) {
+			ft := string(buf.contents())
+			if ft == "" {
+				ft = v.buf.filetype
+			}
+			if ft == "" {
+				g.set_status("File type of %s is unknown", v.buf.name)
+				return
+			}
+			if !is_filetype(ft) {
+				g.set_status("Unknown file type %s", ft)
+				return
+			}
+			v.buf.set_filetype(ft)
+			v.dirty |= dirty_status
+			g.set_status("File type of %s is %s", v.buf.name, ft)
+		},
+	}
+}
+
 // "lemp" stands for "line edit mode params"
-func (g *godit) search_and_replace_lemp1() line_edit_mode_params {
+//
+// Asks for the string to replace and then for the replacement ('what' starts
+// both prompts), 'replace' does the rest.
+func (g *godit) search_and_replace_lemp1(what string, replace func(word, repl []byte)) line_edit_mode_params {
 	var prompt string
 	if len(g.s_and_r_last_word) != 0 {
-		prompt = fmt.Sprintf("Replace string [%s]:", g.s_and_r_last_word)
+		prompt = fmt.Sprintf("%s [%s]:", what, g.s_and_r_last_word)
 	} else {
-		prompt = "Replace string:"
+		prompt = what + ":"
 	}
 	return line_edit_mode_params{
 		prompt: prompt,
@@ -645,20 +1600,19 @@ func (g *godit) search_and_replace_lemp1() line_edit_mode_params {
 				g.set_status("Nothing to replace")
 				return
 			}
-			g.set_overlay_mode(init_line_edit_mode(g, g.search_and_replace_lemp2(word)))
+			g.set_overlay_mode(init_line_edit_mode(g, g.search_and_replace_lemp2(what, word, replace)))
 		},
 	}
 }
 
 // "lemp" stands for "line edit mode params"
-func (g *godit) search_and_replace_lemp2(word []byte) line_edit_mode_params {
+func (g *godit) search_and_replace_lemp2(what string, word []byte, replace func(word, repl []byte)) line_edit_mode_params {
 	var prompt string
 	if len(g.s_and_r_last_repl) != 0 {
-		prompt = fmt.Sprintf("Replace string %s with [%s]:", word, g.s_and_r_last_repl)
+		prompt = fmt.Sprintf("%s %s with [%s]:", what, word, g.s_and_r_last_repl)
 	} else {
-		prompt = fmt.Sprintf("Replace string %s with:", word)
+		prompt = fmt.Sprintf("%s %s with:", what, word)
 	}
-	v := g.active.leaf
 	return line_edit_mode_params{
 		prompt: prompt,
 		on_apply: func(buf *buffer) {
@@ -671,25 +1625,60 @@ func (g *godit) search_and_replace_lemp2(word []byte) line_edit_mode_params {
 			} else {
 				repl = contents
 			}
-			v.finalize_action_group()
-			v.last_vcommand = vcommand_none
-			g.active.leaf.search_and_replace(word, repl)
-			v.finalize_action_group()
 			g.s_and_r_last_word = word
 			g.s_and_r_last_repl = repl
+			replace(word, repl)
 		},
 	}
 }
 
-func (g *godit) stop_recording() {
+// Replaces every match in the region.
+func (g *godit) replace_in_region(word, repl []byte) {
+	v := g.active.leaf
+	v.finalize_action_group()
+	v.last_vcommand = vcommand_none
+	v.search_and_replace(word, repl)
+	v.finalize_action_group()
+	v.buf.mark_active = false
+}
+
+// Replaces every match after the cursor, one undo reverts all of them.
+func (g *godit) replace_string(word, repl []byte) {
+	v := g.active.leaf
+	v.finalize_action_group()
+	v.last_vcommand = vcommand_none
+	n := v.replace_all(word, repl)
+	v.finalize_action_group()
+	g.set_status("Replaced %d occurrence(s)", n)
+}
+
+// Asks about every match after the cursor, see query_replace_mode.go.
+func (g *godit) query_replace(word, repl []byte) {
+	if q := init_query_replace_mode(g, word, repl); q != nil {
+		g.set_overlay_mode(q)
+	}
+}
+
+func (g *godit) start_recording() {
+	g.set_status("Defining keyboard macro...")
+	g.recording = true
+	g.keymacros = g.keymacros[:0]
+}
+
+// 'keys' is the number of the last recorded key events which invoked the
+// command, they're not a part of the macro (e.g. 2 for "C-x )").
+func (g *godit) stop_recording(keys int) {
 	if !g.recording {
 		g.set_status("Not defining keyboard macro")
 		return
 	}
 
-	// clean up the current key combo: "C-x )"
+	// clean up the current key combo
 	g.recording = false
-	g.keymacros = g.keymacros[:len(g.keymacros)-2]
+	if keys > len(g.keymacros) {
+		keys = len(g.keymacros)
+	}
+	g.keymacros = g.keymacros[:len(g.keymacros)-keys]
 	if len(g.keymacros) == 0 {
 		g.set_status("Ignore empty macro")
 	} else {
@@ -699,6 +1688,10 @@ func (g *godit) stop_recording() {
 
 func (g *godit) replay_macro() {
 	for _, keyev := range g.keymacros {
+		if keyev.text != nil {
+			g.paste_text(keyev.text)
+			continue
+		}
 		ev := keyev.to_termbox_event()
 		g.handle_event(&ev)
 	}
@@ -709,9 +1702,87 @@ func (g *godit) view_context() view_context {
 		set_status: func(f string, args ...interface{}) {
 			g.set_status(f, args...)
 		},
-		kill_buffer: &g.killbuffer,
-		buffers:     &g.buffers,
+		kill_ring: &g.kill_ring,
+		rectangle: &g.rectangle,
+		buffers:   &g.buffers,
+		registers: g.registers,
+	}
+}
+
+// Exits right away if there is nothing to lose, asks otherwise (returns true
+// then, the question is an overlay mode).
+func (g *godit) quit_maybe() bool {
+	if !g.has_unsaved_buffers() {
+		g.quitflag = true
+		return false
+	}
+	g.set_overlay_mode(init_key_press_mode(
+		g,
+		map[rune]func(){
+			'y': func() {
+				g.quitflag = true
+			},
+			'n': func() {},
+		},
+		0,
+		"Modified buffers exist; exit anyway? (y or n)",
+	))
+	return true
+}
+
+// Kills the buffer right away if it has no unsaved changes, asks otherwise
+// (returns true then, the question is an overlay mode).
+func (g *godit) kill_buffer_maybe(b *buffer) bool {
+	if b.synced_with_disk() {
+		g.kill_buffer(b)
+		return false
+	}
+	g.set_overlay_mode(init_key_press_mode(
+		g,
+		map[rune]func(){
+			'y': func() {
+				g.kill_buffer(b)
+			},
+			'n': func() {},
+		},
+		0,
+		"Buffer "+b.name+" modified; kill anyway? (y or n)",
+	))
+	return true
+}
+
+// Makes the next view active, they are cycled through in the layout order (left
+// to right, top to bottom).
+func (g *godit) other_view() {
+	var leaves []*view_tree
+	next := -1
+	g.views.traverse(func(v *view_tree) {
+		if v == g.active {
+			next = len(leaves) + 1
+		}
+		leaves = append(leaves, v)
+	})
+	if len(leaves) < 2 || next < 0 {
+		return
+	}
+	g.active.leaf.deactivate()
+	g.active = leaves[next%len(leaves)]
+	g.active.leaf.activate()
+}
+
+func (g *godit) describe_char() {
+	v := g.active.leaf
+	var r rune
+	if v.cursor.eol() {
+		r = '\n'
+	} else {
+		r, _ = v.cursor.rune_under()
 	}
+	g.set_status("Char: %s (dec: %d, oct: %s, hex: %s) line: %d, column: %d",
+		strconv.QuoteRune(r), r,
+		strconv.FormatInt(int64(r), 8),
+		strconv.FormatInt(int64(r), 16),
+		v.cursor.line_num, v.cursor_column())
 }
 
 func (g *godit) has_unsaved_buffers() bool {
@@ -724,14 +1795,17 @@ func (g *godit) has_unsaved_buffers() bool {
 }
 
 func main() {
+	// buffers are loaded first, so that "-" (stdin) is consumed before
+	// termbox takes over the terminal, termbox itself talks to /dev/tty
+	godit := new_godit(os.Args[1:])
+
 	err := termbox.Init()
 	if err != nil {
 		panic(err)
 	}
 	defer termbox.Close()
-	termbox.SetInputMode(termbox.InputAlt)
+	termbox.SetInputMode(termbox.InputAlt | termbox.InputMouse)
 
-	godit := new_godit(os.Args[1:])
 	godit.resize()
 	godit.draw()
 	termbox.SetCursor(godit.cursor_position())
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"github.com/nsf/tulib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindNewFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := new_test_godit(t, "")
+	filename := filepath.Join(dir, "new.txt")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlF})
+	type_text(g, filename)
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+
+	b := g.active.leaf.buf
+	if b.path != filename {
+		t.Fatalf("new file buffer: path %q", b.path)
+	}
+
+	// saving creates the file without asking for a name
+	type_text(g, "hi")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlS})
+	if g.overlay != nil {
+		t.Fatal("saving a new file asks for a name")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hi\n" {
+		t.Errorf("saved %q", data)
+	}
+
+	// a malformed pattern is reported
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlF})
+	type_text(g, "[")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if g.active.leaf.buf != b {
+		t.Error("a malformed pattern opened a buffer")
+	}
+}
+
+func TestBuffersMostRecentFirst(t *testing.T) {
+	g := new_test_godit(t, "")
+	first := g.active.leaf.buf
+	a, b := new_empty_buffer(), new_empty_buffer()
+	a.name, b.name = "a", "b"
+	g.add_buffer(a)
+	g.add_buffer(b)
+	order := func() string {
+		s := ""
+		for _, buf := range g.buffers {
+			s += buf.name + " "
+		}
+		return s
+	}
+	if got, want := order(), first.name+" a b "; got != want {
+		t.Fatalf("new buffers: got %q, want %q", got, want)
+	}
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'b'})
+	type_text(g, "b")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if g.active.leaf.buf != b {
+		t.Fatalf("switch-buffer shows %q", g.active.leaf.buf.name)
+	}
+	if got, want := order(), "b "+first.name+" a "; got != want {
+		t.Errorf("after switching: got %q, want %q", got, want)
+	}
+
+	// the replacement of a killed buffer is the most recently shown one
+	g.kill_buffer(b)
+	if g.active.leaf.buf != first {
+		t.Errorf("after killing: the view shows %q", g.active.leaf.buf.name)
+	}
+}
+
+func TestKillModifiedBuffer(t *testing.T) {
+	g := new_test_godit(t, "")
+	b := g.active.leaf.buf
+	g.split_vertically()
+	type_text(g, "x")
+	kill := func(answer rune) {
+		send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'k'})
+		send_keys(g, termbox.Event{Ch: answer})
+	}
+
+	kill('n')
+	if len(g.buffers) != 1 || g.buffers[0] != b {
+		t.Fatal("the buffer is killed without confirmation")
+	}
+
+	// both views get the replacement, a new empty buffer
+	kill('y')
+	if len(g.buffers) != 1 || g.buffers[0] == b {
+		t.Fatalf("the buffer isn't killed, %d buffer(s)", len(g.buffers))
+	}
+	g.views.traverse(func(vt *view_tree) {
+		if vt.leaf.buf != g.buffers[0] {
+			t.Errorf("a view shows %q", vt.leaf.buf.name)
+		}
+	})
+	if len(b.views) != 0 {
+		t.Errorf("the killed buffer has %d view(s)", len(b.views))
+	}
+}
+
+func TestUniversalArgument(t *testing.T) {
+	g := new_test_godit(t, strings.Repeat("abcd", 10))
+	v := g.active.leaf
+	cu := termbox.Event{Key: termbox.KeyCtrlU}
+	send_keys(g, cu, cu, termbox.Event{Key: termbox.KeyCtrlF})
+	if v.cursor.boffset != 16 {
+		t.Errorf("C-u C-u C-f: cursor at %d, want 16", v.cursor.boffset)
+	}
+	send_keys(g, cu, termbox.Event{Ch: '3'}, termbox.Event{Key: termbox.KeyCtrlB})
+	if v.cursor.boffset != 13 {
+		t.Errorf("C-u 3 C-b: cursor at %d, want 13", v.cursor.boffset)
+	}
+
+	// the argument is for one key only
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlF})
+	if v.cursor.boffset != 14 || v.prefix_arg != 0 {
+		t.Errorf("C-f: cursor at %d, want 14", v.cursor.boffset)
+	}
+}
+
+func TestScratchBuffer(t *testing.T) {
+	g := new_godit(nil)
+	g.uibuf = tulib.NewBuffer(80, 24)
+	b := g.active.leaf.buf
+	if len(g.buffers) != 1 || g.buffers[0] != b {
+		t.Fatal("the view doesn't show the only buffer")
+	}
+	if b.name != "*scratch*" || b.path != "" {
+		t.Errorf("name %q, path %q", b.name, b.path)
+	}
+	if b.lines_n != 1 || b.first_line != b.last_line || len(b.first_line.data) != 0 {
+		t.Errorf("%d line(s): %q", b.lines_n, b.contents())
+	}
+	if b.history == nil || b.history.prev != nil || len(b.history.actions) != 0 {
+		t.Error("the history doesn't start at the sentinel")
+	}
+
+	// saving asks for a file name
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlS})
+	if _, ok := g.overlay.(*line_edit_mode); !ok {
+		t.Errorf("saving: overlay %T", g.overlay)
+	}
+}
+
+func TestSetFiletype(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := new_test_godit(t, "")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlF})
+	type_text(g, filepath.Join(dir, "main.go"))
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	b := g.active.leaf.buf
+	if b.filetype != "go" || !b.auto_indent {
+		t.Errorf("find-file: file type %q, auto indent %v", b.filetype, b.auto_indent)
+	}
+
+	set := func(ft string) {
+		send_keys(g, termbox.Event{Mod: termbox.ModAlt, Ch: 'x'})
+		type_text(g, "set-filetype")
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+		type_text(g, ft)
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	}
+	set("py")
+	if b.filetype != "py" {
+		t.Errorf("set-filetype py: file type %q", b.filetype)
+	}
+	set("python")
+	if b.filetype != "py" || g.statusbuf.String() != "Unknown file type python" {
+		t.Errorf("set-filetype python: file type %q, status %q", b.filetype, g.statusbuf.String())
+	}
+}
+
+func TestOtherViewCyclesThroughViews(t *testing.T) {
+	g := new_test_godit(t, "one")
+	buf := g.active.leaf.buf
+	g.resize()
+	g.split_vertically()
+	g.split_horizontally()
+	var leaves []*view_tree
+	g.views.traverse(func(v *view_tree) { leaves = append(leaves, v) })
+	if len(leaves) != 3 {
+		t.Fatalf("%d views after two splits", len(leaves))
+	}
+
+	start := g.active
+	seen := map[*view_tree]bool{}
+	for i := 0; i < 3; i++ {
+		seen[g.active] = true
+		send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'o'})
+	}
+	if len(seen) != 3 || g.active != start {
+		t.Errorf("C-x o visited %d views, back at the start: %v", len(seen), g.active == start)
+	}
+
+	// the views share the buffer, an edit shows up in all of them
+	type_text(g, "x")
+	for _, v := range leaves {
+		if v.leaf.buf != buf {
+			t.Fatal("a split view shows another buffer")
+		}
+	}
+	for _, v := range leaves {
+		v.leaf.draw()
+		if c := v.leaf.uibuf.Cells[0].Ch; c != 'x' {
+			t.Errorf("a view shows %q", c)
+		}
+	}
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: '1'})
+	if g.views.leaf == nil {
+		t.Error("C-x 1 left more than one view")
+	}
+}
+
+func TestRevertBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(filename, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := new_test_godit(t, "")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlF})
+	type_text(g, filename)
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	v := g.active.leaf
+	v.on_vcommand(vcommand_move_cursor_to_line, 4)
+	type_text(g, "x")
+
+	revert := func() {
+		send_keys(g, termbox.Event{Mod: termbox.ModAlt, Ch: 'x'})
+		type_text(g, "revert-buffer")
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	}
+
+	// the file shrank below the cursor line
+	if err := ioutil.WriteFile(filename, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	revert()
+	send_keys(g, termbox.Event{Ch: 'n'})
+	if got := string(v.buf.contents()); got != "one\ntwo\nthree\nxfour\n" {
+		t.Fatalf("answered n: got %q", got)
+	}
+	revert()
+	send_keys(g, termbox.Event{Ch: 'y'})
+	if got := string(v.buf.contents()); got != "new\n" {
+		t.Errorf("reverted: got %q", got)
+	}
+	if v.cursor.line_num > v.buf.lines_n {
+		t.Errorf("cursor on line %d of %d", v.cursor.line_num, v.buf.lines_n)
+	}
+	if v.buf.history.prev != nil || len(v.buf.history.actions) != 0 || !v.buf.synced_with_disk() {
+		t.Error("the undo history is kept")
+	}
+	if got := g.statusbuf.String(); got != "Reverted "+v.buf.name {
+		t.Errorf("status %q", got)
+	}
+}
+
+func TestSaveChangedOnDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(filename, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g := new_test_godit(t, "")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlF})
+	type_text(g, filename)
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	b := g.active.leaf.buf
+	if b.changed_on_disk() {
+		t.Fatal("changed on disk right after loading")
+	}
+	type_text(g, "x")
+	save := func() {
+		send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlS})
+	}
+	contents := func() string {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// someone else wrote the file
+	later := b.mtime.Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	save()
+	if _, ok := g.overlay.(*key_press_mode); !ok {
+		t.Fatalf("saving doesn't ask, overlay %T", g.overlay)
+	}
+	send_keys(g, termbox.Event{Ch: 'n'})
+	if got := contents(); got != "one\n" {
+		t.Errorf("answered n: the file has %q", got)
+	}
+
+	save()
+	send_keys(g, termbox.Event{Ch: 'y'})
+	if got := contents(); got != "xone\n" {
+		t.Errorf("answered y: the file has %q", got)
+	}
+
+	// the time of our own save is remembered
+	type_text(g, "y")
+	save()
+	if g.overlay != nil || contents() != "xyone\n" {
+		t.Errorf("the second save asks again, overlay %T", g.overlay)
+	}
+
+	// "C-x s" leaves the changed file alone
+	type_text(g, "z")
+	later = b.mtime.Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 's'})
+	if got := contents(); got != "xyone\n" {
+		t.Errorf("C-x s: the file has %q", got)
+	}
+	if got := g.statusbuf.String(); got != "Saved 0 buffer(s), skipped 1 changed on disk" {
+		t.Errorf("C-x s: status %q", got)
+	}
+}
+
+func TestMouse(t *testing.T) {
+	g := new_test_godit(t, strings.Repeat("line\n", 50)+"\tlast")
+	g.resize()
+	v := g.active.leaf
+	mouse := func(key termbox.Key, x, y int) {
+		g.handle_event(&termbox.Event{Type: termbox.EventMouse, Key: key, MouseX: x, MouseY: y})
+	}
+
+	mouse(termbox.MouseLeft, 2, 3)
+	if v.cursor.line_num != 4 || v.cursor.boffset != 2 {
+		t.Errorf("click: cursor at %d:%d, want 4:2", v.cursor.line_num, v.cursor.boffset)
+	}
+	mouse(termbox.MouseLeft, 30, 5)
+	if v.cursor.line_num != 6 || v.cursor.boffset != 4 {
+		t.Errorf("click past the end of a line: cursor at %d:%d, want 6:4",
+			v.cursor.line_num, v.cursor.boffset)
+	}
+
+	// a click on the status bar doesn't move the cursor
+	mouse(termbox.MouseLeft, 2, v.height())
+	if v.cursor.line_num != 6 {
+		t.Errorf("status bar click: cursor on line %d", v.cursor.line_num)
+	}
+
+	mouse(termbox.MouseWheelDown, 0, 0)
+	mouse(termbox.MouseWheelDown, 0, 0)
+	if v.top_line_num != 1+2*mouse_wheel_lines {
+		t.Errorf("wheel down: top line %d", v.top_line_num)
+	}
+	mouse(termbox.MouseWheelUp, 0, 0)
+	if v.top_line_num != 1+mouse_wheel_lines {
+		t.Errorf("wheel up: top line %d", v.top_line_num)
+	}
+	check_view_invariants(t, v, "wheel")
+	for i := 0; i < 5; i++ {
+		mouse(termbox.MouseWheelUp, 0, 0)
+	}
+	if v.top_line_num != 1 {
+		t.Errorf("wheel up at the top: top line %d", v.top_line_num)
+	}
+}
+
+func TestPaste(t *testing.T) {
+	g := new_test_godit(t, "\tx")
+	g.termbox_event = make(chan termbox.Event, 100)
+	v := g.active.leaf
+	v.buf.auto_indent = true
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	queue := func(s string) *termbox.Event {
+		for _, r := range s {
+			ev := termbox.Event{Type: termbox.EventKey, Ch: r}
+			switch r {
+			case ' ':
+				ev = termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}
+			case '\n':
+				ev = termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}
+			}
+			g.termbox_event <- ev
+		}
+		ev := <-g.termbox_event
+		return &ev
+	}
+
+	// pasted lines are inserted as they are, no autoindent
+	g.handle_events(queue("\nfunc f() {\n}"))
+	want := "\tx\nfunc f() {\n}"
+	if got := string(v.buf.contents()); got != want {
+		t.Fatalf("paste: got %q", got)
+	}
+	if v.cursor.line_num != 3 || v.cursor.boffset != 1 {
+		t.Errorf("paste: cursor at %d:%d, want 3:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "\tx" {
+		t.Errorf("undo: got %q", got)
+	}
+
+	// a couple of keys is typing
+	g.handle_events(queue("\nab"))
+	if got := string(v.buf.contents()); got != "\tx\n\tab" {
+		t.Errorf("typing: got %q", got)
+	}
+
+	// "C-x z" pastes the same text again
+	g.handle_events(queue(" 2345678"))
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'z'})
+	if got := string(v.buf.contents()); got != "\tx\n\tab 2345678 2345678" {
+		t.Errorf("repeat: got %q", got)
+	}
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlG})
+
+	// a rebound key runs its command, so the burst isn't a paste
+	set_key(char('@'), "newline")
+	defer delete(view_keys, char('@'))
+	v.buf.auto_indent = false
+	g.handle_events(queue("cd@efghij"))
+	if got := string(v.buf.contents()); got != "\tx\n\tab 2345678 2345678cd\nefghij" {
+		t.Errorf("a rebound key: got %q", got)
+	}
+}
+
+func TestMacroWithPaste(t *testing.T) {
+	g := new_test_godit(t, "\tx")
+	g.termbox_event = make(chan termbox.Event, 100)
+	v := g.active.leaf
+	v.buf.auto_indent = true
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: '('})
+	for _, k := range []termbox.Event{{Key: termbox.KeyEnter}, {Ch: 'i'}, {Ch: 'f'},
+		{Key: termbox.KeySpace}, {Ch: 'a'}, {Key: termbox.KeyEnter}, {Key: termbox.KeyTab},
+		{Ch: 'b'}, {Key: termbox.KeyEnter}} {
+		k.Type = termbox.EventKey
+		g.termbox_event <- k
+	}
+	ev := <-g.termbox_event
+	g.handle_events(&ev)
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: ')'})
+	want := "\tx\nif a\n\tb\n"
+	if got := string(v.buf.contents()); got != want {
+		t.Fatalf("paste: got %q", got)
+	}
+	if len(g.keymacros) != 1 {
+		t.Errorf("the paste is recorded as %d events, want 1", len(g.keymacros))
+	}
+
+	// replayed as pasted text, no autoindent
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'e'})
+	want += "\nif a\n\tb\n"
+	if got := string(v.buf.contents()); got != want {
+		t.Errorf("replay: got %q, want %q", got, want)
+	}
+}
+
+func TestRepeatLastCommand(t *testing.T) {
+	g := new_test_godit(t, "")
+	v := g.active.leaf
+
+	v.on_vcommand(vcommand_repeat_last, 0)
+	if got := g.statusbuf.String(); got != "No command to repeat" {
+		t.Errorf("nothing to repeat: status %q", got)
+	}
+
+	type_text(g, "a")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'z'})
+	if got := string(v.buf.contents()); got != "aa" {
+		t.Errorf("C-x z: got %q", got)
+	}
+	// 'z' keeps repeating, other keys work as usual
+	type_text(g, "zzb")
+	if got := string(v.buf.contents()); got != "aaaab" {
+		t.Errorf("C-x z z z b: got %q", got)
+	}
+
+	// the numeric argument is repeated as well
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlU}, termbox.Event{Ch: '2'},
+		termbox.Event{Key: termbox.KeyCtrlB})
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Ch: 'z'})
+	if v.cursor.boffset != 1 {
+		t.Errorf("C-u 2 C-b C-x z: cursor at %d, want 1", v.cursor.boffset)
+	}
+}
+
+func TestReadonlyRefusesGoditCommands(t *testing.T) {
+	g := new_test_godit(t, "aaa bbb")
+	v := g.active.leaf
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_toggle_readonly, 0)
+
+	for _, name := range []string{"search-and-replace", "fill-region", "filter-region"} {
+		g.set_status("")
+		g.run_command(name, 0)
+		if got := g.statusbuf.String(); got != "Buffer is read-only" || g.overlay != nil {
+			t.Errorf("%s: status %q, overlay %v", name, got, g.overlay)
+		}
+		g.set_overlay_mode(nil)
+	}
+
+	// "C-x C-r" doesn't even prompt
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlR})
+	type_text(g, "a")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	type_text(g, "z")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if got := string(v.buf.contents()); got != "aaa bbb" {
+		t.Errorf("C-x C-r: got %q", got)
+	}
+
+	// whatever gets around the commands' checks, the actions refuse it
+	g.replace_in_region([]byte("a"), []byte("z"))
+	v.action_insert(v.cursor, []byte("x"))
+	v.action_delete(cursor_location{v.buf.first_line, 1, 0}, 1)
+	if got := string(v.buf.contents()); got != "aaa bbb" {
+		t.Errorf("actions: got %q", got)
+	}
+	if got := g.statusbuf.String(); got != "Buffer is read-only" {
+		t.Errorf("actions: status %q", got)
+	}
+}
+
+func TestGrepOpenBuffers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "godit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	g := new_test_godit(t, "")
+	var bufs []*buffer
+	for _, sub := range []string{"a", "b"} {
+		path := filepath.Join(dir, sub, "x.txt")
+		os.Mkdir(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte("one\nneedle\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b, err := g.new_buffer_from_file(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the buffers of files with the same name in different
+		// directories
+		b.name = g.buffer_name("x.txt")
+		bufs = append(bufs, b)
+	}
+
+	g.grep([]byte("needle"), "")
+	v := g.active.leaf
+	if !v.buf.readonly {
+		t.Errorf("the grep buffer isn't read-only")
+	}
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	want := bufs[0].path + ":2: needle\n" + bufs[1].path + ":2: needle\n"
+	if got := string(v.buf.contents()); got != want {
+		t.Fatalf("grep: got %q, want %q", got, want)
+	}
+
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	g.jump_to_location()
+	if v.buf != bufs[1] || v.cursor.line_num != 2 {
+		t.Errorf("jump: got %s at %d, want %s at 2", v.buf.name, v.cursor.line_num, bufs[1].name)
+	}
+}
+
+func TestShellCommandInsert(t *testing.T) {
+	g := new_test_godit(t, "x")
+	v := g.active.leaf
+	lemp := g.shell_command_lemp()
+	lemp.on_apply(new_test_buffer(t, "printf 'a\\nb'; echo oops >&2"))
+	if got := string(v.buf.contents()); got != "a\nbx" {
+		t.Errorf("got %q", got)
+	}
+	if got := g.statusbuf.String(); got != "oops" {
+		t.Errorf("status %q", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "x" {
+		t.Errorf("undo: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	g.filter_region_lemp().on_apply(new_test_buffer(t, "tr x y"))
+	if got := string(v.buf.contents()); got != "y" {
+		t.Errorf("filter region: got %q", got)
+	}
+}
+
+func TestQuotedInsertCode(t *testing.T) {
+	g := new_test_godit(t, "")
+	v := g.active.leaf
+	quoted := func(evs ...termbox.Event) {
+		send_keys(g, termbox.Event{Key: termbox.KeyCtrlQ})
+		send_keys(g, evs...)
+	}
+	quoted(termbox.Event{Ch: '1'}, termbox.Event{Ch: '0'}, termbox.Event{Ch: '1'})
+	quoted(termbox.Event{Ch: '#'}, termbox.Event{Ch: '6'}, termbox.Event{Ch: '6'},
+		termbox.Event{Key: termbox.KeyEnter})
+	// no digits after '#', then it's the character itself
+	quoted(termbox.Event{Ch: '#'}, termbox.Event{Key: termbox.KeyEnter})
+	quoted(termbox.Event{Ch: '#'}, termbox.Event{Ch: 'x'})
+	if got := string(v.buf.contents()); got != "AB##x" {
+		t.Errorf("got %q", got)
+	}
+}
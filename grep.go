@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+//----------------------------------------------------------------------------
+// grep
+//
+// Searches for a string in a set of files (or in all the open buffers) and
+// puts the matching lines into the "*grep*" buffer as "file:line: text"
+// references. <enter> on one of those opens the file at that line. Open
+// buffers are referred to by their paths, so that buffers of files with the
+// same name aren't confused, buffers with no file by their names.
+//----------------------------------------------------------------------------
+
+const grep_buffer_name = "*grep*"
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) grep_lemp1() line_edit_mode_params {
+	return line_edit_mode_params{
+		prompt: "Grep for:",
+		on_apply: func(buf *buffer) {
+			pattern := buf.contents()
+			if len(pattern) == 0 {
+				g.set_status("(Nothing to search for)")
+				return
+			}
+			g.set_overlay_mode(init_line_edit_mode(g, g.grep_lemp2(pattern)))
+		},
+	}
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) grep_lemp2(pattern []byte) line_edit_mode_params {
+	return line_edit_mode_params{
+		ac_decide: filesystem_line_ac_decide,
+		prompt:    fmt.Sprintf("Grep for %q in files (empty for open buffers):", pattern),
+		on_apply: func(buf *buffer) {
+			g.grep(pattern, string(buf.contents()))
+		},
+	}
+}
+
+func (g *godit) grep(pattern []byte, files string) {
+	var out bytes.Buffer
+	matches, searched := 0, 0
+	grep_buffer := func(name string, b *buffer) {
+		searched++
+		c := cursor_location{b.first_line, 1, 0}
+		for {
+			m, ok := b.find(c, pattern, search_opts{})
+			if !ok {
+				break
+			}
+			fmt.Fprintf(&out, "%s:%d: %s\n", name, m.line_num, m.line.data)
+			matches++
+			if m.line.next == nil {
+				break
+			}
+			c = cursor_location{m.line.next, m.line_num + 1, 0}
+		}
+	}
+
+	if files == "" {
+		for _, b := range g.buffers {
+			if b.name != grep_buffer_name {
+				grep_buffer(b.location_name(), b)
+			}
+		}
+	} else {
+		names, err := filepath.Glob(substitute_home(files))
+		if err != nil {
+			g.set_status(err.Error())
+			return
+		}
+		for _, name := range names {
+			// open buffers are searched as they are, with unsaved
+			// changes
+			if b := g.find_buffer_by_full_path(abs_path(name)); b != nil {
+				grep_buffer(name, b)
+				continue
+			}
+			b, err := load_buffer(name)
+			if err != nil {
+				continue
+			}
+			grep_buffer(name, b)
+		}
+	}
+
+	if matches == 0 {
+		g.set_status("No matches for %q in %d file(s)", pattern, searched)
+		return
+	}
+
+	b := g.find_buffer_by_name(grep_buffer_name)
+	if b == nil {
+		b = new_empty_buffer()
+		b.name = g.buffer_name(grep_buffer_name)
+		b.locations = true
+		b.readonly = true
+		g.add_buffer(b)
+	}
+	b.reload(&out)
+	g.active.leaf.attach(b)
+	g.set_status("%d match(es) for %q in %d file(s)", matches, pattern, searched)
+}
+
+// Opens the reference under the cursor of the active view, the view must show
+// a buffer of "file:line: text" references.
+func (g *godit) jump_to_location() {
+	v := g.active.leaf
+	name, line_num, ok := parse_location(v.cursor.line.data)
+	if !ok {
+		g.set_status("No file reference on this line")
+		return
+	}
+
+	b := g.find_buffer_by_name(name)
+	if b == nil || b.path != "" {
+		var err error
+		b, err = g.new_buffer_from_file(name)
+		if err != nil {
+			return
+		}
+	}
+	v.attach(b)
+	v.on_vcommand(vcommand_move_cursor_to_line, rune(line_num))
+}
+
+// The name of the buffer in a "file:line: text" reference.
+func (b *buffer) location_name() string {
+	if b.path != "" {
+		return b.path
+	}
+	return b.name
+}
+
+// Splits a "file:line: text" reference.
+func parse_location(data []byte) (name string, line_num int, ok bool) {
+	for i := 0; i < len(data); i++ {
+		if data[i] != ':' {
+			continue
+		}
+		j := i + 1
+		for j < len(data) && data[j] >= '0' && data[j] <= '9' {
+			j++
+		}
+		if j == i+1 || j == len(data) || data[j] != ':' {
+			continue
+		}
+		n, err := strconv.Atoi(string(data[i+1 : j]))
+		if err != nil {
+			continue
+		}
+		return string(data[:i]), n, i > 0
+	}
+	return "", 0, false
+}
+
+// Reads a file into a buffer which is not added to the buffer list.
+func load_buffer(filename string) (*buffer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return new_buffer(f)
+}
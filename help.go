@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+//----------------------------------------------------------------------------
+// help
+//
+// "describe-key" tells which command a key sequence runs, "describe-bindings"
+// lists all of the keymaps in the read-only "*help*" buffer.
+//----------------------------------------------------------------------------
+
+const help_buffer_name = "*help*"
+
+func (g *godit) describe_key() {
+	g.describe_key_in(global_keys, "")
+}
+
+// Reads the next key of a sequence starting with 'prefix' and looks it up in
+// 'm'. Without a prefix the view keymap is consulted as well.
+func (g *godit) describe_key_in(m keymap, prefix string) {
+	prompt := strings.TrimSpace("Describe key: " + prefix)
+	g.set_overlay_mode(init_key_read_mode(g, prompt, func(ev *termbox.Event) {
+		k := key_of(ev)
+		keys := strings.TrimSpace(prefix + " " + k.String())
+		if name, ok := m.lookup(k); ok {
+			if next := prefix_keymap(name); next != nil {
+				g.describe_key_in(next, keys)
+				return
+			}
+			g.set_status("%s runs the command %s", keys, name)
+			return
+		}
+		if prefix == "" {
+			if a, ok := view_keys.lookup(k); ok {
+				g.set_status("%s runs the command %s", keys, a.name)
+				return
+			}
+			if k.mod == 0 && k.ch != 0 {
+				g.set_status("%s runs the command self-insert", keys)
+				return
+			}
+		}
+		g.set_status("%s is undefined", keys)
+	}))
+}
+
+type key_description struct {
+	keys string
+	name string
+}
+
+type key_description_slice []key_description
+
+func (s key_description_slice) Len() int           { return len(s) }
+func (s key_description_slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s key_description_slice) Less(i, j int) bool { return s[i].keys < s[j].keys }
+
+func write_key_descriptions(out *bytes.Buffer, title string, descs key_description_slice) {
+	sort.Sort(descs)
+	fmt.Fprintf(out, "%s\n\n", title)
+	for _, d := range descs {
+		fmt.Fprintf(out, "%-16s %s\n", d.keys, d.name)
+	}
+	out.WriteString("\n")
+}
+
+func describe_keymap(m keymap, prefix string) key_description_slice {
+	descs := make(key_description_slice, 0, len(m))
+	for k, name := range m {
+		descs = append(descs, key_description{prefix + k.String(), name})
+	}
+	return descs
+}
+
+func (g *godit) describe_bindings() {
+	var out bytes.Buffer
+	descs := describe_keymap(sys_keys, "")
+	descs = append(descs, describe_keymap(global_keys, "")...)
+	write_key_descriptions(&out, "Global keys", descs)
+	write_key_descriptions(&out, "C-x keys", describe_keymap(ctl_x_keys, "C-x "))
+	write_key_descriptions(&out, "C-x r keys", describe_keymap(ctl_x_r_keys, "C-x r "))
+	write_key_descriptions(&out, "Toggles", describe_keymap(prefix_keymap("toggle"), "C-x t "))
+
+	descs = make(key_description_slice, 0, len(view_keys))
+	for k, a := range view_keys {
+		descs = append(descs, key_description{k.String(), a.name})
+	}
+	write_key_descriptions(&out, "Editing keys (other characters insert themselves)", descs)
+
+	descs = make(key_description_slice, 0, len(autocompl_keys))
+	for k, a := range autocompl_keys {
+		descs = append(descs, key_description{k.String(), a.name})
+	}
+	write_key_descriptions(&out, "Autocompletion keys", descs)
+
+	b := g.find_buffer_by_name(help_buffer_name)
+	if b == nil {
+		b = new_empty_buffer()
+		b.name = g.buffer_name(help_buffer_name)
+		b.readonly = true
+		g.add_buffer(b)
+	}
+	b.reload(&out)
+	g.active.leaf.attach(b)
+}
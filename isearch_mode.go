@@ -10,32 +10,60 @@ var isearch_last_word = make([]byte, 0, 32)
 
 type isearch_mode struct {
 	*line_edit_mode
+	isearch_state
+
+	// where the search started, C-g goes back there
+	origin view_location
+
+	// the states before each search step, <backspace> goes back to them
+	states []isearch_state
+
+	prompt_isearch []byte
+	prompt_failing []byte
+	prompt_wrapped []byte
+}
+
+type isearch_state struct {
 	last_word []byte
 	last_loc  cursor_location
+	cursor    cursor_location
+	shown     []byte // the prompt
 
 	backward bool
 	failing  bool
 	wrapped  bool
-
-	prompt_isearch []byte
-	prompt_failing []byte
-	prompt_wrapped []byte
 }
 
 func init_isearch_mode(g *godit, backward bool) *isearch_mode {
+	g.clear_search_highlight()
 	v := g.active.leaf
 	m := new(isearch_mode)
 	m.last_word = make([]byte, 0, 32)
 	m.last_loc = v.cursor
+	m.origin = v.view_location
 	m.backward = backward
 	m.prepare_prompts()
-	cancel := func() {
+	clear := func() {
 		v.highlight_bytes = nil
 		v.set_tags()
 		v.dirty = dirty_everything
 	}
+	apply := func(*buffer) {
+		// with the lazy highlight the matches stay highlighted until
+		// the next search or "M-x clear-search-highlight"
+		highlight := v.highlight_bytes
+		clear()
+		if settings.lazy_highlight && !m.failing {
+			v.highlight_bytes = highlight
+		}
+	}
+	cancel := func() {
+		clear()
+		v.view_location = m.origin
+		v.adjust_line_voffset()
+	}
 	m.line_edit_mode = init_line_edit_mode(g, line_edit_mode_params{
-		on_apply:  func(*buffer) { cancel() },
+		on_apply:  apply,
 		on_cancel: cancel,
 		ac_decide: default_ac_decide,
 	})
@@ -69,20 +97,22 @@ func (m *isearch_mode) search(next bool) {
 		cursor cursor_location
 		ok     bool
 	)
+	forward := search_opts{}
+	backward := search_opts{backward: true}
 	if m.backward {
 		if !next {
-			cursor, ok = m.last_loc.search_forward(m.last_word)
+			cursor, ok = v.buf.find(m.last_loc, m.last_word, forward)
 			if !ok || cursor != m.last_loc {
-				cursor, ok = m.last_loc.search_backward(m.last_word)
+				cursor, ok = v.buf.find(m.last_loc, m.last_word, backward)
 			}
 		} else {
-			cursor, ok = m.last_loc.search_backward(m.last_word)
+			cursor, ok = v.buf.find(m.last_loc, m.last_word, backward)
 		}
 	} else {
 		if next && !m.wrapped {
 			m.last_loc.boffset += len(m.last_word)
 		}
-		cursor, ok = m.last_loc.search_forward(m.last_word)
+		cursor, ok = v.buf.find(m.last_loc, m.last_word, forward)
 	}
 	if !ok {
 		v.set_tags()
@@ -91,18 +121,15 @@ func (m *isearch_mode) search(next bool) {
 		m.wrapped = false
 	} else {
 		m.last_loc = cursor
-		v.set_tags(view_tag{
-			beg_line:   cursor.line_num,
-			beg_offset: cursor.boffset,
-			end_line:   cursor.line_num,
-			end_offset: cursor.boffset + len(m.last_word),
-			fg:         termbox.ColorCyan,
-			bg:         termbox.ColorMagenta,
-		})
+		m.tag_match(v, cursor)
 		if !m.backward {
 			cursor.boffset += len(m.last_word)
 		}
+		recenter := settings.isearch_recenter && !v.line_is_visible(cursor.line_num)
 		v.move_cursor_to(cursor)
+		if recenter {
+			v.center_view_on_cursor()
+		}
 		if m.wrapped {
 			m.set_prompt(m.prompt_wrapped)
 			m.wrapped = false
@@ -111,11 +138,21 @@ func (m *isearch_mode) search(next bool) {
 		}
 		m.failing = false
 	}
-	v.center_view_on_cursor()
 	v.dirty = dirty_everything
 	v.highlight_bytes = m.last_word
 }
 
+func (m *isearch_mode) tag_match(v *view, match cursor_location) {
+	v.set_tags(view_tag{
+		beg_line:   match.line_num,
+		beg_offset: match.boffset,
+		end_line:   match.line_num,
+		end_offset: match.boffset + len(m.last_word),
+		fg:         termbox.ColorCyan,
+		bg:         termbox.ColorMagenta,
+	})
+}
+
 func (m *isearch_mode) restore_previous_isearch_maybe() {
 	lw := m.godit.isearch_last_word
 	if len(lw) == 0 {
@@ -162,6 +199,7 @@ func (m *isearch_mode) advance_search() {
 }
 
 func (m *isearch_mode) on_key(ev *termbox.Event) {
+	state := m.save_state()
 	switch ev.Key {
 	case termbox.KeyCtrlR:
 		if !m.backward {
@@ -169,15 +207,24 @@ func (m *isearch_mode) on_key(ev *termbox.Event) {
 			m.prepare_prompts()
 		}
 		m.advance_search()
+		m.states = append(m.states, state)
+		return
 	case termbox.KeyCtrlS:
 		if m.backward {
 			m.backward = false
 			m.prepare_prompts()
 		}
 		m.advance_search()
-	default:
-		m.line_edit_mode.on_key(ev)
+		m.states = append(m.states, state)
+		return
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if ev.Mod == 0 && len(m.states) > 0 {
+			m.restore_state(m.states[len(m.states)-1])
+			m.states = m.states[:len(m.states)-1]
+			return
+		}
 	}
+	m.line_edit_mode.on_key(ev)
 
 	new_word := m.linebuf.first_line.data
 	if bytes.Equal(new_word, m.last_word) {
@@ -186,4 +233,48 @@ func (m *isearch_mode) on_key(ev *termbox.Event) {
 	m.last_word = copy_byte_slice(m.last_word, new_word)
 	m.godit.isearch_last_word = copy_byte_slice(m.godit.isearch_last_word, new_word)
 	m.search(false)
+	m.states = append(m.states, state)
+}
+
+func (m *isearch_mode) save_state() isearch_state {
+	s := m.isearch_state
+	s.last_word = clone_byte_slice(m.last_word)
+	s.cursor = m.godit.active.leaf.cursor
+	s.shown = m.prompt
+	return s
+}
+
+// Goes back to the state before a search step: the query, the match and the
+// direction.
+func (m *isearch_mode) restore_state(s isearch_state) {
+	m.isearch_state = s
+	m.prepare_prompts()
+	m.set_prompt(s.shown)
+
+	// the query in the prompt
+	lv := m.lineview
+	c := cursor_location{m.linebuf.first_line, 1, 0}
+	if !bytes.Equal(c.line.data, s.last_word) {
+		if n := len(c.line.data); n > 0 {
+			lv.action_delete(c, n)
+		}
+		if len(s.last_word) > 0 {
+			lv.action_insert(c, clone_byte_slice(s.last_word))
+		}
+		lv.finalize_action_group()
+	}
+	c.boffset = len(s.last_word)
+	lv.move_cursor_to(c)
+	lv.dirty = dirty_everything
+	m.godit.isearch_last_word = copy_byte_slice(m.godit.isearch_last_word, s.last_word)
+
+	// the match in the view
+	v := m.godit.active.leaf
+	v.set_tags()
+	v.highlight_bytes = s.last_word
+	if len(s.last_word) > 0 && !s.failing {
+		m.tag_match(v, s.last_loc)
+	}
+	v.move_cursor_to(s.cursor)
+	v.dirty = dirty_everything
 }
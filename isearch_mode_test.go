@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"github.com/nsf/tulib"
+	"strings"
+	"testing"
+)
+
+func new_test_godit(t *testing.T, contents string) *godit {
+	g := new_godit(nil)
+	g.uibuf = tulib.NewBuffer(80, 24)
+	v := g.active.leaf
+	if err := v.buf.reload(strings.NewReader(contents)); err != nil {
+		t.Fatal(err)
+	}
+	v.resize(80, 23)
+	return g
+}
+
+func send_keys(g *godit, evs ...termbox.Event) {
+	for i := range evs {
+		evs[i].Type = termbox.EventKey
+		g.handle_event(&evs[i])
+	}
+}
+
+func type_text(g *godit, s string) {
+	for _, r := range s {
+		send_keys(g, termbox.Event{Ch: r})
+	}
+}
+
+func TestIsearchBackspaceAndCancel(t *testing.T) {
+	g := new_test_godit(t, "xx foo\nfoo bar\nfoobar")
+	v := g.active.leaf
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	origin := v.cursor
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlS})
+	type_text(g, "foo")
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlS})
+	if v.cursor.line_num != 3 || v.cursor.boffset != 3 {
+		t.Errorf("second match: cursor at %d:%d, want 3:3", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	// back to the first match, then to a shorter query
+	send_keys(g, termbox.Event{Key: termbox.KeyBackspace2})
+	if v.cursor.line_num != 2 || v.cursor.boffset != 3 {
+		t.Errorf("after <backspace>: cursor at %d:%d, want 2:3", v.cursor.line_num, v.cursor.boffset)
+	}
+	send_keys(g, termbox.Event{Key: termbox.KeyBackspace2})
+	m := g.overlay.(*isearch_mode)
+	if got := string(m.linebuf.contents()); got != "fo" {
+		t.Errorf("query after two <backspace>: got %q, want \"fo\"", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 2 {
+		t.Errorf("after two <backspace>: cursor at %d:%d, want 2:2", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlG})
+	if g.overlay != nil {
+		t.Errorf("C-g didn't leave isearch")
+	}
+	if v.cursor != origin {
+		t.Errorf("C-g: cursor at %d:%d, want the origin %d:%d",
+			v.cursor.line_num, v.cursor.boffset, origin.line_num, origin.boffset)
+	}
+}
+
+func TestIsearchEnterKeepsMatch(t *testing.T) {
+	g := new_test_godit(t, "one two three")
+	v := g.active.leaf
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlS})
+	type_text(g, "two")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if g.overlay != nil {
+		t.Errorf("<enter> didn't leave isearch")
+	}
+	if v.cursor.boffset != 7 {
+		t.Errorf("cursor at %d, want 7", v.cursor.boffset)
+	}
+}
+
+func TestIsearchLazyHighlight(t *testing.T) {
+	saved := settings.lazy_highlight
+	defer func() { settings.lazy_highlight = saved }()
+	g := new_test_godit(t, "one two three two three")
+	v := g.active.leaf
+	search := func(word string) {
+		send_keys(g, termbox.Event{Key: termbox.KeyCtrlS})
+		type_text(g, word)
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	}
+
+	settings.lazy_highlight = false
+	search("two")
+	if v.highlight_bytes != nil {
+		t.Errorf("without lazy highlight: %q stays highlighted", v.highlight_bytes)
+	}
+
+	settings.lazy_highlight = true
+	search("two")
+	if got := string(v.highlight_bytes); got != "two" {
+		t.Errorf("after <enter>: highlight %q, want \"two\"", got)
+	}
+
+	// a new search starts without the old highlight
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlS})
+	if v.highlight_bytes != nil {
+		t.Errorf("new isearch: highlight %q", v.highlight_bytes)
+	}
+	type_text(g, "thr")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if got := string(v.highlight_bytes); got != "thr" {
+		t.Errorf("after the new search: highlight %q, want \"thr\"", got)
+	}
+
+	g.run_command("clear-search-highlight", 0)
+	if v.highlight_bytes != nil {
+		t.Errorf("clear-search-highlight left %q", v.highlight_bytes)
+	}
+}
@@ -35,7 +35,10 @@ func (k *key_press_mode) on_key(ev *termbox.Event) {
 	action, ok := k.actions[ch]
 	if ok {
 		action()
-		k.godit.set_overlay_mode(nil)
+		// the action may have switched to another overlay mode
+		if k.godit.overlay == overlay_mode(k) {
+			k.godit.set_overlay_mode(nil)
+		}
 	} else {
 		k.godit.set_status(k.prompt)
 	}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+//----------------------------------------------------------------------------
+// key read mode
+//
+// Shows a prompt and passes the next key event to a function, whatever key it
+// is.
+//----------------------------------------------------------------------------
+
+type key_read_mode struct {
+	stub_overlay_mode
+	godit   *godit
+	on_read func(ev *termbox.Event)
+}
+
+func init_key_read_mode(godit *godit, prompt string, on_read func(ev *termbox.Event)) *key_read_mode {
+	k := new(key_read_mode)
+	k.godit = godit
+	k.on_read = on_read
+	k.godit.set_status(prompt)
+	return k
+}
+
+func (k *key_read_mode) on_key(ev *termbox.Event) {
+	// reset overlay mode earlier so that 'on_read' can override it
+	k.godit.set_overlay_mode(nil)
+	k.on_read(ev)
+}
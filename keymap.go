@@ -0,0 +1,393 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"github.com/nsf/tulib"
+	"strings"
+)
+
+//----------------------------------------------------------------------------
+// keymaps
+//
+// Key bindings are kept in tables, so that they can be looked up and listed
+// ("describe-key" and "describe-bindings"). The global and the "C-x" keymaps
+// bind keys to command names (see commands.go). The view keymap binds keys to
+// view actions instead, because it's used by the one-line views of prompts as
+// well, where there is no godit command to run.
+//----------------------------------------------------------------------------
+
+type key_binding struct {
+	mod termbox.Modifier // only termbox.ModAlt or 0
+	key termbox.Key      // used when 'ch' is 0
+	ch  rune
+}
+
+func key_of(ev *termbox.Event) key_binding {
+	k := key_binding{mod: ev.Mod & termbox.ModAlt}
+	if ev.Ch != 0 {
+		k.ch = ev.Ch
+	} else {
+		k.key = ev.Key
+	}
+	return k
+}
+
+func key(key termbox.Key) key_binding     { return key_binding{key: key} }
+func alt_key(key termbox.Key) key_binding { return key_binding{mod: termbox.ModAlt, key: key} }
+func char(ch rune) key_binding            { return key_binding{ch: ch} }
+func alt_char(ch rune) key_binding        { return key_binding{mod: termbox.ModAlt, ch: ch} }
+func (k key_binding) String() string      { return tulib.KeyToString(k.key, k.ch, k.mod) }
+func (k key_binding) without_alt() key_binding {
+	k.mod = 0
+	return k
+}
+
+// Alt doesn't matter for special keys unless there is a separate binding for
+// it, e.g. "M-<right>" is just "<right>", while "M-<backspace>" is not.
+func (k key_binding) fallback() (key_binding, bool) {
+	return k.without_alt(), k.mod != 0 && k.ch == 0
+}
+
+//----------------------------------------------------------------------------
+// command keymaps
+//----------------------------------------------------------------------------
+
+type keymap map[key_binding]string
+
+func (m keymap) lookup(k key_binding) (string, bool) {
+	if name, ok := m[k]; ok {
+		return name, true
+	}
+	if k, ok := k.fallback(); ok {
+		name, ok := m[k]
+		return name, ok
+	}
+	return "", false
+}
+
+// Keys handled before anything else, even when an overlay mode is active.
+var sys_keys = keymap{
+	key(termbox.KeyCtrlG): "keyboard-quit",
+	key(termbox.KeyCtrlZ): "suspend",
+}
+
+var global_keys = keymap{
+	key(termbox.KeyCtrlX): "ctl-x-prefix",
+	key(termbox.KeyCtrlS): "isearch-forward",
+	key(termbox.KeyCtrlR): "isearch-backward",
+	alt_char('g'):         "goto-line",
+	alt_char('/'):         "local-complete",
+	alt_char('q'):         "fill-paragraph",
+	alt_char('%'):         "query-replace",
+	alt_char('x'):         "execute-command",
+	alt_char('!'):         "shell-command-insert",
+	key(termbox.KeyCtrlU): "universal-argument",
+	key(termbox.KeyCtrlQ): "quoted-insert",
+}
+
+var ctl_x_keys = keymap{
+	key(termbox.KeyCtrlC):         "quit",
+	key(termbox.KeyCtrlX):         "exchange-point-and-mark",
+	key(termbox.KeyCtrlW):         "view-operations",
+	key(termbox.KeyCtrlA):         "complete",
+	key(termbox.KeyCtrlU):         "upcase-region",
+	key(termbox.KeyCtrlL):         "downcase-region",
+	key(termbox.KeyCtrlF):         "find-file",
+	key(termbox.KeyCtrlB):         "switch-to-previous-buffer",
+	key(termbox.KeyCtrlS):         "save-buffer",
+	key(termbox.KeyCtrlSlash):     "redo",
+	alt_key(termbox.KeyCtrlSlash): "clear-undo-history",
+	key(termbox.KeyCtrlR):         "search-and-replace",
+	key(termbox.KeyCtrlO):         "delete-blank-lines",
+	key(termbox.KeyCtrlD):         "duplicate-line",
+	key(termbox.KeyCtrlQ):         "toggle-read-only",
+	char('0'):                     "delete-window",
+	char('1'):                     "delete-other-windows",
+	char('2'):                     "split-window-vertically",
+	char('3'):                     "split-window-horizontally",
+	char('o'):                     "other-window",
+	char('+'):                     "balance-windows",
+	char('^'):                     "enlarge-window",
+	char('-'):                     "shrink-window",
+	char('}'):                     "enlarge-window-horizontally",
+	char('{'):                     "shrink-window-horizontally",
+	char('M'):                     "toggle-maximize-window",
+	char('b'):                     "switch-buffer",
+	char('t'):                     "toggle",
+	char('g'):                     "grep",
+	char('w'):                     "write-region",
+	char('f'):                     "set-fill-column",
+	char('a'):                     "append-to-file",
+	char('('):                     "start-kbd-macro",
+	char(')'):                     "end-kbd-macro",
+	char('e'):                     "call-last-kbd-macro",
+	char('z'):                     "repeat",
+	char('>'):                     "indent-region",
+	char('<'):                     "deindent-region",
+	char('k'):                     "kill-buffer",
+	alt_char('k'):                 "reopen-killed-buffer",
+	char('S'):                     "save-buffer-raw",
+	alt_char('S'):                 "write-file-raw",
+	char('s'):                     "save-some-buffers",
+	alt_char('s'):                 "write-file",
+	char('R'):                     "revert-all-buffers",
+	char(']'):                     "next-changed-line",
+	char('['):                     "previous-changed-line",
+	char('='):                     "describe-char",
+	char('!'):                     "filter-region",
+	char('?'):                     "describe-key",
+	char('h'):                     "describe-bindings",
+	char('r'):                     "ctl-x-r-prefix",
+}
+
+var ctl_x_r_keys = keymap{
+	char('s'):             "copy-to-register",
+	char('x'):             "copy-to-register",
+	char('i'):             "insert-register",
+	char('g'):             "insert-register",
+	key(termbox.KeySpace): "point-to-register",
+	char('j'):             "jump-to-register",
+	char('k'):             "kill-rectangle",
+	char('y'):             "yank-rectangle",
+}
+
+// Returns the keymap of the next key for commands which are prefixes, nil for
+// the rest.
+func prefix_keymap(name string) keymap {
+	switch name {
+	case "ctl-x-prefix":
+		return ctl_x_keys
+	case "ctl-x-r-prefix":
+		return ctl_x_r_keys
+	case "toggle":
+		m := make(keymap, len(toggles))
+		for _, t := range toggles {
+			m[char(t.key)] = t.command
+		}
+		return m
+	}
+	return nil
+}
+
+//----------------------------------------------------------------------------
+// view keymap
+//
+// While autocompletion is active 'autocompl_keys' take precedence over
+// 'view_keys'.
+//----------------------------------------------------------------------------
+
+type view_action struct {
+	name string
+	do   func(v *view)
+}
+
+func vcommand_action(name string, cmd vcommand, arg rune) view_action {
+	return view_action{name, func(v *view) {
+		v.on_vcommand(cmd, arg)
+	}}
+}
+
+type view_keymap map[key_binding]view_action
+
+func (m view_keymap) lookup(k key_binding) (view_action, bool) {
+	if a, ok := m[k]; ok {
+		return a, true
+	}
+	if k, ok := k.fallback(); ok {
+		a, ok := m[k]
+		return a, ok
+	}
+	return view_action{}, false
+}
+
+var (
+	forward_char         = vcommand_action("forward-char", vcommand_move_cursor_forward, 0)
+	backward_char        = vcommand_action("backward-char", vcommand_move_cursor_backward, 0)
+	next_line            = vcommand_action("next-line", vcommand_move_cursor_next_line, 0)
+	previous_line        = vcommand_action("previous-line", vcommand_move_cursor_prev_line, 0)
+	end_of_line          = vcommand_action("end-of-line", vcommand_move_cursor_end_of_line, 0)
+	beginning_of_line    = vcommand_action("beginning-of-line", vcommand_move_cursor_beginning_of_line, 0)
+	scroll_up            = vcommand_action("scroll-up", vcommand_move_view_page_forward, 0)
+	scroll_down          = vcommand_action("scroll-down", vcommand_move_view_page_backward, 0)
+	delete_backward_char = vcommand_action("delete-backward-char", vcommand_delete_rune_backward, 0)
+	delete_char          = vcommand_action("delete-char", vcommand_delete_rune, 0)
+	backward_kill_word   = vcommand_action("backward-kill-word", vcommand_kill_word_backward, 0)
+)
+
+var view_keys = view_keymap{
+	key(termbox.KeyCtrlF):              forward_char,
+	key(termbox.KeyArrowRight):         forward_char,
+	key(termbox.KeyCtrlB):              backward_char,
+	key(termbox.KeyArrowLeft):          backward_char,
+	key(termbox.KeyCtrlN):              next_line,
+	key(termbox.KeyArrowDown):          next_line,
+	key(termbox.KeyCtrlP):              previous_line,
+	key(termbox.KeyArrowUp):            previous_line,
+	alt_key(termbox.KeyArrowUp):        vcommand_action("move-line-up", vcommand_move_line_up, 0),
+	alt_key(termbox.KeyArrowDown):      vcommand_action("move-line-down", vcommand_move_line_down, 0),
+	key(termbox.KeyCtrlE):              end_of_line,
+	key(termbox.KeyEnd):                end_of_line,
+	key(termbox.KeyCtrlA):              beginning_of_line,
+	key(termbox.KeyHome):               beginning_of_line,
+	key(termbox.KeyCtrlV):              scroll_up,
+	key(termbox.KeyPgdn):               scroll_up,
+	key(termbox.KeyPgup):               scroll_down,
+	alt_char('v'):                      scroll_down,
+	key(termbox.KeyCtrlL):              vcommand_action("recenter", vcommand_recenter, 0),
+	alt_char('<'):                      vcommand_action("beginning-of-buffer", vcommand_move_cursor_beginning_of_file, 0),
+	alt_char('>'):                      vcommand_action("end-of-buffer", vcommand_move_cursor_end_of_file, 0),
+	alt_char('f'):                      vcommand_action("forward-word", vcommand_move_cursor_word_forward, 0),
+	alt_char('b'):                      vcommand_action("backward-word", vcommand_move_cursor_word_backward, 0),
+	key(termbox.KeyCtrlSpace):          vcommand_action("set-mark", vcommand_set_mark, 0),
+	key(termbox.KeyCtrlSlash):          vcommand_action("undo", vcommand_undo, 0),
+	key(termbox.KeySpace):              vcommand_action("self-insert", vcommand_insert_rune, ' '),
+	key(termbox.KeyTab):                {"indent-or-complete", (*view).on_tab},
+	key(termbox.KeyEnter):              vcommand_action("newline", vcommand_insert_rune, '\r'), // '\r' autoindents only with 'auto_indent'
+	key(termbox.KeyCtrlJ):              vcommand_action("newline-and-indent", vcommand_insert_rune, '\n'),
+	key(termbox.KeyCtrlO):              vcommand_action("open-line", vcommand_open_line, 0),
+	key(termbox.KeyBackspace):          delete_backward_char,
+	key(termbox.KeyBackspace2):         delete_backward_char,
+	alt_key(termbox.KeyBackspace):      backward_kill_word,
+	alt_key(termbox.KeyBackspace2):     backward_kill_word,
+	key(termbox.KeyDelete):             delete_char,
+	key(termbox.KeyCtrlD):              delete_char,
+	key(termbox.KeyCtrlK):              vcommand_action("kill-line", vcommand_kill_line, 0),
+	alt_char('k'):                      vcommand_action("kill-whole-line", vcommand_kill_whole_line, 0),
+	alt_char('d'):                      vcommand_action("kill-word", vcommand_kill_word, 0),
+	key(termbox.KeyCtrlW):              vcommand_action("kill-region", vcommand_kill_region, 0),
+	alt_char('w'):                      vcommand_action("copy-region", vcommand_copy_region, 0),
+	key(termbox.KeyCtrlY):              vcommand_action("yank", vcommand_yank, 0),
+	alt_char('y'):                      vcommand_action("yank-pop", vcommand_yank_pop, 0),
+	alt_char('u'):                      vcommand_action("upcase-word", vcommand_word_to_upper, 0),
+	alt_char('l'):                      vcommand_action("downcase-word", vcommand_word_to_lower, 0),
+	alt_char('c'):                      vcommand_action("capitalize-word", vcommand_word_to_title, 0),
+	key(termbox.KeyCtrlT):              vcommand_action("transpose-chars", vcommand_transpose_chars, 0),
+	alt_char('^'):                      vcommand_action("join-line", vcommand_join_line, 0),
+	alt_char(';'):                      vcommand_action("toggle-comment-line", vcommand_toggle_comment_line, 0),
+	alt_key(termbox.KeyCtrlBackslash):  vcommand_action("reindent-region", vcommand_reindent_region, 0),
+	alt_key(termbox.KeyCtrlRsqBracket): vcommand_action("goto-matching-bracket", vcommand_move_cursor_matching_bracket, 0),
+}
+
+var autocompl_keys = view_keymap{
+	key(termbox.KeyCtrlN):     vcommand_action("autocompl-next", vcommand_autocompl_move_cursor_down, 0),
+	key(termbox.KeyArrowDown): vcommand_action("autocompl-next", vcommand_autocompl_move_cursor_down, 0),
+	key(termbox.KeyCtrlP):     vcommand_action("autocompl-previous", vcommand_autocompl_move_cursor_up, 0),
+	key(termbox.KeyArrowUp):   vcommand_action("autocompl-previous", vcommand_autocompl_move_cursor_up, 0),
+	key(termbox.KeyEnter):     vcommand_action("autocompl-accept", vcommand_autocompl_finalize, 0),
+	key(termbox.KeyCtrlJ):     vcommand_action("autocompl-accept", vcommand_autocompl_finalize, 0),
+}
+
+// The view action called 'name' bound to some key, false if there is none.
+func find_view_action(name string) (view_action, bool) {
+	for _, a := range view_keys {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return view_action{}, false
+}
+
+//----------------------------------------------------------------------------
+// rebinding
+//
+// "M-x global-set-key" binds a key (or a key sequence of a prefix keymap) to
+// a command until godit exits.
+//----------------------------------------------------------------------------
+
+// Binds 'k' to the view action 'name' if there is one (so that it works in
+// prompts as well), otherwise to the command 'name' in the global keymap. The
+// key is removed from the other keymap, so that nothing shadows the new
+// binding. Returns false if there is no such action or command.
+func set_key(k key_binding, name string) bool {
+	if a, ok := find_view_action(name); ok {
+		view_keys[k] = a
+		delete(global_keys, k)
+		return true
+	}
+	if _, ok := find_command(name); !ok {
+		return false
+	}
+	global_keys[k] = name
+	delete(view_keys, k)
+	return true
+}
+
+// The view actions of the keys pasted text is made of (see 'paste_byte'),
+// character keys insert themselves with no binding at all.
+var paste_key_actions = map[key_binding]string{
+	key(termbox.KeySpace): "self-insert",
+	key(termbox.KeyTab):   "indent-or-complete",
+	key(termbox.KeyEnter): "newline",
+	key(termbox.KeyCtrlJ): "newline-and-indent",
+}
+
+// Reports whether any of the keys pasted text is made of was rebound, then
+// inserting the text as it is would bypass the binding.
+func paste_keys_rebound() bool {
+	for k := range global_keys {
+		if _, ok := paste_key_actions[k]; ok || (k.mod == 0 && k.ch != 0) {
+			return true
+		}
+	}
+	for k := range view_keys {
+		if k.mod == 0 && k.ch != 0 {
+			return true
+		}
+	}
+	for k, name := range paste_key_actions {
+		if a, ok := view_keys[k]; !ok || a.name != name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *godit) global_set_key() {
+	g.global_set_key_in(nil, "")
+}
+
+// Reads the next key of a sequence starting with 'prefix', the keys of prefix
+// commands in 'm' continue the sequence. A nil 'm' stands for the global and
+// the view keymaps.
+func (g *godit) global_set_key_in(m keymap, prefix string) {
+	prompt := strings.TrimSpace("Set key: " + prefix)
+	g.set_overlay_mode(init_key_read_mode(g, prompt, func(ev *termbox.Event) {
+		k := key_of(ev)
+		keys := strings.TrimSpace(prefix + " " + k.String())
+		lookup := m
+		if lookup == nil {
+			lookup = global_keys
+		}
+		if name, ok := lookup.lookup(k); ok {
+			if next := prefix_keymap(name); next != nil {
+				g.global_set_key_in(next, keys)
+				return
+			}
+		}
+		g.set_overlay_mode(init_line_edit_mode(g, g.set_key_lemp(m, k, keys)))
+	}))
+}
+
+// "lemp" stands for "line edit mode params"
+func (g *godit) set_key_lemp(m keymap, k key_binding, keys string) line_edit_mode_params {
+	return line_edit_mode_params{
+		ac_decide:      command_ac_decide,
+		prompt:         "Set key " + keys + " to command:",
+		init_autocompl: true,
+
+		on_apply: func(buf *buffer) {
+			name := strings.TrimSpace(string(buf.contents()))
+			ok := false
+			if m == nil {
+				ok = set_key(k, name)
+			} else if _, ok = find_command(name); ok {
+				m[k] = name
+			}
+			if !ok {
+				g.set_status("No command named %q", name)
+				return
+			}
+			g.set_status("%s runs the command %s", keys, name)
+		},
+	}
+}
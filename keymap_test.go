@@ -0,0 +1,76 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"testing"
+)
+
+func TestKeymapsBindExistingCommands(t *testing.T) {
+	maps := map[string]keymap{
+		"sys":    sys_keys,
+		"global": global_keys,
+		"C-x":    ctl_x_keys,
+		"C-x t":  prefix_keymap("toggle"),
+	}
+	for mname, m := range maps {
+		for k, name := range m {
+			if _, ok := find_command(name); !ok {
+				t.Errorf("%s keymap: %s is bound to unknown command %q", mname, k, name)
+			}
+		}
+	}
+}
+
+func TestGlobalSetKey(t *testing.T) {
+	restore := func(m keymap, k key_binding) func() {
+		name, ok := m[k]
+		return func() {
+			delete(m, k)
+			if ok {
+				m[k] = name
+			}
+		}
+	}
+	ctl_v := key(termbox.KeyCtrlV)
+	scroll := view_keys[ctl_v]
+	defer func() { view_keys[ctl_v] = scroll }()
+	defer restore(global_keys, ctl_v)()
+	defer restore(ctl_x_keys, key(termbox.KeyCtrlD))()
+
+	g := new_test_godit(t, "one\ntwo")
+	v := g.active.leaf
+
+	// a view action
+	g.run_command("global-set-key", 0)
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlV})
+	type_text(g, "end-of-line")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlV})
+	if v.cursor.boffset != 3 {
+		t.Errorf("C-v as end-of-line: cursor at %d, want 3", v.cursor.boffset)
+	}
+
+	// a command of the global keymap replaces the view binding
+	if !set_key(ctl_v, "revert-buffer") {
+		t.Fatalf("set_key failed for revert-buffer")
+	}
+	if _, ok := view_keys[ctl_v]; ok || global_keys[ctl_v] != "revert-buffer" {
+		t.Errorf("C-v is bound to %q in the global keymap", global_keys[ctl_v])
+	}
+	if set_key(ctl_v, "no-such-command") {
+		t.Errorf("set_key succeeded for an unknown command")
+	}
+
+	// a key sequence of the C-x keymap
+	g.run_command("global-set-key", 0)
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlD})
+	type_text(g, "join-line")
+	send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	if got := ctl_x_keys[key(termbox.KeyCtrlD)]; got != "join-line" {
+		t.Errorf("C-x C-d is bound to %q", got)
+	}
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlX}, termbox.Event{Key: termbox.KeyCtrlD})
+	if got := string(v.buf.contents()); got != "one two" {
+		t.Errorf("C-x C-d as join-line: got %q", got)
+	}
+}
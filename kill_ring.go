@@ -0,0 +1,47 @@
+package main
+
+//----------------------------------------------------------------------------
+// kill ring
+//
+// Killed and copied text, shared by all the views. Consecutive kills add to
+// the same entry, anything else in between starts a new one. The oldest
+// entries are dropped when there are more than 'kill_ring_max' of them.
+//----------------------------------------------------------------------------
+
+const kill_ring_max = 60
+
+type kill_ring struct {
+	entries [][]byte // the most recent one is the last
+	yanked  int      // the entry inserted by the last yank
+}
+
+// Starts a new entry with 'data'.
+func (k *kill_ring) push(data []byte) {
+	if len(k.entries) == kill_ring_max {
+		copy(k.entries, k.entries[1:])
+		k.entries = k.entries[:len(k.entries)-1]
+	}
+	k.entries = append(k.entries, data)
+}
+
+// Adds 'data' to the end (or to the beginning) of the most recent entry.
+func (k *kill_ring) add(data []byte, prepend bool) {
+	if len(k.entries) == 0 {
+		k.push(data)
+		return
+	}
+	last := &k.entries[len(k.entries)-1]
+	if prepend {
+		*last = append(data, *last...)
+	} else {
+		*last = append(*last, data...)
+	}
+}
+
+// The most recent entry, nil if the ring is empty.
+func (k *kill_ring) latest() []byte {
+	if len(k.entries) == 0 {
+		return nil
+	}
+	return k.entries[len(k.entries)-1]
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKillRing(t *testing.T) {
+	v := new_test_view(t, "one two\nthree", 40, 10)
+	k := v.ctx.kill_ring
+
+	// consecutive kills make one entry
+	v.on_vcommand(vcommand_kill_word, 0)
+	v.on_vcommand(vcommand_kill_word, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_kill_line, 0)
+	if len(k.entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(k.entries))
+	}
+	if got := string(k.entries[0]); got != "one two" {
+		t.Errorf("first entry: got %q", got)
+	}
+	if got := string(k.latest()); got != "\n" {
+		t.Errorf("latest entry: got %q", got)
+	}
+
+	// copying doesn't touch the buffer
+	history := v.buf.history
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_copy_region, 0)
+	if got := string(k.latest()); got != "three" || len(k.entries) != 3 {
+		t.Errorf("copy: got %q in %d entries", got, len(k.entries))
+	}
+	if got := string(v.buf.contents()); got != "three" || v.buf.history != history {
+		t.Errorf("copy changed the buffer: %q", got)
+	}
+
+	for i := 0; i < kill_ring_max; i++ {
+		k.push([]byte(fmt.Sprint(i)))
+	}
+	if len(k.entries) != kill_ring_max || string(k.entries[0]) != "0" {
+		t.Errorf("full ring: %d entries, the oldest is %q", len(k.entries), k.entries[0])
+	}
+}
+
+func TestViewYankPop(t *testing.T) {
+	v := new_test_view(t, "a b c", 40, 10)
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_kill_word, 0)
+		v.on_vcommand(vcommand_move_cursor_forward, 0)
+	}
+
+	v.on_vcommand(vcommand_yank_pop, 0)
+	if got := string(v.buf.contents()); got != "  " {
+		t.Errorf("yank-pop without a yank changed the buffer: %q", got)
+	}
+
+	v.on_vcommand(vcommand_yank, 0)
+	want := []string{"  c", "  b", "  a", "  c"}
+	for i, w := range want {
+		if i > 0 {
+			v.on_vcommand(vcommand_yank_pop, 0)
+		}
+		if got := string(v.buf.contents()); got != w {
+			t.Errorf("yank %d: got %q, want %q", i, got, w)
+		}
+		if v.cursor.boffset != 3 {
+			t.Errorf("yank %d: cursor at %d, want 3", i, v.cursor.boffset)
+		}
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "  " {
+		t.Errorf("undo: got %q", got)
+	}
+}
+
+func TestViewKillWordBackward(t *testing.T) {
+	v := new_test_view(t, "one two", 40, 10)
+	status := capture_status(v)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+
+	// backward kills go before the text killed by the previous ones
+	v.on_vcommand(vcommand_kill_word_backward, 0)
+	v.on_vcommand(vcommand_kill_word_backward, 0)
+	if got := string(v.ctx.kill_ring.latest()); got != "one two" || len(v.ctx.kill_ring.entries) != 1 {
+		t.Errorf("got %q in %d entries", got, len(v.ctx.kill_ring.entries))
+	}
+	if v.buf.lines_n != 1 || len(v.buf.first_line.data) != 0 {
+		t.Errorf("buffer: %q", v.buf.contents())
+	}
+
+	v.on_vcommand(vcommand_kill_word_backward, 0)
+	if *status != "Beginning of buffer" {
+		t.Errorf("at the beginning: status %q", *status)
+	}
+	if len(v.ctx.kill_ring.entries) != 1 {
+		t.Errorf("at the beginning: %d entries", len(v.ctx.kill_ring.entries))
+	}
+}
@@ -23,7 +23,7 @@ type line_edit_mode struct {
 
 type line_edit_mode_params struct {
 	on_apply        func(buffer *buffer)
-	on_cancel       func()
+	on_cancel       func() // the mode is left without applying, e.g. C-g
 	ac_decide       ac_decide_func
 	prompt          string
 	initial_content string
@@ -47,7 +47,8 @@ func (l *line_edit_mode) on_key(ev *termbox.Event) {
 		}
 
 		// reset overlay mode earlier so that 'on_apply' can
-		// override it
+		// override it, leaving the mode this way isn't a cancel
+		l.on_cancel = nil
 		l.godit.set_overlay_mode(nil)
 		if l.on_apply != nil {
 			l.on_apply(l.linebuf)
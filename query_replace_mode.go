@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+//----------------------------------------------------------------------------
+// query replace mode
+//
+// Stops at each match after the cursor and asks what to do with it: 'y' (or
+// SPC) replaces it, 'n' (or DEL) skips it, '!' replaces the rest without
+// asking and 'q' (or RET) stops. Every replacement can be undone on its own,
+// the ones made by '!' all at once.
+//----------------------------------------------------------------------------
+
+type query_replace_mode struct {
+	stub_overlay_mode
+	godit    *godit
+	word     []byte
+	repl     []byte
+	match    cursor_location
+	replaced int
+	prompt   string
+}
+
+func init_query_replace_mode(godit *godit, word, repl []byte) *query_replace_mode {
+	v := godit.active.leaf
+	if !v.check_writable() {
+		return nil
+	}
+	q := new(query_replace_mode)
+	q.godit = godit
+	q.word = word
+	q.repl = repl
+	q.prompt = "Query replacing " + string(word) + " with " + string(repl) +
+		": (y, n, !, q)"
+	v.finalize_action_group()
+	v.last_vcommand = vcommand_none
+	if !q.find(v.cursor) {
+		godit.set_status("No matches for %s", word)
+		return nil
+	}
+	return q
+}
+
+// Moves to the first match at 'from' or after it, false if there is none.
+func (q *query_replace_mode) find(from cursor_location) bool {
+	g := q.godit
+	v := g.active.leaf
+	match, ok := v.buf.find(from, q.word, search_opts{})
+	if !ok {
+		return false
+	}
+	q.match = match
+	v.set_tags(view_tag{
+		beg_line:   match.line_num,
+		beg_offset: match.boffset,
+		end_line:   match.line_num,
+		end_offset: match.boffset + len(q.word),
+		fg:         termbox.ColorCyan,
+		bg:         termbox.ColorMagenta,
+	})
+	match.boffset += len(q.word)
+	v.move_cursor_to(match)
+	v.dirty = dirty_everything
+	g.set_status(q.prompt)
+	return true
+}
+
+// Moves to the next match, the mode ends if there is none.
+func (q *query_replace_mode) next(from cursor_location) {
+	if !q.find(from) {
+		q.godit.set_overlay_mode(nil)
+	}
+}
+
+// Replaces the current match, the cursor goes after the replacement.
+func (q *query_replace_mode) replace() cursor_location {
+	v := q.godit.active.leaf
+	c := q.match
+	v.action_delete(c, len(q.word))
+	v.action_insert(c, clone_byte_slice(q.repl))
+	v.finalize_action_group()
+	q.replaced++
+	c.boffset += len(q.repl)
+	v.move_cursor_to(c)
+	return c
+}
+
+func (q *query_replace_mode) exit() {
+	v := q.godit.active.leaf
+	v.set_tags()
+	v.buf.mark_active = false
+	v.dirty = dirty_everything
+	q.godit.set_status("Replaced %d occurrence(s)", q.replaced)
+}
+
+func (q *query_replace_mode) on_key(ev *termbox.Event) {
+	g := q.godit
+	if ev.Mod != 0 {
+		g.set_status(q.prompt)
+		return
+	}
+	switch {
+	case ev.Ch == 'y' || ev.Key == termbox.KeySpace:
+		q.next(q.replace())
+	case ev.Ch == 'n' || ev.Key == termbox.KeyBackspace || ev.Key == termbox.KeyBackspace2:
+		next := q.match
+		next.boffset += len(q.word)
+		q.next(next)
+	case ev.Ch == '!':
+		v := g.active.leaf
+		v.move_cursor_to(q.match)
+		q.replaced += v.replace_all(q.word, q.repl)
+		v.finalize_action_group()
+		g.set_overlay_mode(nil)
+	case ev.Ch == 'q' || ev.Key == termbox.KeyEnter:
+		g.set_overlay_mode(nil)
+	default:
+		g.set_status(q.prompt)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+	"testing"
+)
+
+func TestQueryReplace(t *testing.T) {
+	g := new_test_godit(t, "a a\na a\na")
+	v := g.active.leaf
+	query_replace := func(word, repl string) {
+		send_keys(g, termbox.Event{Mod: termbox.ModAlt, Ch: '%'})
+		type_text(g, word)
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+		type_text(g, repl)
+		send_keys(g, termbox.Event{Key: termbox.KeyEnter})
+	}
+
+	query_replace("a", "bcd")
+	if v.cursor.line_num != 1 || v.cursor.boffset != 1 {
+		t.Errorf("first match: cursor at %d:%d, want 1:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	send_keys(g, termbox.Event{Ch: 'y'}, termbox.Event{Ch: 'n'}, termbox.Event{Ch: 'y'})
+	if got := string(v.buf.contents()); got != "bcd a\nbcd a\na" {
+		t.Errorf("y n y: got %q", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 5 {
+		t.Errorf("third match: cursor at %d:%d, want 2:5", v.cursor.line_num, v.cursor.boffset)
+	}
+	send_keys(g, termbox.Event{Ch: '!'})
+	if got := string(v.buf.contents()); got != "bcd a\nbcd bcd\nbcd" {
+		t.Errorf("!: got %q", got)
+	}
+	if g.overlay != nil {
+		t.Fatal("the mode doesn't end after !")
+	}
+	if got := g.statusbuf.String(); got != "Replaced 4 occurrence(s)" {
+		t.Errorf("status: got %q", got)
+	}
+
+	// the replacements made by ! are undone together, the rest one by one
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlSlash})
+	if got := string(v.buf.contents()); got != "bcd a\nbcd a\na" {
+		t.Errorf("undo !: got %q", got)
+	}
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlSlash})
+	if got := string(v.buf.contents()); got != "bcd a\na a\na" {
+		t.Errorf("undo y: got %q", got)
+	}
+
+	send_keys(g, termbox.Event{Key: termbox.KeyCtrlA})
+	query_replace("x", "y")
+	if g.overlay != nil {
+		t.Error("the mode starts without matches")
+	}
+	if got := g.statusbuf.String(); got != "No matches for x" {
+		t.Errorf("no matches: status %q", got)
+	}
+}
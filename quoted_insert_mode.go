@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+	"unicode/utf8"
+)
+
+//----------------------------------------------------------------------------
+// quoted insert mode
+//
+// "C-q" inserts the next key literally, without any of the special meanings
+// it may have: control characters, <tab> in the smart tab mode, <esc>. It's
+// also possible to type the character code, up to three octal digits or '#'
+// followed by decimal digits. A code is ended by <enter> or any other key,
+// which is handled as usual then. A '#' with no digits after it is inserted
+// as it is.
+//----------------------------------------------------------------------------
+
+type quoted_insert_mode struct {
+	stub_overlay_mode
+	godit      *godit
+	prefix_arg int
+	radix      int // 0 until a code is being typed
+	code       int
+	digits     int
+}
+
+func init_quoted_insert_mode(godit *godit) *quoted_insert_mode {
+	q := new(quoted_insert_mode)
+	q.godit = godit
+	q.prefix_arg = godit.active.leaf.prefix_arg
+	q.godit.set_status("C-q-")
+	return q
+}
+
+func (q *quoted_insert_mode) on_key(ev *termbox.Event) {
+	g := q.godit
+	if ev.Mod == 0 {
+		switch {
+		case q.radix == 0 && ev.Ch == '#':
+			q.radix = 10
+			g.set_status("C-q #-")
+			return
+		case q.radix != 10 && ev.Ch >= '0' && ev.Ch <= '7',
+			q.radix == 10 && ev.Ch >= '0' && ev.Ch <= '9':
+			if q.radix == 0 {
+				q.radix = 8
+			}
+			q.code = q.code*q.radix + int(ev.Ch-'0')
+			q.digits++
+			if q.code > utf8.MaxRune {
+				g.set_overlay_mode(nil)
+				g.set_status("Character code is out of range")
+				return
+			}
+			if q.radix == 8 && q.digits == 3 {
+				g.set_overlay_mode(nil)
+				q.insert(rune(q.code))
+				return
+			}
+			if q.radix == 8 {
+				g.set_status(fmt.Sprintf("C-q %o-", q.code))
+			} else {
+				g.set_status(fmt.Sprintf("C-q #%d-", q.code))
+			}
+			return
+		}
+	}
+
+	g.set_overlay_mode(nil)
+	if q.radix != 0 {
+		if q.digits > 0 {
+			q.insert(rune(q.code))
+		} else {
+			q.insert('#')
+		}
+		if ev.Key != termbox.KeyEnter {
+			g.on_key(ev)
+		}
+		return
+	}
+
+	switch {
+	case ev.Ch != 0:
+		if ev.Mod&termbox.ModAlt != 0 {
+			// that's how terminals send it
+			q.insert('\x1b')
+		}
+		q.insert(ev.Ch)
+	case ev.Key <= termbox.KeyBackspace2:
+		// control keys have the codes of their characters
+		q.insert(rune(ev.Key))
+	default:
+		g.set_status("C-q: %s isn't a character", key_of(ev))
+	}
+}
+
+func (q *quoted_insert_mode) insert(r rune) {
+	q.godit.with_prefix_arg(q.prefix_arg, func() {
+		q.godit.active.leaf.on_vcommand(vcommand_quoted_insert, r)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+//----------------------------------------------------------------------------
+// rectangles
+//
+// A rectangle is the text between two columns (the cursor and the mark ones)
+// on the lines of the region. A rune belongs to the column it starts at, a
+// tab crossing the left edge of a rectangle stays out of it. The last killed
+// rectangle is kept apart from the kill ring ('view_context.rectangle'), one
+// row per line.
+//----------------------------------------------------------------------------
+
+// The byte offsets of the runes of 'line' beginning at the visual offsets from
+// 'left' up to 'right'.
+func rectangle_offsets(line *line, left, right, tabstop int) (beg, end int) {
+	beg, _, vo := line.find_closest_offsets(left, tabstop)
+	if vo < left && beg < len(line.data) {
+		// the rune crosses the left edge
+		_, rlen := utf8.DecodeRune(line.data[beg:])
+		beg += rlen
+	}
+	end, _, _ = line.find_closest_offsets(right-1, tabstop)
+	if right > left && end < len(line.data) {
+		_, rlen := utf8.DecodeRune(line.data[end:])
+		end += rlen
+	}
+	if end < beg {
+		end = beg
+	}
+	return
+}
+
+// The lines and the columns of the rectangle between the cursor and the mark.
+func (v *view) rectangle_bounds() (beg, end cursor_location, left, right int) {
+	beg, end = swap_cursors_maybe(v.cursor, v.buf.mark)
+	left = v.cursor.voffset(v.buf.tabstop)
+	right = v.buf.mark.voffset(v.buf.tabstop)
+	if left > right {
+		left, right = right, left
+	}
+	return
+}
+
+// Deletes the rectangle and keeps it for 'yank_rectangle', the cursor goes to
+// its top left corner.
+func (v *view) kill_rectangle() {
+	if !v.check_region() {
+		return
+	}
+	beg, end, left, right := v.rectangle_bounds()
+	tabstop := v.buf.tabstop
+
+	rows := make([][]byte, 0, end.line_num-beg.line_num+1)
+	c := beg
+	for {
+		b, e := rectangle_offsets(c.line, left, right, tabstop)
+		rows = append(rows, clone_byte_slice(c.line.data[b:e]))
+		if e > b {
+			v.action_delete(cursor_location{c.line, c.line_num, b}, e-b)
+		}
+		if c.line == end.line {
+			break
+		}
+		c = cursor_location{c.line.next, c.line_num + 1, 0}
+	}
+	*v.ctx.rectangle = rows
+
+	b, _ := rectangle_offsets(beg.line, left, right, tabstop)
+	v.move_cursor_to(cursor_location{beg.line, beg.line_num, b})
+	v.dirty = dirty_everything
+}
+
+// Inserts the rows of the last killed rectangle at the cursor column of the
+// cursor line and the lines after it. Short lines are padded with spaces and
+// the buffer gets new lines if there are not enough of them. A tab crossing
+// the column is turned into spaces, so that the rows line up. The cursor goes
+// to the end of the last row.
+func (v *view) yank_rectangle() {
+	rows := *v.ctx.rectangle
+	if len(rows) == 0 {
+		v.ctx.set_status("No rectangle to yank")
+		return
+	}
+	tabstop := v.buf.tabstop
+	col := v.cursor.voffset(tabstop)
+
+	c := v.cursor
+	for i, row := range rows {
+		if i > 0 {
+			if c.line.next == nil {
+				v.action_insert(cursor_location{c.line, c.line_num, len(c.line.data)}, []byte{'\n'})
+			}
+			c = cursor_location{c.line.next, c.line_num + 1, 0}
+		}
+		bo, _, vo := c.line.find_closest_offsets(col, tabstop)
+		c.boffset = bo
+		data := row
+		if vo < col && bo == len(c.line.data) {
+			data = append(bytes.Repeat([]byte{' '}, col-vo), row...)
+		} else if vo < col && c.line.data[bo] == '\t' {
+			w := rune_advance_len('\t', vo, tabstop)
+			v.action_delete(c, 1)
+			data = append(bytes.Repeat([]byte{' '}, col-vo), row...)
+			v.action_insert(c, bytes.Repeat([]byte{' '}, vo+w-col))
+		}
+		v.action_insert(c, data)
+		c.boffset += len(data)
+	}
+	v.move_cursor_to(c)
+	v.dirty = dirty_everything
+}
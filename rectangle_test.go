@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRectangleOffsets(t *testing.T) {
+	cases := []struct {
+		data        string
+		left, right int
+		beg, end    int
+	}{
+		{"abcdef", 1, 3, 1, 3},
+		{"abcdef", 2, 2, 2, 2},
+		{"ab", 1, 5, 1, 2},
+		{"ab", 4, 6, 2, 2},
+		{"a\tb", 4, 9, 2, 3}, // the tab starts before the rectangle
+		{"a\tb", 0, 2, 0, 2},
+		{"世界x", 1, 4, 3, 6}, // a wide rune belongs to its first column
+	}
+	for _, c := range cases {
+		l := &line{data: []byte(c.data)}
+		beg, end := rectangle_offsets(l, c.left, c.right, tabstop_length)
+		if beg != c.beg || end != c.end {
+			t.Errorf("%q from %d to %d: got %d, %d, want %d, %d",
+				c.data, c.left, c.right, beg, end, c.beg, c.end)
+		}
+	}
+}
+
+func TestViewKillYankRectangle(t *testing.T) {
+	v := new_test_view(t, "abcd\nefgh\ni\njklm", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.on_vcommand(vcommand_set_mark, 0)
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	}
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+
+	v.on_vcommand(vcommand_kill_rectangle, 0)
+	if got := string(v.buf.contents()); got != "ad\neh\ni\njm" {
+		t.Fatalf("kill: got %q", got)
+	}
+	if v.cursor.line_num != 1 || v.cursor.boffset != 1 {
+		t.Errorf("kill: cursor at %d:%d, want 1:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	if len(v.ctx.kill_ring.entries) != 0 {
+		t.Error("the rectangle went to the kill ring")
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "abcd\nefgh\ni\njklm" {
+		t.Errorf("undo: got %q", got)
+	}
+	v.on_vcommand(vcommand_redo, 0)
+
+	// short lines are padded, missing ones added
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_yank_rectangle, 0)
+	if got := string(v.buf.contents()); got != "ad\neh\ni\njmbc\n  fg\n  \n  kl" {
+		t.Errorf("yank: got %q", got)
+	}
+	if v.cursor.line_num != 7 || v.cursor.boffset != 4 {
+		t.Errorf("yank: cursor at %d:%d, want 7:4", v.cursor.line_num, v.cursor.boffset)
+	}
+}
+
+func TestViewYankRectangleAcrossTab(t *testing.T) {
+	v := new_test_view(t, "12\n34\nabcdef\na\tx\na\ty", 40, 10)
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_kill_rectangle, 0)
+
+	// the tab of the second line spans the columns 1 to 7, the row goes
+	// into it at the column 4
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_to_column, 4)
+	v.on_vcommand(vcommand_yank_rectangle, 0)
+	if got := string(v.buf.contents()); got != "\n\nabcd12ef\na   34    x\na\ty" {
+		t.Errorf("yank: got %q", got)
+	}
+	if v.cursor.line_num != 4 || v.cursor.boffset != 6 {
+		t.Errorf("yank: cursor at %d:%d, want 4:6", v.cursor.line_num, v.cursor.boffset)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+// After "C-x z" each 'z' repeats the last command once more.
+type repeat_mode struct {
+	stub_overlay_mode
+	godit *godit
+}
+
+func init_repeat_mode(godit *godit) repeat_mode {
+	return repeat_mode{godit: godit}
+}
+
+func (r repeat_mode) on_key(ev *termbox.Event) {
+	g := r.godit
+	v := g.active.leaf
+	if ev.Mod == 0 && ev.Ch == 'z' {
+		v.on_vcommand(vcommand_repeat_last, 0)
+		return
+	}
+
+	g.set_overlay_mode(nil)
+	g.on_key(ev)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+//----------------------------------------------------------------------------
+// search
+//
+// The one buffer scanner everything that looks for text uses (isearch, search
+// & replace, etc.). Patterns are matched within a line, a pattern with a
+// newline in it never matches.
+//----------------------------------------------------------------------------
+
+type search_opts struct {
+	backward   bool
+	fold_case  bool // case-insensitive, only runes of equal encoded length match
+	whole_word bool // a match can't be surrounded by word runes
+	wrap       bool // continue from the other end of the buffer
+}
+
+// Finds the beginning of the nearest match of 'pattern'. Forward search looks
+// for matches beginning at 'from' or after it, backward search looks for
+// matches ending at 'from' or before it. In both cases a match is
+// len(pattern) bytes long.
+func (b *buffer) find(from cursor_location, pattern []byte, opts search_opts) (cursor_location, bool) {
+	if bytes.IndexByte(pattern, '\n') != -1 {
+		return from, false
+	}
+
+	c, ok := scan_lines(from, pattern, opts)
+	if ok || !opts.wrap {
+		return c, ok
+	}
+
+	if opts.backward {
+		c = cursor_location{b.last_line, b.lines_n, len(b.last_line.data)}
+	} else {
+		c = cursor_location{b.first_line, 1, 0}
+	}
+	return scan_lines(c, pattern, opts)
+}
+
+func scan_lines(c cursor_location, pattern []byte, opts search_opts) (cursor_location, bool) {
+	if opts.backward {
+		for {
+			i := last_index_in_line(c.line.data, pattern, c.boffset, &opts)
+			if i != -1 {
+				c.boffset = i
+				return c, true
+			}
+			if c.line.prev == nil {
+				return c, false
+			}
+			c.line = c.line.prev
+			c.line_num--
+			c.boffset = len(c.line.data)
+		}
+	}
+
+	for {
+		i := index_in_line(c.line.data, pattern, c.boffset, &opts)
+		if i != -1 {
+			c.boffset = i
+			return c, true
+		}
+		if c.line.next == nil {
+			return c, false
+		}
+		c.line = c.line.next
+		c.line_num++
+		c.boffset = 0
+	}
+}
+
+// Index of the first match beginning at 'from' or after it, -1 if none.
+func index_in_line(data, pattern []byte, from int, opts *search_opts) int {
+	for p := from; p+len(pattern) <= len(data); p++ {
+		if !opts.fold_case {
+			i := bytes.Index(data[p:], pattern)
+			if i == -1 {
+				return -1
+			}
+			p += i
+		}
+		if matches_at(data, pattern, p, opts) {
+			return p
+		}
+	}
+	return -1
+}
+
+// Index of the last match ending at 'to' or before it, -1 if none.
+func last_index_in_line(data, pattern []byte, to int, opts *search_opts) int {
+	for p := to - len(pattern); p >= 0; p-- {
+		if !opts.fold_case {
+			p = bytes.LastIndex(data[:p+len(pattern)], pattern)
+			if p == -1 {
+				return -1
+			}
+		}
+		if matches_at(data, pattern, p, opts) {
+			return p
+		}
+	}
+	return -1
+}
+
+func matches_at(data, pattern []byte, p int, opts *search_opts) bool {
+	if p < len(data) && !utf8.RuneStart(data[p]) {
+		return false
+	}
+	m := data[p : p+len(pattern)]
+	if opts.fold_case && !bytes.EqualFold(m, pattern) {
+		return false
+	}
+	if !opts.fold_case && !bytes.Equal(m, pattern) {
+		return false
+	}
+	if opts.whole_word && len(pattern) > 0 {
+		if r, _ := utf8.DecodeLastRune(data[:p]); p > 0 && is_word(r) {
+			return false
+		}
+		end := p + len(pattern)
+		if r, _ := utf8.DecodeRune(data[end:]); end < len(data) && is_word(r) {
+			return false
+		}
+	}
+	return true
+}
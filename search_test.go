@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestBufferFind(t *testing.T) {
+	b := new_test_buffer(t, "foo bar\nFoo foobar\nbar föö\n\nfoo")
+	line := func(n int) *line {
+		l, _ := b.line_at(n)
+		return l
+	}
+	at := func(n, boffset int) cursor_location {
+		return cursor_location{line(n), n, boffset}
+	}
+
+	cases := []struct {
+		from     cursor_location
+		pattern  string
+		opts     search_opts
+		ok       bool
+		line_num int
+		boffset  int
+	}{
+		// forward, matches beginning at 'from' count
+		{at(1, 0), "foo", search_opts{}, true, 1, 0},
+		{at(1, 1), "foo", search_opts{}, true, 2, 4},
+		{at(2, 4), "foo", search_opts{}, true, 2, 4},
+		{at(2, 5), "foo", search_opts{}, true, 5, 0},
+		{at(5, 1), "foo", search_opts{}, false, 0, 0},
+		{at(5, 1), "foo", search_opts{wrap: true}, true, 1, 0},
+		{at(1, 0), "bar", search_opts{whole_word: true}, true, 1, 4},
+		{at(1, 5), "bar", search_opts{whole_word: true}, true, 3, 0},
+		{at(1, 1), "foo", search_opts{fold_case: true}, true, 2, 0},
+		{at(1, 0), "FÖÖ", search_opts{fold_case: true}, true, 3, 4},
+		{at(1, 0), "o\nb", search_opts{}, false, 0, 0},
+		{at(2, 2), "", search_opts{}, true, 2, 2},
+
+		// backward, matches ending at 'from' count
+		{at(5, 3), "foo", search_opts{backward: true}, true, 5, 0},
+		{at(5, 2), "foo", search_opts{backward: true}, true, 2, 4},
+		{at(2, 6), "foo", search_opts{backward: true}, true, 1, 0},
+		{at(2, 6), "foo", search_opts{backward: true, fold_case: true}, true, 2, 0},
+		{at(1, 2), "foo", search_opts{backward: true}, false, 0, 0},
+		{at(1, 2), "foo", search_opts{backward: true, wrap: true}, true, 5, 0},
+		{at(3, 0), "foo", search_opts{backward: true, whole_word: true}, true, 1, 0},
+		{at(2, 10), "bar", search_opts{backward: true, whole_word: true}, true, 1, 4},
+	}
+	for _, c := range cases {
+		loc, ok := b.find(c.from, []byte(c.pattern), c.opts)
+		if ok != c.ok {
+			t.Errorf("%q from %d:%d %+v: got ok = %v, expected %v",
+				c.pattern, c.from.line_num, c.from.boffset, c.opts, ok, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if loc.line_num != c.line_num || loc.boffset != c.boffset || loc.line != line(c.line_num) {
+			t.Errorf("%q from %d:%d %+v: got %d:%d, expected %d:%d",
+				c.pattern, c.from.line_num, c.from.boffset, c.opts,
+				loc.line_num, loc.boffset, c.line_num, c.boffset)
+		}
+	}
+}
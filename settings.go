@@ -0,0 +1,135 @@
+package main
+
+import (
+	"time"
+)
+
+//----------------------------------------------------------------------------
+// settings
+//
+// Godit has no configuration files, but a couple of things can be switched at
+// runtime (see toggle mode). Global knobs live here, the ones that make sense
+// per buffer live in the buffer itself.
+//----------------------------------------------------------------------------
+
+type godit_settings struct {
+	// When enabled, the region exists only while the mark is active, the
+	// mark is activated by setting it and deactivated by most editing
+	// commands. Otherwise the region is always there once the mark was set.
+	transient_mark bool
+
+	// C-v and M-v scroll by the whole view height minus 'scroll_overlap'
+	// lines of context instead of half of it.
+	full_page_scroll bool
+	scroll_overlap   int
+
+	// Tab right after a word starts autocompletion (C-x C-a), elsewhere it
+	// inserts a tab as usual.
+	smart_tab bool
+
+	// The location of a buffer (see 'buffer.loc') is updated on every cursor
+	// move and view command, not only after each key.
+	sync_location bool
+
+	// Matches of the last incremental search stay highlighted after it's
+	// done.
+	lazy_highlight bool
+
+	// Lines changed since the buffer was loaded or saved are marked in the
+	// gutter of views.
+	changed_lines_gutter bool
+
+	// Incremental search centers the view on a match which isn't visible,
+	// otherwise the view scrolls just enough to show it.
+	isearch_recenter bool
+
+	// Saving (but not the raw one) deletes spaces and tabs at the end of
+	// lines first.
+	trim_trailing_whitespace bool
+
+	// Indentation step of the indentation commands (region indent,
+	// reindent, electric indent) in screen cells, it's independent of the
+	// tab width used for display. Indentation is made of tabs followed by
+	// spaces for the remainder, with 'expand_tabs' it's spaces only and
+	// <tab> inserts spaces up to the next multiple of 'shift_width'.
+	shift_width int
+	expand_tabs bool
+
+	// How the cursor column is shown and read everywhere (status bar,
+	// describe-char, goto-column): counting from 1 instead of 0 and in
+	// characters instead of screen cells (a tab is one character).
+	column_one_based bool
+	column_chars     bool
+
+	// The status bar shows both the character and the visual column when
+	// they differ (there are tabs or wide characters before the cursor).
+	column_both bool
+
+	// The status bar shows the character column as "(L<line>, C<column>)",
+	// as Emacs does, instead of the column 'column_chars' chooses.
+	status_char_column bool
+
+	// A command after a pause longer than this since the last change
+	// starts a new undo group, so that a long run of typing isn't undone
+	// at once. Zero means no limit.
+	undo_pause time.Duration
+
+	// The number of action groups (undo steps) kept in the undo history of
+	// a buffer, the oldest ones are dropped. Zero means no limit.
+	undo_limit int
+
+	// The width M-q fills paragraphs to, and the default one of
+	// fill-region.
+	fill_column int
+
+	// Runes used for drawing the UI, see 'ascii_glyphs' and
+	// 'unicode_glyphs'.
+	glyphs glyph_set
+}
+
+type glyph_set struct {
+	overflow_right rune // line continues past the right edge of a view
+	overflow_left  rune // the cursor line is scrolled horizontally
+	tab_fill       rune // cells covered by a tab
+	status_fill    rune // status bar background
+	splitter       rune // vertical splitter between views
+	changed_line   rune // gutter marker of a changed line
+}
+
+// The default one, works with any font.
+var ascii_glyphs = glyph_set{
+	overflow_right: '>',
+	overflow_left:  '<',
+	tab_fill:       ' ',
+	status_fill:    '-',
+	splitter:       '|',
+	changed_line:   '+',
+}
+
+var unicode_glyphs = glyph_set{
+	overflow_right: '→',
+	overflow_left:  '←',
+	tab_fill:       ' ',
+	status_fill:    '─',
+	splitter:       '│',
+	changed_line:   '▌',
+}
+
+var settings = godit_settings{
+	transient_mark:           false,
+	full_page_scroll:         false,
+	scroll_overlap:           2,
+	smart_tab:                false,
+	sync_location:            true,
+	isearch_recenter:         true,
+	trim_trailing_whitespace: true,
+	shift_width:              tabstop_length,
+	expand_tabs:              false,
+	column_one_based:         false,
+	column_chars:             false,
+	status_char_column:       true,
+	undo_pause:               time.Second,
+	undo_limit:               1000,
+	fill_column:              80,
+	glyphs:                   ascii_glyphs,
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+//----------------------------------------------------------------------------
+// saving with sudo
+//
+// When a file can't be written because of its permissions, godit asks whether
+// to write it through "sudo tee" instead. The terminal is handed over to sudo
+// while it runs, so that it can ask for a password.
+//----------------------------------------------------------------------------
+
+// Writes the contents of the buffer to 'filename' as root.
+func (b *buffer) save_as_sudo(filename string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sudo", "tee", filename)
+	cmd.Stdin = b.file_reader()
+	cmd.Stdout = ioutil.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	b.mark_saved()
+	b.record_mtime(filename)
+	return nil
+}
+
+// Handles an error of saving 'b' to 'filename'. Permission errors turn into
+// a y/n prompt for saving with sudo, 'on_saved' is called if that succeeds.
+func (g *godit) save_failed(b *buffer, filename string, err error, on_saved func()) {
+	if !os.IsPermission(err) {
+		g.set_status(err.Error())
+		return
+	}
+
+	g.set_overlay_mode(init_key_press_mode(
+		g,
+		map[rune]func(){
+			'y': func() {
+				err := g.release_terminal(func() error {
+					fmt.Printf("Writing %s with sudo\n", filename)
+					return b.save_as_sudo(filename)
+				})
+				if err != nil {
+					g.set_status(err.Error())
+					return
+				}
+				if on_saved != nil {
+					on_saved()
+				}
+				g.set_status("Wrote %s (with sudo)", filename)
+			},
+			'n': func() {
+				g.set_status(err.Error())
+			},
+		},
+		0,
+		"No permission to write "+filename+", write it with sudo? (y or n)",
+	))
+}
+
+// Gives the terminal away for the time 'f' runs and takes it back afterwards.
+func (g *godit) release_terminal(f func() error) error {
+	termbox.Close()
+	err := f()
+	if ierr := termbox.Init(); ierr != nil {
+		panic(ierr)
+	}
+	termbox.SetInputMode(termbox.InputAlt | termbox.InputMouse)
+	g.resize()
+	return err
+}
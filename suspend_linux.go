@@ -22,6 +22,6 @@ func suspend(g *godit) {
 	if err != nil {
 		panic(err)
 	}
-	termbox.SetInputMode(termbox.InputAlt)
+	termbox.SetInputMode(termbox.InputAlt | termbox.InputMouse)
 	g.resize()
 }
@@ -0,0 +1,65 @@
+package main
+
+//----------------------------------------------------------------------------
+// toggle mode
+//
+// A micromode for flipping editor settings: "C-x t <key>". Each toggle reports
+// its new state in the status bar.
+//----------------------------------------------------------------------------
+
+type toggle struct {
+	key     rune
+	command string
+}
+
+var toggles = []toggle{
+	{'m', "toggle-transient-mark-mode"},
+	{'p', "toggle-full-page-scroll"},
+	{'o', "set-scroll-overlap"},
+	{'u', "set-undo-pause"},
+	{'i', "toggle-smart-tab"},
+	{'a', "toggle-auto-indent"},
+	{'e', "toggle-electric-indent"},
+	{'t', "toggle-truncate-lines"},
+	{'w', "toggle-wrap-lines"},
+	{'g', "toggle-unicode-glyphs"},
+	{'1', "toggle-one-based-column"},
+	{'c', "toggle-character-column"},
+	{'v', "toggle-both-columns"},
+	{'C', "toggle-status-character-column"},
+	{'x', "toggle-expand-tabs"},
+	{'s', "toggle-isearch-recenter"},
+	{'I', "set-indentation-width"},
+	{'T', "set-tab-width"},
+	{'l', "toggle-location-sync"},
+	{'h', "toggle-lazy-highlight"},
+	{'d', "toggle-changed-lines-gutter"},
+	{'n', "toggle-line-numbers"},
+	{'D', "toggle-crlf-line-endings"},
+	{'r', "toggle-read-only"},
+	{'W', "toggle-trim-trailing-whitespace"},
+}
+
+func init_toggle_mode(godit *godit) *key_press_mode {
+	actions := make(map[rune]func(), len(toggles))
+	keys := make([]rune, 0, len(toggles))
+	for _, t := range toggles {
+		name := t.command
+		actions[t.key] = func() {
+			godit.run_command(name, 3)
+			// toggles may change the mode indicators
+			godit.views.traverse(func(t *view_tree) {
+				t.leaf.dirty |= dirty_status
+			})
+		}
+		keys = append(keys, t.key)
+	}
+	return init_key_press_mode(godit, actions, 0, "Toggle ["+string(keys)+"]:")
+}
+
+func enabled_or_disabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nsf/termbox-go"
+)
+
+//----------------------------------------------------------------------------
+// universal argument mode
+//
+// "C-u" reads a numeric argument for the next key: 4 on its own, multiplied
+// by 4 with each "C-u" after it, or the typed digits. "C-u" after the digits
+// ends the argument, so that the next digit is inserted instead.
+//----------------------------------------------------------------------------
+
+type universal_argument_mode struct {
+	stub_overlay_mode
+	godit  *godit
+	n      int
+	digits bool // 'n' is typed in
+	done   bool
+}
+
+func init_universal_argument_mode(godit *godit) *universal_argument_mode {
+	u := new(universal_argument_mode)
+	u.godit = godit
+	u.n = 4
+	u.godit.set_status("C-u-")
+	return u
+}
+
+func (u *universal_argument_mode) on_key(ev *termbox.Event) {
+	g := u.godit
+	switch {
+	case ev.Mod == 0 && ev.Ch >= '0' && ev.Ch <= '9' && !u.done:
+		if !u.digits {
+			u.n = 0
+			u.digits = true
+		}
+		u.n = u.n*10 + int(ev.Ch-'0')
+	case ev.Mod == 0 && ev.Key == termbox.KeyCtrlU && !u.done:
+		if u.digits {
+			u.done = true
+		} else {
+			u.n *= 4
+		}
+	default:
+		g.set_overlay_mode(nil)
+		g.with_prefix_arg(u.n, func() {
+			g.on_key(ev)
+		})
+		return
+	}
+	g.set_status(fmt.Sprintf("C-u %d-", u.n))
+}
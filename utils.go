@@ -72,10 +72,10 @@ func rune_width(r rune) int {
 	return 1
 }
 
-func rune_advance_len(r rune, pos int) int {
+func rune_advance_len(r rune, pos, tabstop int) int {
 	switch {
 	case r == '\t':
-		return tabstop_length - pos%tabstop_length
+		return tabstop - pos%tabstop
 	case r < 32:
 		// for invisible chars like ^R ^@ and such, two cells
 		return 2
@@ -83,12 +83,12 @@ func rune_advance_len(r rune, pos int) int {
 	return rune_width(r)
 }
 
-func vlen(data []byte, pos int) int {
+func vlen(data []byte, pos, tabstop int) int {
 	origin := pos
 	for len(data) > 0 {
 		r, rlen := utf8.DecodeRune(data)
 		data = data[rlen:]
-		pos += rune_advance_len(r, pos)
+		pos += rune_advance_len(r, pos, tabstop)
 	}
 	return pos - origin
 }
@@ -192,6 +192,133 @@ func index_first_non_space(s []byte) int {
 	return len(s)
 }
 
+// Counts the closing brackets at the beginning of a line of C-like code
+// ('leading', the line's contents must not start with whitespace) and the
+// balance of opening and closing brackets after them ('rest'). String
+// literals, rune literals and line comments are skipped, no attempt is made to
+// deal with block comments or raw strings spanning lines.
+func bracket_balance(data []byte) (leading, rest int) {
+	i := 0
+	for ; i < len(data); i++ {
+		if c := data[i]; c != '}' && c != ')' && c != ']' {
+			break
+		}
+		leading++
+	}
+
+	for ; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '{', '(', '[':
+			rest++
+		case '}', ')', ']':
+			rest--
+		case '"', '\'', '`':
+			// skip the literal, honoring escapes (not in raw strings)
+			for i++; i < len(data) && data[i] != c; i++ {
+				if c != '`' && data[i] == '\\' {
+					i++
+				}
+			}
+		case '/':
+			if i+1 < len(data) && data[i+1] == '/' {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Visual width of the leading whitespace.
+func indent_width(data []byte, tabstop int) int {
+	vo := 0
+	for _, c := range data[:index_first_non_space(data)] {
+		vo += rune_advance_len(rune(c), vo, tabstop)
+	}
+	return vo
+}
+
+// Visual width of the leading whitespace in indentation levels, see
+// 'settings.shift_width'.
+func indent_level(data []byte, tabstop int) int {
+	return indent_width(data, tabstop) / settings.shift_width
+}
+
+// Leading whitespace 'width' cells wide: tabs and spaces for the remainder, or
+// spaces only if 'settings.expand_tabs' is on.
+func make_indent(width, tabstop int) []byte {
+	tabs, spaces := 0, width
+	if !settings.expand_tabs {
+		tabs, spaces = width/tabstop, width%tabstop
+	}
+	indent := bytes.Repeat([]byte{'\t'}, tabs)
+	return append(indent, bytes.Repeat([]byte{' '}, spaces)...)
+}
+
+// A run of whitespace of a line, 'data' is what it's replaced with.
+type retab_run struct {
+	beg, end int
+	data     []byte
+}
+
+// Finds the runs of whitespace of the line which change when converted to tabs
+// ('tabs' is true) or to spaces, only the leading one unless 'interior' is
+// true. Interior runs of a single space are never tabified.
+func retab_runs(data []byte, tabs, interior bool, tabstop int) []retab_run {
+	var runs []retab_run
+	vo := 0
+	for i := 0; i < len(data); {
+		if data[i] != ' ' && data[i] != '\t' {
+			if !interior {
+				break
+			}
+			r, rlen := utf8.DecodeRune(data[i:])
+			vo += rune_advance_len(r, vo, tabstop)
+			i += rlen
+			continue
+		}
+
+		beg, beg_vo := i, vo
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
+			vo += rune_advance_len(rune(data[i]), vo, tabstop)
+			i++
+		}
+		var ws []byte
+		if tabs {
+			if beg > 0 && i-beg < 2 {
+				continue
+			}
+			ws = tabs_between(beg_vo, vo, tabstop)
+		} else {
+			ws = bytes.Repeat([]byte{' '}, vo-beg_vo)
+		}
+		if !bytes.Equal(ws, data[beg:i]) {
+			runs = append(runs, retab_run{beg, i, ws})
+		}
+	}
+	return runs
+}
+
+// Whitespace from the visual offset 'from' to 'to', with tabs up to the last
+// tab stop and spaces after it.
+func tabs_between(from, to, tabstop int) []byte {
+	var ws []byte
+	for stop := (from/tabstop + 1) * tabstop; stop <= to; stop += tabstop {
+		ws = append(ws, '\t')
+		from = stop
+	}
+	return append(ws, bytes.Repeat([]byte{' '}, to-from)...)
+}
+
+// Empty or whitespace only.
+func is_blank_line(l *line) bool {
+	return index_first_non_space(l.data) == len(l.data)
+}
+
+func is_case_label(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("case ")) ||
+		bytes.HasPrefix(data, []byte("default:"))
+}
+
 func index_last_non_space(s []byte) int {
 	for i := len(s) - 1; i >= 0; i-- {
 		if s[i] != '\t' && s[i] != ' ' {
@@ -209,6 +336,25 @@ func abs_path(filename string) string {
 	return path
 }
 
+// Creates or truncates the file and writes 'data' to it, if 'appending' is
+// true, 'data' is appended to the end of the file instead.
+func write_file(filename string, data []byte, appending bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appending {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(filename, flags, 0666)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 func grow_byte_slice(s []byte, desired_cap int) []byte {
 	if cap(s) < desired_cap {
 		ns := make([]byte, len(s), desired_cap)
@@ -247,6 +393,35 @@ func bytes_between(a, b cursor_location) []byte {
 	return a.line.data[a.boffset:b.boffset]
 }
 
+// Capitalizes the words of 'data' rune by rune: the first rune of a word in
+// title case, the rest of it in lower case, everything else is kept as it is.
+func capitalize(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	in_word := false
+	for len(data) > 0 {
+		r, rlen := utf8.DecodeRune(data)
+		cr := r
+		switch {
+		case !is_word(r):
+			in_word = false
+		case in_word:
+			cr = unicode.ToLower(r)
+		default:
+			cr = unicode.ToTitle(r)
+			in_word = true
+		}
+		if cr == r {
+			// as it is, invalid UTF-8 included
+			out = append(out, data[:rlen]...)
+		} else {
+			var buf [utf8.UTFMax]byte
+			out = append(out, buf[:utf8.EncodeRune(buf[:], cr)]...)
+		}
+		data = data[rlen:]
+	}
+	return out
+}
+
 func is_word(r rune) bool {
 	return r == '_' || unicode.IsLetter(r) || unicode.IsNumber(r)
 }
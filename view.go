@@ -6,7 +6,9 @@ import (
 	"github.com/nsf/termbox-go"
 	"github.com/nsf/tulib"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
@@ -40,7 +42,7 @@ type view_location struct {
 	// 1. in characters
 	// 2. in visual cells
 	// An example would be the '\t' character, which gives 1 character
-	// offset, but 'tabstop_length' visual cells offset.
+	// offset, but up to 'buf.tabstop' visual cells offset.
 	cursor_coffset int
 	cursor_voffset int
 
@@ -74,6 +76,9 @@ func (r byte_range) includes(offset int) bool {
 const hl_fg = termbox.ColorCyan
 const hl_bg = termbox.ColorBlue
 
+const bracket_fg = termbox.ColorBlack
+const bracket_bg = termbox.ColorYellow
+
 //----------------------------------------------------------------------------
 // view tags
 //----------------------------------------------------------------------------
@@ -110,9 +115,11 @@ var default_view_tag = view_tag{
 //----------------------------------------------------------------------------
 
 type view_context struct {
-	set_status  func(format string, args ...interface{})
-	kill_buffer *[]byte
-	buffers     *[]*buffer
+	set_status func(format string, args ...interface{})
+	kill_ring  *kill_ring
+	rectangle  *[][]byte // the last killed rectangle, see rectangle.go
+	buffers    *[]*buffer
+	registers  map[rune][]byte
 }
 
 //----------------------------------------------------------------------------
@@ -147,6 +154,57 @@ type view struct {
 	highlight_bytes  []byte
 	highlight_ranges []byte_range
 	tags             []view_tag
+
+	// the bracket matching the one under the cursor, highlighted (see
+	// 'update_bracket_match'), 'line' is nil if there is none
+	bracket_match  cursor_location
+	bracket_cursor cursor_location // the cursor it was found for
+
+	// the drawn region, see 'update_region_highlight'
+	region_beg cursor_location
+	region_end cursor_location
+
+	// the numeric argument of the key being handled ("C-u"), 0 if there is
+	// none, see 'repeat_count'
+	prefix_arg int
+
+	// the last command with its arguments, for 'vcommand_repeat_last'
+	repeat_vcommand   vcommand
+	repeat_arg        rune
+	repeat_prefix_arg int
+
+	// the last pasted text, 'vcommand_insert_pasted' inserts it again
+	repeat_text []byte
+
+	// the buffers the view showed before, the most recent one is the last
+	buf_history []*buffer
+
+	// the last line the cursor left horizontally scrolled, the scroll is
+	// restored when the cursor returns to it
+	scrolled_line    *line
+	scrolled_voffset int
+
+	// long lines continue on the next rows of the view, see wrap.go
+	wrap bool
+
+	// line numbers are shown in the gutter, see 'gutter_width'
+	show_line_numbers bool
+
+	// what's drawn on the rows of 'uibuf', a row which would be drawn the
+	// same way again is skipped (see 'draw_contents')
+	drawn_rows      []drawn_row
+	drawn_highlight []byte
+	drawn_glyphs    glyph_set
+	drawn_gutter    int
+}
+
+type drawn_row struct {
+	valid        bool
+	line         *line
+	data         []byte // copy of the line contents
+	line_num     int
+	line_voffset int
+	changed      bool
 }
 
 func new_view(ctx view_context, buf *buffer) *view {
@@ -162,6 +220,7 @@ func new_view(ctx view_context, buf *buffer) *view {
 
 func (v *view) activate() {
 	v.last_vcommand = vcommand_none
+	v.buf.loc_owner = v
 }
 
 func (v *view) deactivate() {
@@ -176,19 +235,77 @@ func (v *view) attach(b *buffer) {
 
 	v.ac = nil
 	if v.buf != nil {
+		v.remember_buffer(v.buf)
 		v.detach()
 	}
 	v.buf = b
 	v.view_location = b.loc
+	b.loc_owner = v
 	b.add_view(v)
+	v.raise_buffer(b)
 	v.dirty = dirty_everything
 }
 
 func (v *view) detach() {
+	v.save_location()
+	if v.buf.loc_owner == v {
+		v.buf.loc_owner = nil
+	}
 	v.buf.delete_view(v)
 	v.buf = nil
 }
 
+// Moves the buffer to the end of 'buf_history'.
+func (v *view) remember_buffer(b *buffer) {
+	v.forget_buffer(b)
+	v.buf_history = append(v.buf_history, b)
+}
+
+func (v *view) forget_buffer(b *buffer) {
+	for i, hb := range v.buf_history {
+		if hb == b {
+			copy(v.buf_history[i:], v.buf_history[i+1:])
+			v.buf_history = v.buf_history[:len(v.buf_history)-1]
+			return
+		}
+	}
+}
+
+// Moves the buffer to the front of the buffers list (see 'view_context'),
+// keeping it ordered from the most recently shown one.
+func (v *view) raise_buffer(b *buffer) {
+	if v.ctx.buffers == nil {
+		return
+	}
+	bufs := *v.ctx.buffers
+	for i, gb := range bufs {
+		if gb == b {
+			copy(bufs[1:i+1], bufs[:i])
+			bufs[0] = b
+			return
+		}
+	}
+}
+
+// The buffer the view showed most recently before the current one, nil if
+// there is none.
+func (v *view) previous_buffer() *buffer {
+	for i := len(v.buf_history) - 1; i >= 0; i-- {
+		if b := v.buf_history[i]; b != v.buf {
+			return b
+		}
+	}
+	return nil
+}
+
+// Saves the view location into the buffer if the view owns it (see
+// 'buffer.loc_owner').
+func (v *view) save_location() {
+	if v.buf.loc_owner == v {
+		v.buf.loc = v.view_location
+	}
+}
+
 func (v *view) init_autocompl() {
 	if v.ac_decide == nil {
 		return
@@ -209,6 +326,7 @@ func (v *view) init_autocompl() {
 // Resize the 'v.uibuf', adjusting things accordingly.
 func (v *view) resize(w, h int) {
 	v.uibuf.Resize(w, h)
+	v.invalidate_drawn_rows()
 	v.adjust_line_voffset()
 	v.adjust_top_line()
 	v.dirty = dirty_everything
@@ -237,19 +355,84 @@ func (v *view) horizontal_threshold() int {
 	return view_horizontal_threshold
 }
 
+// Width of the text area, right of the gutter.
 func (v *view) width() int {
-	// TODO: perhaps if I want to draw line numbers, I will hack it there
-	return v.uibuf.Width
+	return v.uibuf.Width - v.gutter_width()
 }
 
-func (v *view) draw_line(line *line, line_num, coff, line_voffset int) {
-	x := 0
+// The gutter is left of the text, there is one when 'show_line_numbers' or
+// 'settings.changed_lines_gutter' is on. Line numbers are as wide as the
+// number of the last line, they're followed by a column of changed line
+// markers (or a space without them).
+func (v *view) gutter_width() int {
+	if v.oneline || !settings.changed_lines_gutter && !v.show_line_numbers {
+		return 0
+	}
+	w := 1
+	if v.show_line_numbers {
+		w += len(strconv.Itoa(v.buf.lines_n))
+	}
+	if w >= v.uibuf.Width {
+		return 0
+	}
+	return w
+}
+
+// The gutter may be a different width now, or the same width with different
+// contents. A different width changes the width of the text, so the line is
+// scrolled and the lines wrap differently.
+func (v *view) gutter_changed() {
+	v.adjust_line_voffset()
+	v.adjust_top_line()
+	v.invalidate_drawn_rows()
+	v.dirty = dirty_everything
+}
+
+// Draws the gutter of a row of 'line', the line number is drawn on the 'first'
+// row of a wrapped line only.
+func (v *view) draw_gutter(line *line, line_num int, first bool, coff int) {
+	gw := v.gutter_width()
+	if gw == 0 {
+		return
+	}
+	cells := v.uibuf.Cells[coff : coff+gw]
+	for i := range cells {
+		cells[i] = blank_cell
+	}
+	if line == nil {
+		return
+	}
+	if v.show_line_numbers && first {
+		num := strconv.Itoa(line_num)
+		for i, r := range num {
+			cells[gw-1-len(num)+i] = termbox.Cell{
+				Ch: r,
+				Fg: termbox.ColorBlue,
+				Bg: termbox.ColorDefault,
+			}
+		}
+	}
+	if settings.changed_lines_gutter && line.changed {
+		cells[gw-1] = termbox.Cell{
+			Ch: settings.glyphs.changed_line,
+			Fg: termbox.ColorYellow,
+			Bg: termbox.ColorDefault,
+		}
+	}
+}
+
+// Draws the line from the beginning of 'row' to the byte offset 'end' starting
+// at the cell 'coff' of 'uibuf', returns the number of cells covered. It's the
+// whole line unless the lines wrap.
+func (v *view) draw_line(line *line, line_num, coff, line_voffset int, row wrap_row, end int) int {
+	w := v.width()
+	x := row.vo
 	tabstop := 0
-	bx := 0
-	data := line.data
+	bx := row.bo
+	data := line.data[row.bo:end]
 
 	if len(v.highlight_bytes) > 0 {
-		v.find_highlight_ranges_for_line(data)
+		v.find_highlight_ranges_for_line(line.data)
 	}
 	for {
 		rx := x - line_voffset
@@ -257,14 +440,14 @@ func (v *view) draw_line(line *line, line_num, coff, line_voffset int) {
 			break
 		}
 
-		if x == tabstop {
-			tabstop += tabstop_length
+		for x >= tabstop {
+			tabstop += v.buf.tabstop
 		}
 
-		if rx >= v.uibuf.Width {
-			last := coff + v.uibuf.Width - 1
+		if rx >= w {
+			last := coff + w - 1
 			v.uibuf.Cells[last] = termbox.Cell{
-				Ch: '>',
+				Ch: settings.glyphs.overflow_right,
 				Fg: termbox.ColorDefault,
 				Bg: termbox.ColorDefault,
 			}
@@ -277,13 +460,13 @@ func (v *view) draw_line(line *line, line_num, coff, line_voffset int) {
 			// fill with spaces to the next tabstop
 			for ; x < tabstop; x++ {
 				rx := x - line_voffset
-				if rx >= v.uibuf.Width {
+				if rx >= w {
 					break
 				}
 
 				if rx >= 0 {
 					v.uibuf.Cells[coff+rx] = v.make_cell(
-						line_num, bx, ' ')
+						line_num, bx, settings.glyphs.tab_fill)
 				}
 			}
 		case r < 32:
@@ -297,7 +480,7 @@ func (v *view) draw_line(line *line, line_num, coff, line_voffset int) {
 			}
 			x++
 			rx = x - line_voffset
-			if rx >= v.uibuf.Width {
+			if rx >= w {
 				break
 			}
 			if rx >= 0 {
@@ -314,54 +497,166 @@ func (v *view) draw_line(line *line, line_num, coff, line_voffset int) {
 					line_num, bx, r)
 			}
 			x += rune_width(r)
+			if rx+1 >= 0 && rx+1 < x-line_voffset && rx+1 < w {
+				// the second half of a wide rune
+				v.uibuf.Cells[coff+rx+1] = blank_cell
+			}
 		}
 		data = data[rlen:]
 		bx += rlen
 	}
 
-	if line_voffset != 0 {
+	if line_voffset > row.vo {
 		v.uibuf.Cells[coff] = termbox.Cell{
-			Ch: '<',
+			Ch: settings.glyphs.overflow_left,
 			Fg: termbox.ColorDefault,
 			Bg: termbox.ColorDefault,
 		}
 	}
+
+	drawn := x - line_voffset
+	if drawn > w || len(data) > 0 {
+		drawn = w
+	}
+	if drawn < 0 {
+		drawn = 0
+	}
+	return drawn
 }
 
+var blank_cell = termbox.Cell{
+	Ch: ' ',
+	Fg: termbox.ColorDefault,
+	Bg: termbox.ColorDefault,
+}
+
+// Only the rows which would look different are drawn, the rest of 'uibuf' is
+// kept as it is. A row is blanked only past the end of its line.
 func (v *view) draw_contents() {
 	if len(v.highlight_bytes) == 0 {
 		v.highlight_ranges = v.highlight_ranges[:0]
 	}
 
-	// clear the buffer
-	v.uibuf.Fill(v.uibuf.Rect, termbox.Cell{
-		Ch: ' ',
-		Fg: termbox.ColorDefault,
-		Bg: termbox.ColorDefault,
-	})
-
 	if v.uibuf.Width == 0 || v.uibuf.Height == 0 {
 		return
 	}
 
-	// draw lines
-	line := v.top_line
-	coff := 0
-	for y, h := 0, v.height(); y < h; y++ {
-		if line == nil {
-			break
-		}
+	if !bytes.Equal(v.drawn_highlight, v.highlight_bytes) ||
+		v.drawn_glyphs != settings.glyphs ||
+		v.drawn_gutter != v.gutter_width() {
+		v.invalidate_drawn_rows()
+		v.drawn_highlight = append(v.drawn_highlight[:0], v.highlight_bytes...)
+		v.drawn_glyphs = settings.glyphs
+		v.drawn_gutter = v.gutter_width()
+	}
 
+	h := v.height()
+	if len(v.drawn_rows) != h {
+		v.drawn_rows = make([]drawn_row, h)
+	}
+
+	// draw lines, a wrapped line takes several rows
+	gw := v.gutter_width()
+	line, line_num := v.top_line, v.top_line_num
+	rows, ri := v.line_rows(line), 0
+	coff := 0
+	for y := 0; y < h; y++ {
+		line_voffset := 0
 		if line == v.cursor.line {
 			// special case, cursor line
-			v.draw_line(line, v.top_line_num+y, coff, v.line_voffset)
-		} else {
-			v.draw_line(line, v.top_line_num+y, coff, 0)
+			line_voffset = v.line_voffset
+		}
+		if v.wraps() && line != nil {
+			// rows of a line differ in the part of it they draw
+			line_voffset = rows[ri].vo
+		}
+
+		row := &v.drawn_rows[y]
+		if !row.same(line, line_num, line_voffset) {
+			v.draw_gutter(line, line_num, ri == 0, coff)
+			drawn := 0
+			if line != nil {
+				end := len(line.data)
+				if ri+1 < len(rows) {
+					end = rows[ri+1].bo
+				}
+				drawn = v.draw_line(line, line_num, coff+gw, line_voffset, rows[ri], end)
+			}
+			blank := v.uibuf.Cells[coff+gw+drawn : coff+v.uibuf.Width]
+			for i := range blank {
+				blank[i] = blank_cell
+			}
+			row.set(line, line_num, line_voffset)
 		}
 
 		coff += v.uibuf.Width
-		line = line.next
+		if line != nil {
+			if ri++; ri == len(rows) {
+				line, line_num = line.next, line_num+1
+				ri = 0
+				if line != nil {
+					rows = v.line_rows(line)
+				}
+			}
+		}
+	}
+}
+
+func (r *drawn_row) same(line *line, line_num, line_voffset int) bool {
+	if !r.valid || r.line != line {
+		return false
+	}
+	if line == nil {
+		return true
 	}
+	return r.line_num == line_num && r.line_voffset == line_voffset &&
+		r.changed == line.changed && bytes.Equal(r.data, line.data)
+}
+
+func (r *drawn_row) set(line *line, line_num, line_voffset int) {
+	r.valid = true
+	r.line = line
+	r.data = r.data[:0]
+	r.changed = false
+	if line != nil {
+		r.data = append(r.data, line.data...)
+		r.changed = line.changed
+	}
+	r.line_num = line_num
+	r.line_voffset = line_voffset
+}
+
+// Makes the next 'draw_contents' draw every row, for changes it can't detect
+// by itself (tags, view size).
+func (v *view) invalidate_drawn_rows() {
+	for i := range v.drawn_rows {
+		v.drawn_rows[i].valid = false
+	}
+}
+
+// The region is drawn reversed in transient mark mode while the mark is active,
+// the rows are redrawn when its span changes.
+func (v *view) update_region_highlight() {
+	var beg, end cursor_location
+	if settings.transient_mark && v.buf.is_region_active() {
+		beg, end = swap_cursors_maybe(v.cursor, v.buf.mark)
+	}
+	if beg != v.region_beg || end != v.region_end {
+		v.invalidate_drawn_rows()
+		v.dirty |= dirty_contents
+		v.region_beg, v.region_end = beg, end
+	}
+}
+
+func (v *view) in_region(line, offset int) bool {
+	beg, end := &v.region_beg, &v.region_end
+	if beg.line == nil || line < beg.line_num || line > end.line_num {
+		return false
+	}
+	if line == beg.line_num && offset < beg.boffset {
+		return false
+	}
+	return line != end.line_num || offset < end.boffset
 }
 
 func (v *view) draw_status() {
@@ -369,14 +664,14 @@ func (v *view) draw_status() {
 		return
 	}
 
-	// fill background with '-'
+	// fill the background
 	lp := default_label_params
 	lp.Bg = termbox.AttrReverse
 	lp.Fg = termbox.AttrReverse | termbox.AttrBold
 	v.uibuf.Fill(tulib.Rect{0, v.height(), v.uibuf.Width, 1}, termbox.Cell{
 		Fg: termbox.AttrReverse,
 		Bg: termbox.AttrReverse,
-		Ch: '-',
+		Ch: settings.glyphs.status_fill,
 	})
 
 	// on disk sync status
@@ -397,14 +692,110 @@ func (v *view) draw_status() {
 	namel := v.tmpbuf.Len()
 	lp.Fg = termbox.AttrReverse
 	v.tmpbuf.Reset()
-	fmt.Fprintf(&v.tmpbuf, "(%d, %d)  ", v.cursor.line_num, v.cursor_voffset)
+	fmt.Fprintf(&v.tmpbuf, "(L%d, %s)  ", v.cursor.line_num, v.column_status())
 	v.uibuf.DrawLabel(tulib.Rect{5 + namel, v.height(), v.uibuf.Width, 1},
 		&lp, v.tmpbuf.Bytes())
+	posl := v.tmpbuf.Len()
+	v.tmpbuf.Reset()
+
+	// active modes
+	for _, m := range mode_indicators {
+		if !m.on(v) {
+			continue
+		}
+		if v.tmpbuf.Len() == 0 {
+			v.tmpbuf.WriteByte('[')
+		} else {
+			v.tmpbuf.WriteByte(' ')
+		}
+		v.tmpbuf.WriteString(m.name)
+	}
+	model := 0
+	if v.tmpbuf.Len() != 0 {
+		v.tmpbuf.WriteString("]  ")
+		v.uibuf.DrawLabel(tulib.Rect{5 + namel + posl, v.height(), v.uibuf.Width, 1},
+			&lp, v.tmpbuf.Bytes())
+		model = v.tmpbuf.Len()
+		v.tmpbuf.Reset()
+	}
+
+	// position in the buffer, on the right unless there is no room for it
+	v.tmpbuf.WriteString(v.position_status())
+	x := v.uibuf.Width - v.tmpbuf.Len() - 2
+	if x >= 5+namel+posl+model {
+		v.uibuf.DrawLabel(tulib.Rect{x, v.height(), v.tmpbuf.Len(), 1},
+			&lp, v.tmpbuf.Bytes())
+	}
 	v.tmpbuf.Reset()
 }
 
+// The cursor line out of the number of lines and how far into the buffer it
+// is, e.g. "L42/1000 4%".
+func (v *view) position_status() string {
+	return fmt.Sprintf("L%d/%d %d%%", v.cursor.line_num, v.buf.lines_n,
+		v.cursor.line_num*100/v.buf.lines_n)
+}
+
+// The cursor column as it's shown to the user, see 'settings.column_chars' and
+// 'settings.column_one_based'.
+func (v *view) cursor_column() int {
+	col := v.cursor_voffset
+	if settings.column_chars {
+		col = v.cursor_coffset
+	}
+	if settings.column_one_based {
+		col++
+	}
+	return col
+}
+
+// The cursor column for the status bar, with 'settings.column_both' it's
+// "C<character>/<visual>" when the two differ.
+func (v *view) column_status() string {
+	co, vo := v.cursor_coffset, v.cursor_voffset
+	if settings.column_one_based {
+		co, vo = co+1, vo+1
+	}
+	switch {
+	case settings.column_both && co != vo:
+		return fmt.Sprintf("C%d/%d", co, vo)
+	case settings.status_char_column:
+		return fmt.Sprintf("C%d", co)
+	}
+	return fmt.Sprintf("%s%d", column_label(), v.cursor_column())
+}
+
+// "C" for the visual column, "Ch" for the character one.
+func column_label() string {
+	if settings.column_chars {
+		return "Ch"
+	}
+	return "C"
+}
+
+// Indicators of the active modes shown in the status bar, the ones where 'on'
+// reports true are drawn in this order. Whatever changes a mode must mark the
+// affected views with 'dirty_status'.
+var mode_indicators = []struct {
+	name string
+	on   func(v *view) bool
+}{
+	{"TM", func(v *view) bool { return settings.transient_mark }},
+	{"PG", func(v *view) bool { return settings.full_page_scroll }},
+	{"ST", func(v *view) bool { return settings.smart_tab }},
+	{"AI", func(v *view) bool { return v.buf.auto_indent }},
+	{"EI", func(v *view) bool { return v.buf.electric_indent }},
+	{"TR", func(v *view) bool { return v.buf.line_display == line_display_truncate }},
+	{"WR", func(v *view) bool { return v.wrap }},
+	{"ET", func(v *view) bool { return settings.expand_tabs }},
+	{"CRLF", func(v *view) bool { return v.buf.eol == eol_crlf }},
+	{"RO", func(v *view) bool { return v.buf.readonly }},
+}
+
 // Draw the current view to the 'v.uibuf'.
 func (v *view) draw() {
+	v.update_bracket_match()
+	v.update_region_highlight()
 	if v.dirty&dirty_contents != 0 {
 		v.dirty &^= dirty_contents
 		v.draw_contents()
@@ -416,20 +807,209 @@ func (v *view) draw() {
 	}
 }
 
+func (v *view) line_is_visible(line_num int) bool {
+	if v.wraps() {
+		return v.line_is_visible_wrapped(line_num)
+	}
+	return line_num >= v.top_line_num && line_num < v.top_line_num+v.height()
+}
+
 // Center view on the cursor.
 func (v *view) center_view_on_cursor() {
+	if v.wraps() {
+		v.center_view_on_cursor_wrapped()
+		return
+	}
 	v.top_line = v.cursor.line
 	v.top_line_num = v.cursor.line_num
 	v.move_top_line_n_times(-v.height() / 2)
 	v.dirty = dirty_everything
 }
 
+// Moves the cursor to the column 'col' of its line, the column is 0-based and
+// visual or character one depending on 'settings.column_chars'. Stops at the
+// end of a shorter line.
+func (v *view) move_cursor_to_column(col int) {
+	c := v.cursor
+	if settings.column_chars {
+		c.boffset = 0
+		for ; col > 0 && !c.eol(); col-- {
+			c.move_one_rune_forward()
+		}
+	} else {
+		c.boffset, _, _ = c.line.find_closest_offsets(col, v.buf.tabstop)
+	}
+	v.move_cursor_to(c)
+}
+
 func (v *view) move_cursor_to_line(n int) {
 	v.move_cursor_beginning_of_file()
 	v.move_cursor_line_n_times(n - 1)
 	v.center_view_on_cursor()
 }
 
+// Moves the cursor to the line 'p' percent into the buffer, 0 is the first line
+// and 100 is the last one.
+func (v *view) move_cursor_to_percent(p int) {
+	if p < 0 {
+		p = 0
+	} else if p > 100 {
+		p = 100
+	}
+	v.move_cursor_to_line(1 + (v.buf.lines_n-1)*p/100)
+}
+
+// Moves the cursor to the first line of the next block of changed lines (see
+// 'line.changed'), or of the previous one if 'backward' is true.
+func (v *view) move_cursor_to_changed_line(backward bool) {
+	c := v.cursor
+	step := func() bool {
+		if backward {
+			if c.line.prev == nil {
+				return false
+			}
+			c.line = c.line.prev
+			c.line_num--
+		} else {
+			if c.line.next == nil {
+				return false
+			}
+			c.line = c.line.next
+			c.line_num++
+		}
+		return true
+	}
+
+	// leave the block the cursor is in, then find the next one
+	for c.line.changed {
+		if !step() {
+			v.ctx.set_status("No further changed lines")
+			return
+		}
+	}
+	for !c.line.changed {
+		if !step() {
+			v.ctx.set_status("No further changed lines")
+			return
+		}
+	}
+	for backward && c.line.prev != nil && c.line.prev.changed {
+		c.line = c.line.prev
+		c.line_num--
+	}
+
+	c.boffset = 0
+	v.jump_to(c)
+}
+
+// Pairs of brackets, an opening one maps to the closing one and vice versa.
+var matching_brackets = map[byte]byte{
+	'(': ')', '[': ']', '{': '}',
+	')': '(', ']': '[', '}': '{',
+}
+
+// The bracket under 'c' and its pair, false if there is no bracket.
+func bracket_under(c cursor_location) (open, match byte, ok bool) {
+	if c.eol() {
+		return 0, 0, false
+	}
+	open = c.line.data[c.boffset]
+	match, ok = matching_brackets[open]
+	return
+}
+
+// Finds the bracket matching the one under 'c': forward from an opening
+// bracket, backward from a closing one, giving up after 'max_lines' lines. The
+// matching is naive, brackets inside strings and comments count as well.
+func find_matching_bracket(c cursor_location, max_lines int) (cursor_location, bool) {
+	open, match, ok := bracket_under(c)
+	if !ok {
+		return c, false
+	}
+	backward := open == ')' || open == ']' || open == '}'
+
+	depth := 0
+	for {
+		if backward {
+			for c.boffset == 0 {
+				if c.line.prev == nil || max_lines == 0 {
+					return c, false
+				}
+				c.line = c.line.prev
+				c.line_num--
+				c.boffset = len(c.line.data)
+				max_lines--
+			}
+			c.boffset--
+		} else {
+			c.boffset++
+			for c.boffset >= len(c.line.data) {
+				if c.line.next == nil || max_lines == 0 {
+					return c, false
+				}
+				c.line = c.line.next
+				c.line_num++
+				c.boffset = 0
+				max_lines--
+				if len(c.line.data) > 0 {
+					break
+				}
+			}
+		}
+		switch c.line.data[c.boffset] {
+		case open:
+			depth++
+		case match:
+			if depth == 0 {
+				return c, true
+			}
+			depth--
+		}
+	}
+}
+
+// Moves the cursor to the bracket matching the one under it.
+func (v *view) goto_matching_bracket() {
+	if _, _, ok := bracket_under(v.cursor); !ok {
+		v.ctx.set_status("No bracket at the cursor")
+		return
+	}
+	c, ok := find_matching_bracket(v.cursor, v.buf.lines_n)
+	if !ok {
+		v.ctx.set_status("No matching bracket")
+		return
+	}
+	v.jump_to(c)
+}
+
+// Finds the bracket to highlight when the cursor moved or the contents changed,
+// the ones farther than the view height can't be visible and aren't looked
+// for. The rows are redrawn if it's another one.
+func (v *view) update_bracket_match() {
+	if v.cursor == v.bracket_cursor && v.dirty&dirty_contents == 0 {
+		return
+	}
+	v.bracket_cursor = v.cursor
+	c, ok := find_matching_bracket(v.cursor, v.height())
+	if !ok {
+		c = cursor_location{}
+	}
+	if c != v.bracket_match {
+		v.invalidate_drawn_rows()
+		v.dirty |= dirty_contents
+		v.bracket_match = c
+	}
+}
+
+// Moves the cursor to 'c', the view is centered on it if it wasn't visible.
+func (v *view) jump_to(c cursor_location) {
+	visible := v.line_is_visible(c.line_num)
+	v.move_cursor_to(c)
+	if !visible {
+		v.center_view_on_cursor()
+	}
+}
+
 // Move top line 'n' times forward or backward.
 func (v *view) move_top_line_n_times(n int) {
 	if n == 0 {
@@ -473,6 +1053,10 @@ func (v *view) move_cursor_line_n_times(n int) {
 // When 'top_line' was changed, call this function to possibly adjust the
 // 'cursor_line'.
 func (v *view) adjust_cursor_line() {
+	if v.wraps() {
+		v.adjust_cursor_line_wrapped()
+		return
+	}
 	vt := v.vertical_threshold()
 	cursor := v.cursor.line
 	co := v.cursor.line_num - v.top_line_num
@@ -488,7 +1072,7 @@ func (v *view) adjust_cursor_line() {
 
 	if cursor != v.cursor.line {
 		cursor = v.cursor.line
-		bo, co, vo := cursor.find_closest_offsets(v.last_cursor_voffset)
+		bo, co, vo := cursor.find_closest_offsets(v.last_cursor_voffset, v.buf.tabstop)
 		v.cursor.boffset = bo
 		v.cursor_coffset = co
 		v.cursor_voffset = vo
@@ -501,6 +1085,10 @@ func (v *view) adjust_cursor_line() {
 // When 'cursor_line' was changed, call this function to possibly adjust the
 // 'top_line'.
 func (v *view) adjust_top_line() {
+	if v.wraps() {
+		v.adjust_top_line_wrapped()
+		return
+	}
 	vt := v.vertical_threshold()
 	top := v.top_line
 	co := v.cursor.line_num - v.top_line_num
@@ -520,8 +1108,16 @@ func (v *view) adjust_top_line() {
 // When 'cursor_voffset' was changed usually > 0, then call this function to
 // possibly adjust 'line_voffset'.
 func (v *view) adjust_line_voffset() {
+	if v.buf.line_display != line_display_scroll || v.wraps() {
+		if v.line_voffset != 0 {
+			v.line_voffset = 0
+			v.dirty = dirty_everything
+		}
+		return
+	}
+
 	ht := v.horizontal_threshold()
-	w := v.uibuf.Width
+	w := v.width()
 	vo := v.line_voffset
 	cvo := v.cursor_voffset
 	threshold := w - 1
@@ -547,15 +1143,54 @@ func (v *view) adjust_line_voffset() {
 }
 
 func (v *view) cursor_position() (int, int) {
+	if v.wraps() {
+		x, y := v.wrapped_position(v.cursor, v.cursor_voffset)
+		return x + v.gutter_width(), y
+	}
 	y := v.cursor.line_num - v.top_line_num
 	x := v.cursor_voffset - v.line_voffset
-	return x, y
+	if w := v.width(); x >= w && w > 0 {
+		// truncated line, keep the cursor at the edge of the view
+		x = w - 1
+	}
+	return x + v.gutter_width(), y
 }
 
 func (v *view) cursor_position_for(cursor cursor_location) (int, int) {
+	if v.wraps() {
+		x, y := v.wrapped_position(cursor, cursor.voffset(v.buf.tabstop))
+		return x + v.gutter_width(), y
+	}
 	y := cursor.line_num - v.top_line_num
-	x := cursor.voffset() - v.line_voffset
-	return x, y
+	x := cursor.voffset(v.buf.tabstop) - v.line_voffset
+	return x + v.gutter_width(), y
+}
+
+// The inverse of 'cursor_position', finds a location for the cell at 'x', 'y'
+// (relative to the view). Rows past the end of the buffer map to its last
+// line, columns past the end of a line map to the end of it. A cell within a
+// rune that spans several cells (a tab) maps to the beginning of that rune,
+// the same way 'find_closest_offsets' never goes past the target voffset.
+func (v *view) location_at(x, y int) cursor_location {
+	x -= v.gutter_width()
+	if x < 0 {
+		x = 0
+	}
+	if v.wraps() {
+		return v.location_at_wrapped(x, y)
+	}
+
+	line, line_num := v.top_line, v.top_line_num
+	for i := 0; i < y && line.next != nil; i++ {
+		line = line.next
+		line_num++
+	}
+	if line == v.cursor.line {
+		// only the cursor line is scrolled horizontally
+		x += v.line_voffset
+	}
+	bo, _, _ := line.find_closest_offsets(x, v.buf.tabstop)
+	return cursor_location{line, line_num, bo}
 }
 
 // Move cursor to the 'boffset' position in the 'line'. Obviously 'line' must be
@@ -565,12 +1200,12 @@ func (v *view) cursor_position_for(cursor cursor_location) (int, int) {
 func (v *view) move_cursor_to(c cursor_location) {
 	v.dirty |= dirty_status
 	if c.boffset < 0 {
-		bo, co, vo := c.line.find_closest_offsets(v.last_cursor_voffset)
+		bo, co, vo := c.line.find_closest_offsets(v.last_cursor_voffset, v.buf.tabstop)
 		v.cursor.boffset = bo
 		v.cursor_coffset = co
 		v.cursor_voffset = vo
 	} else {
-		vo, co := c.voffset_coffset()
+		vo, co := c.voffset_coffset(v.buf.tabstop)
 		v.cursor.boffset = c.boffset
 		v.cursor_coffset = co
 		v.cursor_voffset = vo
@@ -582,9 +1217,15 @@ func (v *view) move_cursor_to(c cursor_location) {
 
 	if c.line != v.cursor.line {
 		if v.line_voffset != 0 {
+			v.scrolled_line = v.cursor.line
+			v.scrolled_voffset = v.line_voffset
 			v.dirty = dirty_everything
 		}
 		v.line_voffset = 0
+		if c.line == v.scrolled_line {
+			v.line_voffset = v.scrolled_voffset
+			v.dirty = dirty_everything
+		}
 	}
 	v.cursor.line = c.line
 	v.cursor.line_num = c.line_num
@@ -598,6 +1239,9 @@ func (v *view) move_cursor_to(c cursor_location) {
 			v.ac = nil
 		}
 	}
+	if settings.sync_location {
+		v.save_location()
+	}
 }
 
 // Move cursor one character forward.
@@ -701,33 +1345,32 @@ func (v *view) move_view_n_lines(n int) {
 	}
 }
 
-// Check if it's possible to move view 'n' lines forward or backward.
-func (v *view) can_move_top_line_n_times(n int) bool {
-	if n == 0 {
-		return true
-	}
-
-	top := v.top_line
-	for top.prev != nil && n < 0 {
-		top = top.prev
-		n++
-	}
-	for top.next != nil && n > 0 {
-		top = top.next
-		n--
+// Scroll view 'n' lines forward or backward. Both directions stop at the
+// buffer boundaries, when the view can't move at all, the cursor goes to the
+// end (or the beginning) of the buffer instead.
+func (v *view) scroll_view_n_lines(n int) {
+	prevtop := v.top_line_num
+	v.move_view_n_lines(n)
+	if prevtop != v.top_line_num {
+		return
 	}
-
-	if n != 0 {
-		return false
+	if n > 0 {
+		v.move_cursor_end_of_file()
+	} else if n < 0 {
+		v.move_cursor_beginning_of_file()
 	}
-	return true
 }
 
-// Move view 'n' lines forward or backward only if it's possible.
-func (v *view) maybe_move_view_n_lines(n int) {
-	if v.can_move_top_line_n_times(n) {
-		v.move_view_n_lines(n)
+// Number of lines C-v and M-v scroll by, see 'settings.full_page_scroll'.
+func (v *view) scroll_page_lines() int {
+	n := v.height() / 2
+	if settings.full_page_scroll {
+		n = v.height() - settings.scroll_overlap
+	}
+	if n < 1 {
+		n = 1
 	}
+	return n
 }
 
 func (v *view) maybe_next_action_group() {
@@ -743,6 +1386,7 @@ func (v *view) maybe_next_action_group() {
 	b.history.next = nil
 	b.history.actions = nil
 	b.history.before = v.cursor
+	b.trim_history(settings.undo_limit)
 }
 
 func (v *view) finalize_action_group() {
@@ -767,15 +1411,25 @@ func (v *view) undo() {
 	// undo action causes finalization, always
 	v.finalize_action_group()
 
+	// the flags restored by the actions of a group applied before the last
+	// save are out of date, the lines they touch differ from the disk now
+	past_save := b.saved_since(b.history)
+
 	// undo invariant tells us 'len(b.history.actions) != 0' in case if this is
 	// not a sentinel, revert the actions in the current action group
 	for i := len(b.history.actions) - 1; i >= 0; i-- {
 		a := &b.history.actions[i]
 		a.revert(v)
+		if past_save {
+			a.mark_changed()
+		}
 	}
 	v.move_cursor_to(b.history.before)
 	v.last_cursor_voffset = v.cursor_voffset
 	b.history = b.history.prev
+	if b.synced_with_disk() {
+		b.clear_changed_lines()
+	}
 	v.ctx.set_status("Undo!")
 }
 
@@ -801,10 +1455,27 @@ func (v *view) redo() {
 	}
 	v.move_cursor_to(b.history.after)
 	v.last_cursor_voffset = v.cursor_voffset
+	if b.synced_with_disk() {
+		b.clear_changed_lines()
+	}
 	v.ctx.set_status("Redo!")
 }
 
+// Reports whether the buffer may be changed, tells that it's read-only
+// otherwise. Commands check it before changing anything, 'action_insert' and
+// 'action_delete' refuse to change a read-only buffer anyway.
+func (v *view) check_writable() bool {
+	if v.buf.readonly {
+		v.ctx.set_status("Buffer is read-only")
+		return false
+	}
+	return true
+}
+
 func (v *view) action_insert(c cursor_location, data []byte) {
+	if !v.check_writable() {
+		return
+	}
 	if v.oneline {
 		data = bytes.Replace(data, []byte{'\n'}, nil, -1)
 	}
@@ -824,6 +1495,9 @@ func (v *view) action_insert(c cursor_location, data []byte) {
 }
 
 func (v *view) action_delete(c cursor_location, nbytes int) {
+	if !v.check_writable() {
+		return
+	}
 	v.maybe_next_action_group()
 	d := c.extract_bytes(nbytes)
 	a := action{
@@ -852,10 +1526,17 @@ func (v *view) insert_rune(r rune) {
 		c.line_num++
 		c.boffset = 0
 
-		if r == '\n' {
+		if r == '\n' || v.buf.auto_indent {
 			i := index_first_non_space(prev.data)
-			if i > 0 {
-				autoindent := clone_byte_slice(prev.data[:i])
+			autoindent := clone_byte_slice(prev.data[:i])
+			if v.buf.electric_indent {
+				autoindent = electric_indent(autoindent, prev.data, c.line.data, v.buf.tabstop)
+			}
+			if i > 0 && i == len(prev.data) {
+				// don't leave the indentation of a blank line behind
+				v.action_delete(cursor_location{prev, c.line_num - 1, 0}, i)
+			}
+			if len(autoindent) > 0 {
 				v.action_insert(c, autoindent)
 				c.boffset += len(autoindent)
 			}
@@ -868,6 +1549,105 @@ func (v *view) insert_rune(r rune) {
 	v.dirty = dirty_everything
 }
 
+// Inserts 'data' at the cursor as a single action, the cursor goes after it.
+func (v *view) insert_bytes(data []byte) {
+	if v.oneline {
+		data = bytes.Replace(data, []byte{'\n'}, nil, -1)
+	}
+	c := v.cursor
+	v.action_insert(c, data)
+	if n := bytes.Count(data, []byte{'\n'}); n > 0 {
+		for i := 0; i < n; i++ {
+			c.line = c.line.next
+		}
+		c.line_num += n
+		c.boffset = len(data) - bytes.LastIndexByte(data, '\n') - 1
+	} else {
+		c.boffset += len(data)
+	}
+	v.move_cursor_to(c)
+	v.dirty = dirty_everything
+}
+
+// Inserts the rune 'n' times as a single insertion, newlines are inserted one
+// by one, because they autoindent.
+func (v *view) insert_rune_times(r rune, n int) {
+	if n == 1 || r == '\n' || r == '\r' {
+		for i := 0; i < n; i++ {
+			v.insert_rune(r)
+		}
+		return
+	}
+	var data [utf8.UTFMax]byte
+	l := utf8.EncodeRune(data[:], r)
+	c := v.cursor
+	v.action_insert(c, bytes.Repeat(data[:l], n))
+	c.boffset += l * n
+	v.move_cursor_to(c)
+	v.dirty = dirty_everything
+}
+
+// Splits the line at the cursor, which stays before the newline. The numeric
+// argument opens that many lines.
+func (v *view) open_line() {
+	if v.oneline {
+		return
+	}
+	c := v.cursor
+	v.action_insert(c, bytes.Repeat([]byte{'\n'}, v.repeat_count()))
+	v.move_cursor_to(c)
+	v.dirty = dirty_everything
+}
+
+// Inserts the rune as it is, without autoindentation or any other special
+// treatment of newlines.
+func (v *view) quoted_insert(r rune) {
+	var data [utf8.UTFMax]byte
+	l := utf8.EncodeRune(data[:], r)
+	text := bytes.Repeat(data[:l], v.repeat_count())
+	v.insert_text(text)
+	v.dirty = dirty_everything
+}
+
+// How many times the command of the current key is repeated, see
+// 'prefix_arg'.
+func (v *view) repeat_count() int {
+	if v.prefix_arg > 0 {
+		return v.prefix_arg
+	}
+	return 1
+}
+
+// Adjusts 'indent' copied from the 'prev' line for the 'next' line: one more
+// level after an opening bracket, one less before a closing one.
+func electric_indent(indent, prev, next []byte, tabstop int) []byte {
+	width := indent_width(indent, tabstop)
+	levels := 0
+	prev = prev[:index_last_non_space(prev)+1]
+	if len(prev) > 0 {
+		switch prev[len(prev)-1] {
+		case '{', '(', '[':
+			levels++
+		}
+	}
+
+	next = next[index_first_non_space(next):]
+	if len(next) > 0 {
+		switch next[0] {
+		case '}', ')', ']':
+			levels--
+		}
+	}
+	if levels == 0 {
+		return indent
+	}
+	width += levels * settings.shift_width
+	if width < 0 {
+		width = 0
+	}
+	return make_indent(width, tabstop)
+}
+
 // If at the beginning of the line, move contents of the current line to the end
 // of the previous line. Otherwise, erase one character backward.
 func (v *view) delete_rune_backward() {
@@ -922,41 +1702,106 @@ func (v *view) kill_line() {
 	if !c.eol() {
 		// kill data from the cursor to the EOL
 		len := len(c.line.data) - c.boffset
-		v.append_to_kill_buffer(c, len)
+		v.append_to_kill_ring(c, len)
 		v.action_delete(c, len)
 		v.dirty = dirty_everything
 		return
 	}
-	v.append_to_kill_buffer(c, 1)
+	v.append_to_kill_ring(c, 1)
 	v.delete_rune()
 }
 
+// Kills the cursor line with its newline, wherever the cursor is on it. The
+// last line has no newline, it's killed to the end of the buffer.
+func (v *view) kill_whole_line() {
+	c := v.cursor
+	c.boffset = 0
+	n := len(c.line.data)
+	if c.line.next != nil {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	v.append_to_kill_ring(c, n)
+	v.action_delete(c, n)
+	v.move_cursor_to(c)
+	v.dirty = dirty_everything
+}
+
+// On a blank line deletes the blank lines around it but one, or the line itself
+// if it's the only one. On a non-blank line deletes the blank lines right after
+// it. Blank lines may have whitespace.
+func (v *view) delete_blank_lines() {
+	c := v.cursor
+	blank := is_blank_line(c.line)
+	beg := cursor_location{c.line, c.line_num, 0}
+	if !blank {
+		beg.boffset = len(c.line.data)
+	}
+	for blank && beg.line.prev != nil && is_blank_line(beg.line.prev) {
+		beg.line = beg.line.prev
+		beg.line_num--
+	}
+	end := cursor_location{c.line, c.line_num, 0}
+	for end.line.next != nil && is_blank_line(end.line.next) {
+		end.line = end.line.next
+		end.line_num++
+	}
+	end.boffset = len(end.line.data)
+
+	if blank && beg.line == end.line {
+		// the only blank line goes away with its newline
+		switch {
+		case end.line.next != nil:
+			end = cursor_location{end.line.next, end.line_num + 1, 0}
+		case beg.line.prev != nil:
+			beg.line = beg.line.prev
+			beg.line_num--
+			beg.boffset = len(beg.line.data)
+		}
+	}
+
+	d := beg.distance(end)
+	if d == 0 {
+		return
+	}
+	v.action_delete(beg, d)
+	if blank {
+		v.move_cursor_to(beg)
+	}
+	v.dirty = dirty_everything
+}
+
 func (v *view) kill_word() {
 	c1 := v.cursor
 	c2 := c1
 	c2.move_one_word_forward()
 	d := c1.distance(c2)
 	if d > 0 {
-		v.append_to_kill_buffer(c1, d)
+		v.append_to_kill_ring(c1, d)
 		v.action_delete(c1, d)
 	}
 }
 
 func (v *view) kill_word_backward() {
 	c2 := v.cursor
+	if c2.first_line() && c2.bol() {
+		v.ctx.set_status("Beginning of buffer")
+		return
+	}
 	c1 := c2
 	c1.move_one_word_backward()
 	d := c1.distance(c2)
 	if d > 0 {
-		v.prepend_to_kill_buffer(c1, d)
+		v.prepend_to_kill_ring(c1, d)
 		v.action_delete(c1, d)
 		v.move_cursor_to(c1)
 	}
 }
 
 func (v *view) kill_region() {
-	if !v.buf.is_mark_set() {
-		v.ctx.set_status("The mark is not set now, so there is no region")
+	if !v.check_region() {
 		return
 	}
 
@@ -968,17 +1813,46 @@ func (v *view) kill_region() {
 		return
 	case d < 0:
 		d = -d
-		v.append_to_kill_buffer(c2, d)
+		v.append_to_kill_ring(c2, d)
 		v.action_delete(c2, d)
 		v.move_cursor_to(c2)
 	default:
-		v.append_to_kill_buffer(c1, d)
+		v.append_to_kill_ring(c1, d)
 		v.action_delete(c1, d)
 	}
 }
 
+// Deletes everything before and after the region, both deletions are one undo
+// step. The cursor and the mark stay at the ends of the region.
+func (v *view) keep_region() {
+	if !v.check_region() {
+		return
+	}
+
+	beg, end := swap_cursors_maybe(v.cursor, v.buf.mark)
+	at_beg := v.cursor == beg
+	last := v.buf.last_line
+	eob := cursor_location{last, v.buf.lines_n, len(last.data)}
+	if d := end.distance(eob); d > 0 {
+		v.action_delete(end, d)
+	}
+	bob := cursor_location{v.buf.first_line, 1, 0}
+	if d := bob.distance(beg); d > 0 {
+		v.action_delete(bob, d)
+	}
+
+	last = v.buf.last_line
+	if at_beg {
+		v.move_cursor_to(cursor_location{v.buf.first_line, 1, 0})
+	} else {
+		v.move_cursor_to(cursor_location{last, v.buf.lines_n, len(last.data)})
+	}
+	v.dirty = dirty_everything
+}
+
 func (v *view) set_mark() {
 	v.buf.mark = v.cursor
+	v.buf.mark_active = true
 	v.ctx.set_status("Mark set")
 }
 
@@ -986,10 +1860,144 @@ func (v *view) swap_cursor_and_mark() {
 	if v.buf.is_mark_set() {
 		m := v.buf.mark
 		v.buf.mark = v.cursor
+		v.buf.mark_active = true
 		v.move_cursor_to(m)
 	}
 }
 
+// Returns true if there is a region to operate on, otherwise sets the status
+// explaining why there is none.
+func (v *view) check_region() bool {
+	if !v.buf.is_mark_set() {
+		v.ctx.set_status("The mark is not set now, so there is no region")
+		return false
+	}
+	if !v.buf.is_region_active() {
+		v.ctx.set_status("The mark is not active now")
+		return false
+	}
+	return true
+}
+
+func (v *view) keyboard_quit() {
+	v.ac = nil
+	v.buf.mark_active = false
+	v.ctx.set_status("Quit")
+}
+
+func (v *view) toggle_transient_mark() {
+	settings.transient_mark = !settings.transient_mark
+	v.buf.mark_active = false
+	v.ctx.set_status("Transient Mark mode %s",
+		enabled_or_disabled(settings.transient_mark))
+}
+
+func (v *view) toggle_full_page_scroll() {
+	settings.full_page_scroll = !settings.full_page_scroll
+	if settings.full_page_scroll {
+		v.ctx.set_status("Full page scrolling (%d lines of overlap)",
+			settings.scroll_overlap)
+	} else {
+		v.ctx.set_status("Half page scrolling")
+	}
+}
+
+func (v *view) toggle_smart_tab() {
+	settings.smart_tab = !settings.smart_tab
+	v.ctx.set_status("Smart tab %s", enabled_or_disabled(settings.smart_tab))
+}
+
+func (v *view) toggle_auto_indent() {
+	v.buf.auto_indent = !v.buf.auto_indent
+	v.ctx.set_status("Auto indent %s in %s",
+		enabled_or_disabled(v.buf.auto_indent), v.buf.name)
+}
+
+func (v *view) toggle_electric_indent() {
+	v.buf.electric_indent = !v.buf.electric_indent
+	v.ctx.set_status("Electric indent %s in %s",
+		enabled_or_disabled(v.buf.electric_indent), v.buf.name)
+}
+
+// Switches the line endings the buffer is saved with between "\n" and "\r\n",
+// the buffer stays modified until it's saved.
+func (v *view) toggle_crlf() {
+	b := v.buf
+	if b.eol == eol_crlf {
+		b.eol = eol_lf
+	} else {
+		b.eol = eol_crlf
+	}
+	b.on_disk = nil
+	for _, bv := range b.views {
+		bv.dirty |= dirty_status
+	}
+	v.ctx.set_status("CRLF line endings %s in %s",
+		enabled_or_disabled(b.eol == eol_crlf), b.name)
+}
+
+func (v *view) toggle_readonly() {
+	b := v.buf
+	b.readonly = !b.readonly
+	for _, bv := range b.views {
+		bv.dirty |= dirty_status
+	}
+	v.ctx.set_status("Read-only mode %s in %s", enabled_or_disabled(b.readonly), b.name)
+}
+
+func (v *view) toggle_truncate_lines() {
+	v.toggle_line_display(line_display_truncate)
+	v.ctx.set_status("Truncate long lines %s in %s",
+		enabled_or_disabled(v.buf.line_display == line_display_truncate), v.buf.name)
+}
+
+func (v *view) toggle_wrap_lines() {
+	v.wrap = !v.wrap
+	v.adjust_line_voffset()
+	v.adjust_top_line()
+	v.invalidate_drawn_rows()
+	v.dirty = dirty_everything
+	v.ctx.set_status("Wrap long lines %s in this window", enabled_or_disabled(v.wrap))
+}
+
+func (v *view) toggle_line_numbers() {
+	v.show_line_numbers = !v.show_line_numbers
+	v.gutter_changed()
+	v.ctx.set_status("Line numbers %s in this window", enabled_or_disabled(v.show_line_numbers))
+}
+
+// Switches the buffer to the line display mode 'm', or back to scrolling if
+// it's in that mode already.
+func (v *view) toggle_line_display(m line_display_mode) {
+	b := v.buf
+	if b.line_display == m {
+		b.line_display = line_display_scroll
+	} else {
+		b.line_display = m
+	}
+	for _, bv := range b.views {
+		bv.adjust_line_voffset()
+		bv.adjust_top_line()
+		bv.invalidate_drawn_rows()
+		bv.dirty = dirty_everything
+	}
+}
+
+// Changes the tab width of the buffer, cursors of all its views stay at the
+// same byte offsets, their visual offsets are recomputed.
+func (v *view) set_tabstop(n int) {
+	b := v.buf
+	b.tabstop = n
+	for _, bv := range b.views {
+		bv.move_cursor_to(bv.cursor)
+		bv.invalidate_drawn_rows()
+		bv.dirty = dirty_everything
+	}
+	b.loc.cursor_voffset, b.loc.cursor_coffset = b.loc.cursor.voffset_coffset(n)
+	b.loc.last_cursor_voffset = b.loc.cursor_voffset
+	v.ctx.set_status("Tab width is %d columns in %s", n, b.name)
+}
+
 func (v *view) on_insert_adjust_top_line(a *action) {
 	if a.cursor.line_num < v.top_line_num && len(a.lines) > 0 {
 		// inserted one or more lines above the view
@@ -1074,11 +2082,35 @@ func (v *view) on_delete(a *action) {
 	v.dirty = dirty_everything
 }
 
-func (v *view) on_vcommand(cmd vcommand, arg rune) {
+func (v *view) on_vcommand(cmd vcommand, arg rune) {
+	if cmd == vcommand_repeat_last {
+		v.repeat_last()
+		return
+	}
+	if cmd.modifies_buffer() && !v.check_writable() {
+		return
+	}
+
+	if n := v.repeat_count(); n > 1 && cmd.repeats() {
+		// from the second time on the command follows itself, so that the
+		// kills make one kill ring entry
+		v.prefix_arg = 0
+		for i := 0; i < n; i++ {
+			v.on_vcommand(cmd, arg)
+		}
+		v.prefix_arg = n
+		v.repeat_prefix_arg = n
+		return
+	}
+
 	last_class := v.last_vcommand.class()
 	if cmd.class() != last_class || last_class == vcommand_class_misc {
 		v.finalize_action_group()
 	}
+	if h := v.buf.history; settings.undo_pause > 0 && len(h.actions) != 0 &&
+		time.Since(h.last) > settings.undo_pause {
+		v.finalize_action_group()
+	}
 
 	switch cmd {
 	case vcommand_move_cursor_forward:
@@ -1103,10 +2135,20 @@ func (v *view) on_vcommand(cmd vcommand, arg rune) {
 		v.move_cursor_end_of_file()
 	case vcommand_move_cursor_to_line:
 		v.move_cursor_to_line(int(arg))
-	case vcommand_move_view_half_forward:
-		v.maybe_move_view_n_lines(v.height() / 2)
-	case vcommand_move_view_half_backward:
-		v.move_view_n_lines(-v.height() / 2)
+	case vcommand_move_cursor_matching_bracket:
+		v.goto_matching_bracket()
+	case vcommand_move_cursor_to_column:
+		v.move_cursor_to_column(int(arg))
+	case vcommand_move_cursor_to_percent:
+		v.move_cursor_to_percent(int(arg))
+	case vcommand_move_cursor_next_changed_line:
+		v.move_cursor_to_changed_line(false)
+	case vcommand_move_cursor_prev_changed_line:
+		v.move_cursor_to_changed_line(true)
+	case vcommand_move_view_page_forward:
+		v.scroll_view_n_lines(v.scroll_page_lines())
+	case vcommand_move_view_page_backward:
+		v.scroll_view_n_lines(-v.scroll_page_lines())
 	case vcommand_set_mark:
 		v.set_mark()
 	case vcommand_swap_cursor_and_mark:
@@ -1114,23 +2156,45 @@ func (v *view) on_vcommand(cmd vcommand, arg rune) {
 	case vcommand_recenter:
 		v.center_view_on_cursor()
 	case vcommand_insert_rune:
-		v.insert_rune(arg)
+		v.insert_rune_times(arg, v.repeat_count())
+	case vcommand_quoted_insert:
+		v.quoted_insert(arg)
 	case vcommand_yank:
 		v.yank()
+	case vcommand_yank_pop:
+		v.yank_pop()
+	case vcommand_yank_rectangle:
+		v.yank_rectangle()
+	case vcommand_open_line:
+		v.open_line()
 	case vcommand_delete_rune_backward:
 		v.delete_rune_backward()
 	case vcommand_delete_rune:
 		v.delete_rune()
 	case vcommand_kill_line:
 		v.kill_line()
+	case vcommand_kill_whole_line:
+		v.kill_whole_line()
+	case vcommand_delete_blank_lines:
+		v.delete_blank_lines()
 	case vcommand_kill_word:
 		v.kill_word()
 	case vcommand_kill_word_backward:
 		v.kill_word_backward()
 	case vcommand_kill_region:
 		v.kill_region()
+	case vcommand_kill_rectangle:
+		v.kill_rectangle()
 	case vcommand_copy_region:
 		v.copy_region()
+	case vcommand_copy_to_register:
+		v.copy_to_register(arg, false)
+	case vcommand_kill_to_register:
+		v.copy_to_register(arg, true)
+	case vcommand_insert_register:
+		v.insert_register(arg)
+	case vcommand_insert_pasted:
+		v.insert_pasted(v.repeat_text)
 	case vcommand_undo:
 		v.undo()
 	case vcommand_redo:
@@ -1144,10 +2208,26 @@ func (v *view) on_vcommand(cmd vcommand, arg rune) {
 		v.ac.move_cursor_up()
 	case vcommand_autocompl_move_cursor_down:
 		v.ac.move_cursor_down()
+	case vcommand_toggle_comment_line:
+		v.toggle_comment_line()
+	case vcommand_comment_region:
+		v.comment_region()
+	case vcommand_fill_paragraph:
+		v.fill_paragraph(settings.fill_column)
+	case vcommand_delete_trailing_whitespace:
+		v.delete_trailing_whitespace()
+	case vcommand_keep_region:
+		v.keep_region()
 	case vcommand_indent_region:
 		v.indent_region()
 	case vcommand_deindent_region:
 		v.deindent_region()
+	case vcommand_reindent_region:
+		v.reindent_region()
+	case vcommand_tabify:
+		v.retab(true, arg != 0)
+	case vcommand_untabify:
+		v.retab(false, arg != 0)
 	case vcommand_region_to_upper:
 		v.region_to(bytes.ToUpper)
 	case vcommand_region_to_lower:
@@ -1155,108 +2235,121 @@ func (v *view) on_vcommand(cmd vcommand, arg rune) {
 	case vcommand_word_to_upper:
 		v.word_to(bytes.ToUpper)
 	case vcommand_word_to_title:
-		v.word_to(func(s []byte) []byte {
-			return bytes.Title(bytes.ToLower(s))
-		})
+		v.word_to(capitalize)
 	case vcommand_word_to_lower:
 		v.word_to(bytes.ToLower)
+	case vcommand_transpose_chars:
+		v.transpose_chars()
+	case vcommand_join_line:
+		v.join_line()
+	case vcommand_duplicate_line:
+		v.duplicate_line()
+	case vcommand_move_line_up:
+		v.move_lines(false)
+	case vcommand_move_line_down:
+		v.move_lines(true)
+	case vcommand_toggle_transient_mark:
+		v.toggle_transient_mark()
+	case vcommand_toggle_full_page_scroll:
+		v.toggle_full_page_scroll()
+	case vcommand_toggle_smart_tab:
+		v.toggle_smart_tab()
+	case vcommand_toggle_auto_indent:
+		v.toggle_auto_indent()
+	case vcommand_toggle_electric_indent:
+		v.toggle_electric_indent()
+	case vcommand_toggle_truncate_lines:
+		v.toggle_truncate_lines()
+	case vcommand_toggle_wrap_lines:
+		v.toggle_wrap_lines()
+	case vcommand_toggle_line_numbers:
+		v.toggle_line_numbers()
+	case vcommand_toggle_crlf:
+		v.toggle_crlf()
+	case vcommand_toggle_readonly:
+		v.toggle_readonly()
+	case vcommand_set_tabstop:
+		v.set_tabstop(int(arg))
+	case vcommand_keyboard_quit:
+		v.keyboard_quit()
+	}
+
+	if settings.transient_mark && cmd.deactivates_mark() {
+		v.buf.mark_active = false
+	}
+	if settings.sync_location {
+		v.save_location()
 	}
-
 	v.last_vcommand = cmd
+	if cmd != vcommand_keyboard_quit {
+		v.repeat_vcommand, v.repeat_arg = cmd, arg
+		v.repeat_prefix_arg = v.prefix_arg
+	}
+}
+
+// Runs the last command again with the same arguments, a numeric argument
+// given to the repeat itself replaces the one of the command.
+func (v *view) repeat_last() {
+	if v.repeat_vcommand == vcommand_none {
+		v.ctx.set_status("No command to repeat")
+		return
+	}
+	n := v.prefix_arg
+	if n == 0 {
+		n = v.repeat_prefix_arg
+	}
+	v.prefix_arg = n
+	v.on_vcommand(v.repeat_vcommand, v.repeat_arg)
+	v.prefix_arg = 0
 }
 
 func (v *view) on_key(ev *termbox.Event) {
-	switch ev.Key {
-	case termbox.KeyCtrlF, termbox.KeyArrowRight:
-		v.on_vcommand(vcommand_move_cursor_forward, 0)
-	case termbox.KeyCtrlB, termbox.KeyArrowLeft:
-		v.on_vcommand(vcommand_move_cursor_backward, 0)
-	case termbox.KeyCtrlN, termbox.KeyArrowDown:
-		if v.ac != nil {
-			v.on_vcommand(vcommand_autocompl_move_cursor_down, 0)
-			break
+	k := key_of(ev)
+	if v.ac != nil {
+		if a, ok := autocompl_keys.lookup(k); ok {
+			a.do(v)
+			return
 		}
-		v.on_vcommand(vcommand_move_cursor_next_line, 0)
-	case termbox.KeyCtrlP, termbox.KeyArrowUp:
-		if v.ac != nil {
-			v.on_vcommand(vcommand_autocompl_move_cursor_up, 0)
-			break
+	}
+
+	if a, ok := view_keys.lookup(k); ok {
+		a.do(v)
+	} else if ev.Mod&termbox.ModAlt == 0 && ev.Ch != 0 {
+		v.on_vcommand(vcommand_insert_rune, ev.Ch)
+	}
+}
+
+// Tab inserts a tab, or spaces up to the next indentation stop when tabs are
+// expanded. In the smart tab mode it depends on the context: right after a
+// word (not within the leading whitespace) it starts autocompletion instead.
+// The numeric argument works as pressing <tab> that many times.
+func (v *view) on_tab() {
+	n := v.repeat_count()
+	v.prefix_arg = 0
+	for i := 0; i < n; i++ {
+		v.tab()
+	}
+	v.prefix_arg = n
+}
+
+func (v *view) tab() {
+	if settings.smart_tab && !v.oneline {
+		c := v.cursor
+		if c.boffset > index_first_non_space(c.line.data) {
+			if r, _ := c.rune_before(); is_word(r) {
+				v.on_vcommand(vcommand_autocompl_init, 0)
+				return
+			}
 		}
-		v.on_vcommand(vcommand_move_cursor_prev_line, 0)
-	case termbox.KeyCtrlE, termbox.KeyEnd:
-		v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
-	case termbox.KeyCtrlA, termbox.KeyHome:
-		v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
-	case termbox.KeyCtrlV, termbox.KeyPgdn:
-		v.on_vcommand(vcommand_move_view_half_forward, 0)
-	case termbox.KeyCtrlL:
-		v.on_vcommand(vcommand_recenter, 0)
-	case termbox.KeyCtrlSlash:
-		v.on_vcommand(vcommand_undo, 0)
-	case termbox.KeySpace:
-		v.on_vcommand(vcommand_insert_rune, ' ')
-	case termbox.KeyEnter, termbox.KeyCtrlJ:
-		c := '\n'
-		if ev.Key == termbox.KeyEnter {
-			// we use '\r' for <enter>, because it doesn't cause
-			// autoindent
-			c = '\r'
-		}
-		if v.ac != nil {
-			v.on_vcommand(vcommand_autocompl_finalize, 0)
-		} else {
-			v.on_vcommand(vcommand_insert_rune, c)
+	}
+	if settings.expand_tabs {
+		n := settings.shift_width - v.cursor_voffset%settings.shift_width
+		for i := 0; i < n; i++ {
+			v.on_vcommand(vcommand_insert_rune, ' ')
 		}
-	case termbox.KeyBackspace, termbox.KeyBackspace2:
-		if ev.Mod&termbox.ModAlt != 0 {
-			v.on_vcommand(vcommand_kill_word_backward, 0)
-		} else {
-			v.on_vcommand(vcommand_delete_rune_backward, 0)
-		}
-	case termbox.KeyDelete, termbox.KeyCtrlD:
-		v.on_vcommand(vcommand_delete_rune, 0)
-	case termbox.KeyCtrlK:
-		v.on_vcommand(vcommand_kill_line, 0)
-	case termbox.KeyPgup:
-		v.on_vcommand(vcommand_move_view_half_backward, 0)
-	case termbox.KeyTab:
-		v.on_vcommand(vcommand_insert_rune, '\t')
-	case termbox.KeyCtrlSpace:
-		if ev.Ch == 0 {
-			v.set_mark()
-		}
-	case termbox.KeyCtrlW:
-		v.on_vcommand(vcommand_kill_region, 0)
-	case termbox.KeyCtrlY:
-		v.on_vcommand(vcommand_yank, 0)
-	}
-
-	if ev.Mod&termbox.ModAlt != 0 {
-		switch ev.Ch {
-		case 'v':
-			v.on_vcommand(vcommand_move_view_half_backward, 0)
-		case '<':
-			v.on_vcommand(vcommand_move_cursor_beginning_of_file, 0)
-		case '>':
-			v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
-		case 'f':
-			v.on_vcommand(vcommand_move_cursor_word_forward, 0)
-		case 'b':
-			v.on_vcommand(vcommand_move_cursor_word_backward, 0)
-		case 'd':
-			v.on_vcommand(vcommand_kill_word, 0)
-		case 'w':
-			v.on_vcommand(vcommand_copy_region, 0)
-		case 'u':
-			v.on_vcommand(vcommand_word_to_upper, 0)
-		case 'l':
-			v.on_vcommand(vcommand_word_to_lower, 0)
-		case 'c':
-			v.on_vcommand(vcommand_word_to_title, 0)
-		}
-	} else if ev.Ch != 0 {
-		v.on_vcommand(vcommand_insert_rune, ev.Ch)
+		return
 	}
+	v.on_vcommand(vcommand_insert_rune, '\t')
 }
 
 func (v *view) dump_info() {
@@ -1306,27 +2399,32 @@ func (v *view) tag(line, offset int) *view_tag {
 
 func (v *view) make_cell(line, offset int, ch rune) termbox.Cell {
 	tag := v.tag(line, offset)
-	if tag != &default_view_tag {
-		return termbox.Cell{
-			Ch: ch,
-			Fg: tag.fg,
-			Bg: tag.bg,
-		}
-	}
-
 	cell := termbox.Cell{
 		Ch: ch,
 		Fg: tag.fg,
 		Bg: tag.bg,
 	}
-	if v.in_one_of_highlight_ranges(offset) {
-		cell.Fg = hl_fg
-		cell.Bg = hl_bg
+	if tag == &default_view_tag {
+		if v.in_one_of_highlight_ranges(offset) {
+			cell.Fg = hl_fg
+			cell.Bg = hl_bg
+		}
+		if m := v.bracket_match; m.line != nil && m.line_num == line && m.boffset == offset {
+			cell.Fg = bracket_fg
+			cell.Bg = bracket_bg
+		}
+	}
+	if v.in_region(line, offset) {
+		cell.Fg |= termbox.AttrReverse
+		cell.Bg |= termbox.AttrReverse
 	}
 	return cell
 }
 
-func (v *view) cleanup_trailing_whitespaces() {
+// Deletes spaces and tabs at the end of lines, returns the number of lines
+// changed.
+func (v *view) cleanup_trailing_whitespaces() int {
+	n := 0
 	cursor := cursor_location{
 		line:     v.buf.first_line,
 		line_num: 1,
@@ -1339,11 +2437,13 @@ func (v *view) cleanup_trailing_whitespaces() {
 		if i == -1 && len > 0 {
 			// the whole string is whitespace
 			v.action_delete(cursor, len)
+			n++
 		}
 		if i != -1 && i != len-1 {
 			// some whitespace at the end
 			cursor.boffset = i + 1
 			v.action_delete(cursor, len-cursor.boffset)
+			n++
 		}
 		cursor.line = cursor.line.next
 		cursor.line_num++
@@ -1356,6 +2456,16 @@ func (v *view) cleanup_trailing_whitespaces() {
 		cursor.boffset = len(cursor.line.data)
 		v.move_cursor_to(cursor)
 	}
+	return n
+}
+
+func (v *view) delete_trailing_whitespace() {
+	n := v.cleanup_trailing_whitespaces()
+	if n == 0 {
+		v.ctx.set_status("No trailing whitespace")
+		return
+	}
+	v.ctx.set_status("Deleted trailing whitespace on %d line(s)", n)
 }
 
 func (v *view) cleanup_trailing_newlines() {
@@ -1405,56 +2515,119 @@ func (v *view) presave_cleanup(raw bool) {
 	v.finalize_action_group()
 	v.last_vcommand = vcommand_none
 	if !raw {
-		v.cleanup_trailing_whitespaces()
+		if settings.trim_trailing_whitespace {
+			v.cleanup_trailing_whitespaces()
+		}
 		v.cleanup_trailing_newlines()
 		v.ensure_trailing_eol()
 		v.finalize_action_group()
 	}
 }
 
-func (v *view) append_to_kill_buffer(cursor cursor_location, nbytes int) {
-	kb := *v.ctx.kill_buffer
+func (v *view) append_to_kill_ring(cursor cursor_location, nbytes int) {
+	v.add_to_kill_ring(cursor.extract_bytes(nbytes), false)
+}
+
+func (v *view) prepend_to_kill_ring(cursor cursor_location, nbytes int) {
+	v.add_to_kill_ring(cursor.extract_bytes(nbytes), true)
+}
 
+// Consecutive kills go to the same entry of the kill ring, anything else
+// starts a new one.
+func (v *view) add_to_kill_ring(data []byte, prepend bool) {
 	switch v.last_vcommand {
-	case vcommand_kill_word, vcommand_kill_word_backward, vcommand_kill_region, vcommand_kill_line:
+	case vcommand_kill_word, vcommand_kill_word_backward, vcommand_kill_region,
+		vcommand_kill_line, vcommand_kill_whole_line:
+		v.ctx.kill_ring.add(data, prepend)
 	default:
-		kb = kb[:0]
+		v.ctx.kill_ring.push(data)
 	}
+}
 
-	kb = append(kb, cursor.extract_bytes(nbytes)...)
-	*v.ctx.kill_buffer = kb
+// Inserts pasted text as it is, without autoindent and autocompletion, one
+// undo step removes it.
+func (v *view) insert_pasted(text []byte) {
+	v.ac = nil
+	v.finalize_action_group()
+	v.insert_bytes(clone_byte_slice(text))
+	v.finalize_action_group()
 }
 
-func (v *view) prepend_to_kill_buffer(cursor cursor_location, nbytes int) {
-	kb := *v.ctx.kill_buffer
+// Inserts the most recent kill, the mark is left at the beginning of it (it
+// isn't activated), that's what 'yank_pop' replaces.
+func (v *view) yank() {
+	k := v.ctx.kill_ring
+	buf := k.latest()
+	if len(buf) == 0 {
+		return
+	}
+	k.yanked = len(k.entries) - 1
+	v.buf.mark = v.cursor
+	v.insert_text(buf)
+}
 
+// Right after a yank replaces the yanked text with the kill before it, going
+// around to the most recent one after the oldest.
+func (v *view) yank_pop() {
 	switch v.last_vcommand {
-	case vcommand_kill_word, vcommand_kill_word_backward, vcommand_kill_region, vcommand_kill_line:
+	case vcommand_yank, vcommand_yank_pop:
 	default:
-		kb = kb[:0]
+		v.ctx.set_status("Previous command was not a yank")
+		return
 	}
 
-	kb = append(cursor.extract_bytes(nbytes), kb...)
-	*v.ctx.kill_buffer = kb
+	k := v.ctx.kill_ring
+	if len(k.entries) == 0 {
+		return
+	}
+	beg := v.buf.mark
+	if d := beg.distance(v.cursor); d > 0 {
+		v.action_delete(beg, d)
+	}
+	k.yanked = (k.yanked + len(k.entries) - 1) % len(k.entries)
+	v.move_cursor_to(beg)
+	v.insert_text(k.entries[k.yanked])
 }
 
-func (v *view) yank() {
-	buf := *v.ctx.kill_buffer
+// Inserts a copy of 'data' at the cursor as one action, the cursor goes after
+// it.
+func (v *view) insert_text(data []byte) {
 	cursor := v.cursor
+	v.action_insert(cursor, clone_byte_slice(data))
+	cursor.move_n_bytes_forward(data)
+	v.move_cursor_to(cursor)
+}
 
-	if len(buf) == 0 {
+// Stores a copy of the region in the register 'r', deletes the region as well
+// if 'kill' is true.
+func (v *view) copy_to_register(r rune, kill bool) {
+	if !v.check_region() {
 		return
 	}
-	cbuf := clone_byte_slice(buf)
-	v.action_insert(cursor, cbuf)
-	cursor.move_n_bytes_forward(buf)
-	v.move_cursor_to(cursor)
+	beg, end := v.region()
+	d := beg.distance(end)
+	v.ctx.registers[r] = beg.extract_bytes(d)
+	if kill && d > 0 {
+		v.action_delete(beg, d)
+		v.move_cursor_to(beg)
+		v.ctx.set_status("Killed to register %c", r)
+		return
+	}
+	v.ctx.set_status("Copied to register %c", r)
+}
+
+func (v *view) insert_register(r rune) {
+	data, ok := v.ctx.registers[r]
+	if !ok {
+		v.ctx.set_status("Register %c is empty", r)
+		return
+	}
+	v.insert_text(data)
 }
 
 // shameless copy & paste from kill_region
 func (v *view) copy_region() {
-	if !v.buf.is_mark_set() {
-		v.ctx.set_status("The mark is not set now, so there is no region")
+	if !v.check_region() {
 		return
 	}
 
@@ -1466,21 +2639,32 @@ func (v *view) copy_region() {
 		return
 	case d < 0:
 		d = -d
-		v.append_to_kill_buffer(c2, d)
+		v.append_to_kill_ring(c2, d)
 	default:
-		v.append_to_kill_buffer(c1, d)
+		v.append_to_kill_ring(c1, d)
 	}
+	v.ctx.set_status("Region copied")
 }
 
 func (v *view) region_to(filter func([]byte) []byte) {
-	if !v.buf.is_mark_set() {
-		v.ctx.set_status("The mark is not set now, so there is no region")
+	if !v.check_writable() || !v.check_region() {
 		return
 	}
 	v.filter_text(v.cursor, v.buf.mark, filter)
 }
 
+// Returns a copy of the region contents or the whole buffer if there is no
+// region.
+func (v *view) region_or_buffer_contents() []byte {
+	if !v.buf.is_region_active() {
+		return v.buf.contents()
+	}
+	beg, end := v.region()
+	return beg.extract_bytes(beg.distance(end))
+}
+
 func (v *view) set_tags(tags ...view_tag) {
+	v.invalidate_drawn_rows()
 	v.tags = v.tags[:0]
 	if len(tags) == 0 {
 		return
@@ -1496,7 +2680,7 @@ func (v *view) set_tags(tags ...view_tag) {
 func (v *view) region() (beg, end cursor_location) {
 	beg = v.cursor
 	end = v.cursor
-	if v.buf.is_mark_set() {
+	if v.buf.is_region_active() {
 		end = v.buf.mark
 	}
 	beg, end = swap_cursors_maybe(beg, end)
@@ -1506,7 +2690,7 @@ func (v *view) region() (beg, end cursor_location) {
 func (v *view) line_region() (beg, end cursor_location) {
 	beg = v.cursor
 	end = v.cursor
-	if v.buf.is_mark_set() {
+	if v.buf.is_region_active() {
 		end = v.buf.mark
 	}
 	beg, end = swap_cursors_maybe(beg, end)
@@ -1516,27 +2700,88 @@ func (v *view) line_region() (beg, end cursor_location) {
 }
 
 func (v *view) indent_line(line cursor_location) {
-	line.boffset = 0
-	v.action_insert(line, []byte{'\t'})
-	if v.cursor.line == line.line {
-		cursor := v.cursor
-		cursor.boffset += 1
-		v.move_cursor_to(cursor)
-	}
+	v.set_line_indent(line, indent_width(line.line.data, v.buf.tabstop)+settings.shift_width)
 }
 
 func (v *view) deindent_line(line cursor_location) {
-	line.boffset = 0
-	if r, _ := line.rune_under(); r == '\t' {
-		v.action_delete(line, 1)
+	width := indent_width(line.line.data, v.buf.tabstop) - settings.shift_width
+	if width < 0 {
+		width = 0
+	}
+	v.set_line_indent(line, width)
+}
+
+// Comments or uncomments the cursor line with the buffer's comment prefix, the
+// prefix goes to the first non-whitespace column. The cursor stays on the same
+// character of the line.
+func (v *view) toggle_comment_line() {
+	prefix := []byte(v.buf.comment_prefix())
+	c := v.cursor
+	c.boffset = index_first_non_space(c.line.data)
+	rest := c.line.data[c.boffset:]
+	if bytes.HasPrefix(rest, prefix) {
+		n := len(prefix)
+		if len(rest) > n && rest[n] == ' ' {
+			n++
+		}
+		v.action_delete(c, n)
+		return
 	}
-	if v.cursor.line == line.line && v.cursor.boffset > 0 {
+
+	data := append(clone_byte_slice(prefix), ' ')
+	v.action_insert(c, data)
+	if v.cursor.boffset == c.boffset {
+		// insertion at the cursor doesn't move it, but we want to
 		cursor := v.cursor
-		cursor.boffset -= 1
+		cursor.boffset += len(data)
 		v.move_cursor_to(cursor)
 	}
 }
 
+// Comments the lines of the region (or the cursor line), or uncomments them if
+// the first one is commented. As with 'toggle_comment_line' the prefix goes to
+// the first non-whitespace column, blank lines are left alone.
+func (v *view) comment_region() {
+	p, ok := v.buf.comment_syntax()
+	if !ok {
+		v.ctx.set_status("Unknown comment syntax")
+		return
+	}
+	prefix := []byte(p)
+	beg, end := v.line_region()
+	first := beg.line.data[index_first_non_space(beg.line.data):]
+	uncomment := bytes.HasPrefix(first, prefix)
+
+	c := beg
+	for {
+		c.boffset = index_first_non_space(c.line.data)
+		rest := c.line.data[c.boffset:]
+		switch {
+		case uncomment && bytes.HasPrefix(rest, prefix):
+			n := len(prefix)
+			if len(rest) > n && rest[n] == ' ' {
+				n++
+			}
+			v.action_delete(c, n)
+		case !uncomment && len(rest) > 0:
+			data := append(clone_byte_slice(prefix), ' ')
+			v.action_insert(c, data)
+			if v.cursor.line == c.line && v.cursor.boffset == c.boffset {
+				// insertion at the cursor doesn't move it
+				cursor := v.cursor
+				cursor.boffset += len(data)
+				v.move_cursor_to(cursor)
+			}
+		}
+		if c.line == end.line {
+			break
+		}
+		c.line = c.line.next
+		c.line_num++
+	}
+	v.dirty = dirty_everything
+}
+
 func (v *view) indent_region() {
 	beg, end := v.line_region()
 	for beg.line != end.line {
@@ -1547,6 +2792,140 @@ func (v *view) indent_region() {
 	v.indent_line(end)
 }
 
+// Recomputes indentation of the region lines of C-like code using the bracket
+// nesting depth, starting from the depth after the first non-blank line above
+// the region. Case labels are one level to the left of the code they belong
+// to. Blank lines lose their whitespace.
+func (v *view) reindent_region() {
+	if !v.buf.has_brace_syntax() {
+		v.ctx.set_status("Reindent works with C-like code only")
+		return
+	}
+
+	beg, end := v.line_region()
+	depth := 0
+	for prev := beg.line.prev; prev != nil; prev = prev.prev {
+		data := prev.data[index_first_non_space(prev.data):]
+		if len(data) == 0 {
+			continue
+		}
+		_, rest := bracket_balance(data)
+		depth = indent_level(prev.data, v.buf.tabstop) + rest
+		if is_case_label(data) {
+			depth++
+		}
+		break
+	}
+
+	for {
+		i := index_first_non_space(beg.line.data)
+		data := beg.line.data[i:]
+		leading, rest := bracket_balance(data)
+		level := depth - leading
+		if is_case_label(data) {
+			level--
+		}
+		if level < 0 || len(data) == 0 {
+			level = 0
+		}
+		v.set_line_indent(beg, level*settings.shift_width)
+
+		depth -= leading
+		if depth < 0 {
+			depth = 0
+		}
+		depth += rest
+		if beg.line == end.line {
+			break
+		}
+		beg.line = beg.line.next
+		beg.line_num++
+	}
+}
+
+// Replaces the leading whitespace of the 'line' with the one 'width' cells
+// wide, leaves the line alone if that's what it has already. A cursor within
+// the old indentation goes to the end of the new one.
+func (v *view) set_line_indent(line cursor_location, width int) {
+	n := index_first_non_space(line.line.data)
+	indent := make_indent(width, v.buf.tabstop)
+	if bytes.Equal(line.line.data[:n], indent) {
+		return
+	}
+	line.boffset = 0
+	if n > 0 {
+		v.action_delete(line, n)
+	}
+	if len(indent) > 0 {
+		v.action_insert(line, indent)
+	}
+	if v.cursor.line == line.line {
+		cursor := v.cursor
+		if cursor.boffset < n {
+			cursor.boffset = len(indent)
+		} else {
+			cursor.boffset += len(indent) - n
+		}
+		v.move_cursor_to(cursor)
+	}
+}
+
+// Converts whitespace of the region lines, or of the whole buffer if there is
+// no region, to tabs or to spaces. Only indentation is converted unless
+// 'interior' is true.
+func (v *view) retab(tabs, interior bool) {
+	beg := cursor_location{v.buf.first_line, 1, 0}
+	end := cursor_location{v.buf.last_line, v.buf.lines_n, 0}
+	if v.buf.is_region_active() {
+		beg, end = v.line_region()
+	}
+
+	lines := 0
+	for {
+		runs := retab_runs(beg.line.data, tabs, interior, v.buf.tabstop)
+		if len(runs) > 0 {
+			v.retab_line(beg, runs)
+			lines++
+		}
+		if beg.line == end.line {
+			break
+		}
+		beg.line = beg.line.next
+		beg.line_num++
+	}
+
+	what := "Untabified"
+	if tabs {
+		what = "Tabified"
+	}
+	v.ctx.set_status("%s %d line(s)", what, lines)
+}
+
+// Replaces the 'runs' of the 'line', the cursor stays on the same character.
+func (v *view) retab_line(line cursor_location, runs []retab_run) {
+	cursor := v.cursor
+	for _, r := range runs {
+		if cursor.line != line.line || cursor.boffset <= r.beg {
+			break
+		}
+		if cursor.boffset < r.end {
+			cursor.boffset = r.end
+		}
+		cursor.boffset += len(r.data) - (r.end - r.beg)
+	}
+
+	// from the end, so that the offsets of the runs before stay valid
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		line.boffset = r.beg
+		v.action_delete(line, r.end-r.beg)
+		v.action_insert(line, r.data)
+	}
+	if cursor.line == line.line {
+		v.move_cursor_to(cursor)
+	}
+}
+
 func (v *view) deindent_region() {
 	beg, end := v.line_region()
 	for beg.line != end.line {
@@ -1557,6 +2936,108 @@ func (v *view) deindent_region() {
 	v.deindent_line(end)
 }
 
+// Swaps the rune before the cursor with the one under it and moves the cursor
+// forward, at the end of a line the two runes before the cursor are swapped.
+// A line break counts as a rune, nothing happens on an empty line.
+func (v *view) transpose_chars() {
+	c1, c2 := v.cursor, v.cursor
+	if c1.bol() && c1.eol() {
+		return
+	}
+	if c2.eol() {
+		c1.move_one_rune_backward()
+	} else {
+		c2.move_one_rune_forward()
+	}
+	mid := c1
+	c1.move_one_rune_backward()
+	if c1 == mid {
+		v.ctx.set_status("Beginning of buffer")
+		return
+	}
+
+	n := c1.distance(mid)
+	v.filter_text(c1, c2, func(data []byte) []byte {
+		return append(clone_byte_slice(data[n:]), data[:n]...)
+	})
+}
+
+// Joins the next line to the cursor one: the line break and the indentation
+// of the next line are replaced by a single space (nothing if either line is
+// empty). The cursor goes to the join point.
+func (v *view) join_line() {
+	c := v.cursor
+	next := c.line.next
+	if next == nil {
+		v.ctx.set_status("End of buffer")
+		return
+	}
+	c.boffset = len(c.line.data)
+	n := index_first_non_space(next.data)
+	rest := len(next.data) - n
+	v.action_delete(c, 1+n)
+	if c.boffset > 0 && rest > 0 {
+		v.action_insert(c, []byte{' '})
+	}
+	v.move_cursor_to(c)
+}
+
+// Inserts a copy of the cursor line after it, the cursor goes to the copy
+// keeping its column.
+func (v *view) duplicate_line() {
+	c := v.cursor
+	c.boffset = len(c.line.data)
+	v.action_insert(c, append([]byte{'\n'}, c.line.data...))
+	v.move_cursor_to(cursor_location{c.line.next, c.line_num + 1, v.cursor.boffset})
+}
+
+// Moves the cursor line (or the lines of the region, in the transient mark
+// mode only, where the region is what the user has just marked) one line down
+// or up by moving the line after (before) them to the other side. The cursor
+// and the mark move along with the lines, so the region stays active and the
+// command can be repeated.
+func (v *view) move_lines(down bool) {
+	cursor, mark := v.cursor, v.buf.mark
+	active := settings.transient_mark && v.buf.mark_active
+	beg, end := cursor, cursor
+	if active {
+		beg, end = swap_cursors_maybe(cursor, mark)
+	}
+	beg.boffset = 0
+	end.boffset = len(end.line.data)
+	d := 1
+	if down {
+		next := end.line.next
+		if next == nil {
+			v.ctx.set_status("End of buffer")
+			return
+		}
+		data := append(clone_byte_slice(next.data), '\n')
+		v.action_delete(end, 1+len(next.data))
+		beg.line, _ = v.buf.line_at(beg.line_num)
+		v.action_insert(beg, data)
+	} else {
+		prev := beg.line.prev
+		if prev == nil {
+			v.ctx.set_status("Beginning of buffer")
+			return
+		}
+		d = -1
+		data := append([]byte{'\n'}, prev.data...)
+		v.action_delete(cursor_location{prev, beg.line_num - 1, 0}, len(prev.data)+1)
+		end.line, end.line_num = v.buf.line_at(end.line_num - 1)
+		end.boffset = len(end.line.data)
+		v.action_insert(end, data)
+	}
+
+	cursor.line_num += d
+	v.move_cursor_to(v.buf.clamp_cursor(cursor))
+	if active {
+		mark.line_num += d
+		v.buf.mark = v.buf.clamp_cursor(mark)
+	}
+}
+
 func (v *view) word_to(filter func([]byte) []byte) {
 	c1, c2 := v.cursor, v.cursor
 	c2.move_one_word_forward()
@@ -1580,11 +3061,11 @@ func (v *view) filter_text(from, to cursor_location, filter func([]byte) []byte)
 	}
 }
 
-func fill_region_filt(data []byte, maxv int, prefix []byte) []byte {
+func fill_region_filt(data []byte, maxv int, prefix []byte, tabstop int) []byte {
 	var buf, out bytes.Buffer
 	indent := data[:index_first_non_space(data)]
-	indent_vlen := vlen(indent, 0)
-	prefix_vlen := vlen(prefix, indent_vlen)
+	indent_vlen := vlen(indent, 0, tabstop)
+	prefix_vlen := vlen(prefix, indent_vlen, tabstop)
 	offset := 0
 	for {
 		// for each line
@@ -1641,7 +3122,7 @@ func fill_region_filt(data []byte, maxv int, prefix []byte) []byte {
 			}
 
 			// advance v and i
-			v += rune_advance_len(r, v)
+			v += rune_advance_len(r, v, tabstop)
 			i += rlen
 
 			if lastspacei != -1 && v >= maxv {
@@ -1663,13 +3144,50 @@ func fill_region_filt(data []byte, maxv int, prefix []byte) []byte {
 }
 
 func (v *view) fill_region(maxv int, prefix []byte) {
+	if !v.check_writable() {
+		return
+	}
 	filt := func(data []byte) []byte {
-		return fill_region_filt(data, maxv, prefix)
+		return fill_region_filt(data, maxv, prefix, v.buf.tabstop)
 	}
 	beg, end := v.line_region()
 	v.filter_text(beg, end, filt)
 }
 
+// Fills the paragraph around the cursor to 'maxv' columns, like 'fill_region'.
+// The paragraph is the lines around the cursor one with the same comment
+// prefix (see 'fill_prefix'), it ends at blank lines.
+func (v *view) fill_paragraph(maxv int) {
+	prefix := fill_prefix(v.cursor.line.data)
+	in_paragraph := func(l *line) bool {
+		data := l.data[index_first_non_space(l.data):]
+		if !bytes.HasPrefix(data, prefix) {
+			return false
+		}
+		data = data[len(prefix):]
+		return index_first_non_space(data) < len(data)
+	}
+	if !in_paragraph(v.cursor.line) {
+		v.ctx.set_status("No paragraph at the cursor")
+		return
+	}
+
+	beg, end := v.cursor, v.cursor
+	for beg.line.prev != nil && in_paragraph(beg.line.prev) {
+		beg.line = beg.line.prev
+		beg.line_num--
+	}
+	for end.line.next != nil && in_paragraph(end.line.next) {
+		end.line = end.line.next
+		end.line_num++
+	}
+	beg.boffset = 0
+	end.boffset = len(end.line.data)
+	v.filter_text(beg, end, func(data []byte) []byte {
+		return fill_region_filt(data, maxv, prefix, v.buf.tabstop)
+	})
+}
+
 func (v *view) collect_words(slice [][]byte, dups *llrb_tree, ignorecase bool) [][]byte {
 	append_word_full := func(prefix, word []byte, clone bool) {
 		lword := word
@@ -1723,6 +3241,9 @@ func (v *view) collect_words(slice [][]byte, dups *llrb_tree, ignorecase bool) [
 }
 
 func (v *view) search_and_replace(word, repl []byte) {
+	if !v.check_writable() {
+		return
+	}
 	// assumes mark is set
 	c1, c2 := swap_cursors_maybe(v.cursor, v.buf.mark)
 	cur := cursor_location{
@@ -1780,6 +3301,34 @@ func (v *view) search_and_replace(word, repl []byte) {
 	v.ctx.set_status("Replaced %s with %s", word, repl)
 }
 
+// Replaces every 'word' from the cursor to the end of the buffer and returns
+// how many there were. The replacements go to the current action group, the
+// cursor goes after the last one.
+func (v *view) replace_all(word, repl []byte) int {
+	if len(word) == 0 || !v.check_writable() {
+		return 0
+	}
+	repl = clone_byte_slice(repl)
+	n := 0
+	c := v.cursor
+	for {
+		match, ok := v.buf.find(c, word, search_opts{})
+		if !ok {
+			break
+		}
+		v.action_delete(match, len(word))
+		v.action_insert(match, repl)
+		match.boffset += len(repl)
+		c = match
+		n++
+	}
+	if n > 0 {
+		v.move_cursor_to(c)
+		v.dirty = dirty_everything
+	}
+	return n
+}
+
 func (v *view) other_buffers(cb func(buf *buffer)) {
 	bufs := *v.ctx.buffers
 	for _, buf := range bufs {
@@ -1823,8 +3372,13 @@ const (
 	vcommand_move_cursor_beginning_of_file
 	vcommand_move_cursor_end_of_file
 	vcommand_move_cursor_to_line
-	vcommand_move_view_half_forward
-	vcommand_move_view_half_backward
+	vcommand_move_cursor_to_column
+	vcommand_move_cursor_to_percent
+	vcommand_move_cursor_next_changed_line
+	vcommand_move_cursor_prev_changed_line
+	vcommand_move_cursor_matching_bracket
+	vcommand_move_view_page_forward
+	vcommand_move_view_page_backward
 	vcommand_set_mark
 	vcommand_swap_cursor_and_mark
 	vcommand_recenter
@@ -1833,7 +3387,13 @@ const (
 	// insertion commands
 	_vcommand_insertion_beg
 	vcommand_insert_rune
+	vcommand_quoted_insert
 	vcommand_yank
+	vcommand_yank_pop
+	vcommand_yank_rectangle
+	vcommand_open_line
+	vcommand_insert_register // arg: register name
+	vcommand_insert_pasted   // inserts 'repeat_text'
 	_vcommand_insertion_end
 
 	// deletion commands
@@ -1841,9 +3401,13 @@ const (
 	vcommand_delete_rune_backward
 	vcommand_delete_rune
 	vcommand_kill_line
+	vcommand_kill_whole_line
+	vcommand_delete_blank_lines
 	vcommand_kill_word
 	vcommand_kill_word_backward
 	vcommand_kill_region
+	vcommand_kill_rectangle
+	vcommand_kill_to_register // arg: register name
 	_vcommand_deletion_end
 
 	// history commands (undo/redo)
@@ -1854,18 +3418,45 @@ const (
 
 	// misc commands
 	_vcommand_misc_beg
+	vcommand_toggle_comment_line
+	vcommand_comment_region
+	vcommand_fill_paragraph
+	vcommand_delete_trailing_whitespace
+	vcommand_keep_region
 	vcommand_indent_region
 	vcommand_deindent_region
+	vcommand_reindent_region
+	vcommand_tabify   // arg: non-zero to convert interior whitespace as well
+	vcommand_untabify // same
 	vcommand_copy_region
+	vcommand_copy_to_register // arg: register name
 	vcommand_region_to_upper
 	vcommand_region_to_lower
 	vcommand_word_to_upper
 	vcommand_word_to_title
 	vcommand_word_to_lower
+	vcommand_transpose_chars
+	vcommand_join_line
+	vcommand_duplicate_line
+	vcommand_move_line_up
+	vcommand_move_line_down
 	vcommand_autocompl_init
 	vcommand_autocompl_move_cursor_up
 	vcommand_autocompl_move_cursor_down
 	vcommand_autocompl_finalize
+	vcommand_toggle_transient_mark
+	vcommand_toggle_full_page_scroll
+	vcommand_toggle_smart_tab
+	vcommand_toggle_auto_indent
+	vcommand_toggle_electric_indent
+	vcommand_toggle_truncate_lines
+	vcommand_toggle_wrap_lines
+	vcommand_toggle_line_numbers
+	vcommand_toggle_crlf
+	vcommand_toggle_readonly
+	vcommand_set_tabstop // arg: tab width
+	vcommand_keyboard_quit
+	vcommand_repeat_last
 	_vcommand_misc_end
 )
 
@@ -1884,3 +3475,64 @@ func (c vcommand) class() vcommand_class {
 	}
 	return vcommand_class_none
 }
+
+// Reports whether the command changes the contents of the buffer, or how it's
+// saved.
+func (c vcommand) modifies_buffer() bool {
+	switch c.class() {
+	case vcommand_class_insertion, vcommand_class_deletion, vcommand_class_history:
+		return true
+	}
+	switch c {
+	case vcommand_toggle_comment_line, vcommand_comment_region,
+		vcommand_fill_paragraph, vcommand_delete_trailing_whitespace,
+		vcommand_keep_region,
+		vcommand_indent_region, vcommand_deindent_region,
+		vcommand_reindent_region, vcommand_tabify, vcommand_untabify,
+		vcommand_region_to_upper, vcommand_region_to_lower,
+		vcommand_word_to_upper, vcommand_word_to_title,
+		vcommand_word_to_lower, vcommand_transpose_chars, vcommand_join_line,
+		vcommand_duplicate_line, vcommand_move_line_up, vcommand_move_line_down,
+		vcommand_autocompl_init,
+		vcommand_autocompl_finalize, vcommand_toggle_crlf:
+		return true
+	}
+	return false
+}
+
+// Reports whether the command runs as many times as the numeric argument says
+// (see 'repeat_count'). Insertion commands use the count themselves.
+func (c vcommand) repeats() bool {
+	switch c {
+	case vcommand_move_cursor_forward, vcommand_move_cursor_backward,
+		vcommand_move_cursor_word_forward, vcommand_move_cursor_word_backward,
+		vcommand_move_cursor_next_line, vcommand_move_cursor_prev_line,
+		vcommand_move_cursor_next_changed_line,
+		vcommand_move_cursor_prev_changed_line,
+		vcommand_move_view_page_forward, vcommand_move_view_page_backward,
+		vcommand_delete_rune_backward, vcommand_delete_rune,
+		vcommand_kill_line, vcommand_kill_whole_line,
+		vcommand_kill_word, vcommand_kill_word_backward:
+		return true
+	}
+	return false
+}
+
+// Reports whether the command deactivates the mark in the transient mark mode.
+// Region indentation keeps it, because it's meant to be repeated.
+func (c vcommand) deactivates_mark() bool {
+	switch c.class() {
+	case vcommand_class_insertion, vcommand_class_deletion, vcommand_class_history:
+		return true
+	}
+	switch c {
+	case vcommand_copy_region, vcommand_copy_to_register,
+		vcommand_region_to_upper, vcommand_region_to_lower,
+		vcommand_toggle_comment_line, vcommand_comment_region,
+		vcommand_fill_paragraph, vcommand_reindent_region,
+		vcommand_tabify, vcommand_untabify, vcommand_transpose_chars,
+		vcommand_join_line, vcommand_duplicate_line:
+		return true
+	}
+	return false
+}
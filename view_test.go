@@ -0,0 +1,1755 @@
+package main
+
+import "testing"
+import "strings"
+import "strconv"
+import "fmt"
+import "time"
+import "github.com/nsf/termbox-go"
+
+func new_test_view(t testing.TB, contents string, w, h int) *view {
+	ctx := view_context{
+		set_status: func(string, ...interface{}) {},
+		kill_ring:  new(kill_ring),
+		rectangle:  new([][]byte),
+		buffers:    new([]*buffer),
+	}
+	v := new_view(ctx, new_test_buffer(t, contents))
+	v.resize(w, h)
+	return v
+}
+
+// Makes the view keep its last status message in the returned string.
+func capture_status(v *view) *string {
+	status := new(string)
+	v.ctx.set_status = func(f string, args ...interface{}) {
+		*status = fmt.Sprintf(f, args...)
+	}
+	return status
+}
+
+func TestViewLocationAt(t *testing.T) {
+	v := new_test_view(t, "a\tb\n\té\tx\nlast", 40, 10)
+	cases := []struct {
+		x, y     int
+		line_num int
+		boffset  int
+	}{
+		{0, 0, 1, 0},
+		{5, 0, 1, 1}, // inside the tab
+		{8, 0, 1, 2},
+		{30, 0, 1, 3},
+		{7, 1, 2, 0}, // inside the first tab
+		{8, 1, 2, 1},
+		{15, 1, 2, 3}, // inside the tab after 'é'
+		{16, 1, 2, 4},
+		{2, 2, 3, 2},
+		{2, 9, 3, 2}, // below the end of the buffer
+		{-3, 1, 2, 0},
+	}
+	for _, c := range cases {
+		loc := v.location_at(c.x, c.y)
+		if loc.line_num != c.line_num || loc.boffset != c.boffset {
+			t.Errorf("(%d, %d): got line %d, boffset %d, expected line %d, boffset %d",
+				c.x, c.y, loc.line_num, loc.boffset, c.line_num, c.boffset)
+		}
+	}
+
+	// the cursor line is the only one scrolled horizontally
+	v.move_cursor_to(cursor_location{v.buf.first_line.next, 2, 4})
+	v.line_voffset = 8
+	if loc := v.location_at(0, 1); loc.boffset != 1 {
+		t.Errorf("scrolled cursor line: got boffset %d, expected 1", loc.boffset)
+	}
+	if loc := v.location_at(0, 0); loc.boffset != 0 {
+		t.Errorf("not scrolled line: got boffset %d, expected 0", loc.boffset)
+	}
+}
+
+func TestViewRestoresHorizontalScroll(t *testing.T) {
+	long := strings.Repeat("x", 200)
+	v := new_test_view(t, long+"\nshort", 40, 10)
+	first := v.buf.first_line
+
+	v.move_cursor_to(cursor_location{first, 1, 150})
+	v.move_cursor_to(cursor_location{first, 1, 140})
+	scrolled := v.line_voffset
+	if scrolled == 0 {
+		t.Fatal("the long line is not scrolled")
+	}
+
+	v.move_cursor_to(cursor_location{first.next, 2, 2})
+	if v.line_voffset != 0 {
+		t.Errorf("short line: got line_voffset %d, expected 0", v.line_voffset)
+	}
+
+	v.move_cursor_to(cursor_location{first, 1, 140})
+	if v.line_voffset != scrolled {
+		t.Errorf("back on the long line: got line_voffset %d, expected %d",
+			v.line_voffset, scrolled)
+	}
+}
+
+func check_view_invariants(t *testing.T, v *view, what string) {
+	if v.top_line_num < 1 || v.top_line_num > v.buf.lines_n {
+		t.Fatalf("%s: top line %d is out of the buffer", what, v.top_line_num)
+	}
+	if l, _ := v.buf.line_at(v.top_line_num); l != v.top_line {
+		t.Fatalf("%s: top line pointer doesn't match its number %d", what, v.top_line_num)
+	}
+	if l, _ := v.buf.line_at(v.cursor.line_num); l != v.cursor.line {
+		t.Fatalf("%s: cursor line pointer doesn't match its number %d", what, v.cursor.line_num)
+	}
+	y := v.cursor.line_num - v.top_line_num
+	if y < 0 || y >= v.height() {
+		t.Fatalf("%s: cursor line %d is outside of the view (top line %d)",
+			what, v.cursor.line_num, v.top_line_num)
+	}
+}
+
+func TestViewScrollingStopsAtBufferEnds(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+
+	lines := make([]string, 30)
+	for i := range lines {
+		lines[i] = "line " + strconv.Itoa(i+1)
+	}
+	for _, full := range []bool{false, true} {
+		settings.full_page_scroll = full
+		v := new_test_view(t, strings.Join(lines, "\n"), 40, 11)
+
+		for i := 0; i < 20; i++ {
+			v.on_vcommand(vcommand_move_view_page_forward, 0)
+			check_view_invariants(t, v, "forward")
+		}
+		if v.cursor.line_num != 30 || !v.cursor.eol() {
+			t.Errorf("forward (full page: %v): cursor at %d:%d, expected the end of the buffer",
+				full, v.cursor.line_num, v.cursor.boffset)
+		}
+
+		for i := 0; i < 20; i++ {
+			v.on_vcommand(vcommand_move_view_page_backward, 0)
+			check_view_invariants(t, v, "backward")
+		}
+		if v.top_line_num != 1 || v.cursor.line_num != 1 || v.cursor.boffset != 0 {
+			t.Errorf("backward (full page: %v): top line %d, cursor at %d:%d, expected 1, 1:0",
+				full, v.top_line_num, v.cursor.line_num, v.cursor.boffset)
+		}
+	}
+}
+
+func TestViewReindentRegion(t *testing.T) {
+	src := "func f() {\n" +
+		"if x {\n" +
+		"    y(a,\n" +
+		"b)\n" +
+		"  } else {\n" +
+		"s := \"{\" // {\n" +
+		"\n" +
+		"switch {\n" +
+		"case a:\n" +
+		"                z()\n" +
+		"}\n" +
+		"}\n" +
+		"}"
+	expected := "func f() {\n" +
+		"\tif x {\n" +
+		"\t\ty(a,\n" +
+		"\t\t\tb)\n" +
+		"\t} else {\n" +
+		"\t\ts := \"{\" // {\n" +
+		"\n" +
+		"\t\tswitch {\n" +
+		"\t\tcase a:\n" +
+		"\t\t\tz()\n" +
+		"\t\t}\n" +
+		"\t}\n" +
+		"}"
+	v := new_test_view(t, src, 40, 20)
+	v.buf.path = "test.go"
+	v.move_cursor_to(cursor_location{v.buf.first_line.next, 2, 0})
+	v.set_mark()
+	v.move_cursor_end_of_file()
+	v.on_vcommand(vcommand_reindent_region, 0)
+	if s := string(v.buf.contents()); s != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	// it's one undo step
+	v.on_vcommand(vcommand_undo, 0)
+	if s := string(v.buf.contents()); s != src {
+		t.Errorf("after undo got:\n%s\nexpected:\n%s", s, src)
+	}
+}
+
+func TestViewsShareBufferWithIndependentCursors(t *testing.T) {
+	v1 := new_test_view(t, "one\ntwo\nthree\nfour\nfive\nsix", 40, 11)
+	v2 := new_view(v1.ctx, v1.buf)
+	v2.resize(40, 11)
+	at := func(n, boffset int) cursor_location {
+		l, _ := v1.buf.line_at(n)
+		return cursor_location{l, n, boffset}
+	}
+	check := func(what string, v *view, line_num, boffset int) {
+		if v.cursor.line_num != line_num || v.cursor.boffset != boffset {
+			t.Errorf("%s: cursor at %d:%d, expected %d:%d", what,
+				v.cursor.line_num, v.cursor.boffset, line_num, boffset)
+		}
+		if l, _ := v.buf.line_at(line_num); l != v.cursor.line {
+			t.Errorf("%s: cursor line pointer doesn't match line %d", what, line_num)
+		}
+	}
+
+	v1.move_cursor_to(at(5, 2))
+	v2.move_cursor_to(at(2, 0))
+	check("independent movement, first view", v1, 5, 2)
+	check("independent movement, second view", v2, 2, 0)
+
+	// lines inserted above move the cursor down along with its text
+	v2.on_vcommand(vcommand_insert_rune, 'x')
+	v2.on_vcommand(vcommand_insert_rune, '\n')
+	check("insertion above", v1, 6, 2)
+	if r, _ := v1.cursor.rune_under(); r != 'v' {
+		t.Errorf("insertion above: cursor is on %q, expected 'v'", r)
+	}
+
+	// insertion on the same line before the cursor shifts it
+	v2.move_cursor_to(at(6, 0))
+	v2.on_vcommand(vcommand_insert_rune, '>')
+	check("insertion before the cursor", v1, 6, 3)
+
+	// insertion after the cursor doesn't move it
+	v2.move_cursor_end_of_line()
+	v2.on_vcommand(vcommand_insert_rune, '<')
+	check("insertion after the cursor", v1, 6, 3)
+
+	// deleting the cursor line puts the cursor where the deletion was
+	v2.move_cursor_to(at(5, 4))
+	v2.buf.mark = at(7, 0)
+	v2.on_vcommand(vcommand_kill_region, 0)
+	check("deletion of the cursor line", v1, 5, 4)
+	check("deletion of the cursor line, editing view", v2, 5, 4)
+	check_view_invariants(t, v1, "after edits, first view")
+	check_view_invariants(t, v2, "after edits, second view")
+}
+
+func TestViewMoveCursorToColumn(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+
+	v := new_test_view(t, "a\tbé\tx", 40, 10)
+	cases := []struct {
+		chars   bool
+		col     int
+		boffset int
+	}{
+		{false, 0, 0},
+		{false, 1, 1},
+		{false, 5, 1}, // inside the tab
+		{false, 8, 2},
+		{false, 9, 3},
+		{false, 100, 7},
+		{true, 2, 2},
+		{true, 4, 5},
+		{true, 100, 7},
+	}
+	for _, c := range cases {
+		settings.column_chars = c.chars
+		v.move_cursor_to_column(c.col)
+		if v.cursor.boffset != c.boffset {
+			t.Errorf("column %d (chars: %v): got boffset %d, want %d",
+				c.col, c.chars, v.cursor.boffset, c.boffset)
+		}
+	}
+
+	settings.column_chars = true
+	settings.column_one_based = true
+	v.move_cursor_to_column(3)
+	if col := v.cursor_column(); col != 4 {
+		t.Errorf("got column %d, want 4", col)
+	}
+}
+
+func TestViewIndentationWidth(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+	settings.shift_width = 4
+
+	v := new_test_view(t, "x\n  y", 40, 10)
+	v.set_mark()
+	v.move_cursor_end_of_file()
+	check := func(what, expected string) {
+		if s := string(v.buf.contents()); s != expected {
+			t.Errorf("%s: got %q, expected %q", what, s, expected)
+		}
+	}
+
+	v.on_vcommand(vcommand_indent_region, 0)
+	check("indent", "    x\n      y")
+	v.on_vcommand(vcommand_indent_region, 0)
+	check("indent twice", "\tx\n\t  y")
+	if v.cursor.boffset != 4 {
+		t.Errorf("cursor at %d after indentation, expected 4", v.cursor.boffset)
+	}
+	v.on_vcommand(vcommand_deindent_region, 0)
+	check("deindent", "    x\n      y")
+
+	settings.expand_tabs = true
+	v.on_vcommand(vcommand_indent_region, 0)
+	check("indent with spaces", "        x\n          y")
+
+	if indent := string(electric_indent([]byte("  "), []byte("if {"), nil, tabstop_length)); indent != "      " {
+		t.Errorf("electric indent after a bracket: got %q", indent)
+	}
+	if indent := string(electric_indent([]byte("      "), nil, []byte("}"), tabstop_length)); indent != "  " {
+		t.Errorf("electric indent before a bracket: got %q", indent)
+	}
+}
+
+func TestViewTabWithCount(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+	settings.shift_width = 4
+
+	// "C-u 3 <tab>" is the same as <tab> pressed three times
+	for _, expand := range []bool{false, true} {
+		settings.expand_tabs = expand
+		pressed := new_test_view(t, "x", 40, 10)
+		pressed.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+		for i := 0; i < 3; i++ {
+			pressed.on_tab()
+		}
+		counted := new_test_view(t, "x", 40, 10)
+		counted.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+		counted.prefix_arg = 3
+		counted.on_tab()
+		counted.prefix_arg = 0
+
+		want := string(pressed.buf.contents())
+		if got := string(counted.buf.contents()); got != want {
+			t.Errorf("expand_tabs=%v: got %q, want %q", expand, got, want)
+		}
+		if counted.cursor.boffset != len(want) {
+			t.Errorf("expand_tabs=%v: cursor at %d, want %d", expand, counted.cursor.boffset, len(want))
+		}
+	}
+}
+
+func TestViewKeepRegion(t *testing.T) {
+	src := "one\ntwo\nthree\nfour"
+	v := new_test_view(t, src, 40, 10)
+	v.move_cursor_to(cursor_location{v.buf.first_line.next, 2, 1})
+	v.set_mark()
+	v.move_cursor_to(cursor_location{v.buf.first_line.next.next, 3, 2})
+	v.on_vcommand(vcommand_keep_region, 0)
+	if s := string(v.buf.contents()); s != "wo\nth" {
+		t.Errorf("got %q, expected %q", s, "wo\nth")
+	}
+	check_buffer_lines(t, v.buf, "keep region")
+	if v.cursor.line_num != 2 || v.cursor.boffset != 2 {
+		t.Errorf("cursor at (%d, %d), expected (2, 2)", v.cursor.line_num, v.cursor.boffset)
+	}
+	if m := v.buf.mark; m.line_num != 1 || m.boffset != 0 {
+		t.Errorf("mark at (%d, %d), expected (1, 0)", m.line_num, m.boffset)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if s := string(v.buf.contents()); s != src {
+		t.Errorf("after undo got %q, expected %q", s, src)
+	}
+}
+
+func TestViewDrawsOnlyChangedRows(t *testing.T) {
+	v := new_test_view(t, "first\nsecond\nthird", 20, 5)
+	v.draw_contents()
+	w := v.uibuf.Width
+	cell := func(x, y int) rune { return v.uibuf.Cells[y*w+x].Ch }
+
+	// rows which didn't change are not touched on the next draw
+	v.uibuf.Cells[1*w+0].Ch = '#'
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_insert_rune, '!')
+	v.draw_contents()
+	if r := cell(0, 1); r != '#' {
+		t.Errorf("unchanged row was redrawn, got %q", r)
+	}
+	if r := cell(5, 2); r != '!' {
+		t.Errorf("changed row wasn't redrawn, got %q", r)
+	}
+
+	// shorter contents are blanked to the end of the row
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	v.on_vcommand(vcommand_kill_line, 0)
+	v.draw_contents()
+	for x := 0; x < w; x++ {
+		if r := cell(x, 2); r != ' ' {
+			t.Errorf("killed line: got %q at %d", r, x)
+			break
+		}
+	}
+
+	v.invalidate_drawn_rows()
+	v.draw_contents()
+	if r := cell(0, 1); r != 's' {
+		t.Errorf("invalidated row wasn't redrawn, got %q", r)
+	}
+}
+
+func new_wide_view(b *testing.B) *view {
+	line := strings.Repeat("abcdefghi ", 8) + "\n"
+	v := new_test_view(b, strings.Repeat(line, 100), 300, 60)
+	v.draw_contents()
+	return v
+}
+
+// Editing on a 300 columns wide view of a file with 80 columns lines, rows are
+// compared with what's drawn already and nothing is drawn again.
+func BenchmarkViewDrawAfterInsert(b *testing.B) {
+	v := new_wide_view(b)
+	for i := 0; i < b.N; i++ {
+		v.on_vcommand(vcommand_insert_rune, 'x')
+		v.on_vcommand(vcommand_delete_rune_backward, 0)
+		v.draw_contents()
+	}
+}
+
+// The same with every row drawn, as it was without damage tracking.
+func BenchmarkViewDrawAfterInsertFull(b *testing.B) {
+	v := new_wide_view(b)
+	for i := 0; i < b.N; i++ {
+		v.on_vcommand(vcommand_insert_rune, 'x')
+		v.on_vcommand(vcommand_delete_rune_backward, 0)
+		v.invalidate_drawn_rows()
+		v.draw_contents()
+	}
+}
+
+func TestViewLocationFollowsLastFocusedView(t *testing.T) {
+	a := new_test_view(t, "one\ntwo\nthree\nfour", 40, 10)
+	b := new_view(a.ctx, a.buf)
+	b.resize(40, 10)
+
+	a.activate()
+	a.on_vcommand(vcommand_move_cursor_next_line, 0)
+	b.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	if got := a.buf.loc.cursor.line_num; got != 2 {
+		t.Errorf("location of the focused view: got line %d, want 2", got)
+	}
+
+	b.activate()
+	b.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	if got := a.buf.loc.cursor.line_num; got != 4 {
+		t.Errorf("location after focusing the other view: got line %d, want 4", got)
+	}
+
+	a.on_vcommand(vcommand_move_cursor_next_line, 0)
+	b.detach()
+	if got := a.buf.loc_owner; got != nil {
+		t.Errorf("detached view still owns the location")
+	}
+	if got := a.buf.loc.cursor.line_num; got != 4 {
+		t.Errorf("location after detaching: got line %d, want 4", got)
+	}
+}
+
+func TestViewRetab(t *testing.T) {
+	cases := []struct {
+		contents string
+		tabs     bool
+		interior bool
+		want     string
+	}{
+		{"        a  b\n    \tc\nd", true, false, "\ta  b\n\tc\nd"},
+		{"\ta\t b\n  \tc", false, false, "        a\t b\n        c"},
+		{"a       b c\n\tx\t\ty", true, true, "a\tb c\n\tx\t\ty"},
+		{"ab\tc\n\td", false, true, "ab      c\n        d"},
+		// tabs and spaces mixed in the middle of a line
+		{"x\t  y   \tz", false, true, "x         y     z"},
+		{"x         y     z", true, true, "x\t  y\tz"},
+	}
+	for _, c := range cases {
+		v := new_test_view(t, c.contents, 40, 10)
+		v.retab(c.tabs, c.interior)
+		if got := string(v.buf.contents()); got != c.want {
+			t.Errorf("retab(%q, %v, %v): got %q, want %q",
+				c.contents, c.tabs, c.interior, got, c.want)
+		}
+		check_buffer_lines(t, v.buf, "retab")
+	}
+
+	v := new_test_view(t, "    x = 1\n        y", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_untabify, 0)
+	v.on_vcommand(vcommand_tabify, 0)
+	if got := string(v.buf.contents()); got != "    x = 1\n\ty" {
+		t.Errorf("tabify after untabify: got %q", got)
+	}
+	if v.cursor.line_num != 1 || v.cursor.boffset != 9 {
+		t.Errorf("cursor moved to %d:%d, want 1:9", v.cursor.line_num, v.cursor.boffset)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "    x = 1\n        y" {
+		t.Errorf("undo of tabify: got %q", got)
+	}
+
+	// only the lines of an active region are converted
+	v = new_test_view(t, "\ta\n\tb\n\tc", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_untabify, 0)
+	if got := string(v.buf.contents()); got != "\ta\n        b\n\tc" {
+		t.Errorf("untabify of the region: got %q", got)
+	}
+}
+
+func TestViewSetTabstop(t *testing.T) {
+	v := new_test_view(t, "\tab\n\t\tc", 40, 10)
+	other := new_view(v.ctx, v.buf)
+	other.resize(40, 10)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	other.on_vcommand(vcommand_move_cursor_next_line, 0)
+	other.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+
+	v.on_vcommand(vcommand_set_tabstop, 4)
+	if v.cursor.boffset != 3 || v.cursor_voffset != 6 || v.last_cursor_voffset != 6 {
+		t.Errorf("cursor at %d, visual %d (last %d), want 3, 6 (6)",
+			v.cursor.boffset, v.cursor_voffset, v.last_cursor_voffset)
+	}
+	if other.cursor.boffset != 3 || other.cursor_voffset != 9 {
+		t.Errorf("other view cursor at %d, visual %d, want 3, 9",
+			other.cursor.boffset, other.cursor_voffset)
+	}
+
+	// the tab width is used for the indentation as well
+	v.on_vcommand(vcommand_untabify, 0)
+	if got := string(v.buf.contents()); got != "    ab\n        c" {
+		t.Errorf("untabify with tab width 4: got %q", got)
+	}
+}
+
+func TestViewMoveCursorToPercent(t *testing.T) {
+	v := new_test_view(t, strings.Repeat("line\n", 200), 40, 10)
+	cases := []struct {
+		percent  int
+		line_num int
+	}{
+		{0, 1}, {50, 101}, {100, 201}, {150, 201}, {-5, 1},
+	}
+	for _, c := range cases {
+		v.on_vcommand(vcommand_move_cursor_to_percent, rune(c.percent))
+		if v.cursor.line_num != c.line_num {
+			t.Errorf("%d%%: got line %d, want %d", c.percent, v.cursor.line_num, c.line_num)
+		}
+	}
+}
+
+func TestViewColumnStatus(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+
+	v := new_test_view(t, "\tab", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	cases := []struct {
+		both, one_based, char_column bool
+		want                         string
+	}{
+		{false, false, true, "C3"},
+		{false, true, true, "C4"},
+		{false, false, false, "C10"},
+		{true, false, false, "C3/10"},
+		{true, true, true, "C4/11"},
+	}
+	for _, c := range cases {
+		settings.column_both = c.both
+		settings.column_one_based = c.one_based
+		settings.status_char_column = c.char_column
+		if got := v.column_status(); got != c.want {
+			t.Errorf("both=%v one_based=%v char_column=%v: got %q, want %q",
+				c.both, c.one_based, c.char_column, got, c.want)
+		}
+	}
+
+	settings.column_both = true
+	settings.column_one_based = false
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	if got := v.column_status(); got != "C0" {
+		t.Errorf("same columns: got %q, want \"C0\"", got)
+	}
+}
+
+func TestViewKillWholeLine(t *testing.T) {
+	v := new_test_view(t, "one\ntwo\nthree", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.on_vcommand(vcommand_kill_whole_line, 0)
+	if got := string(v.buf.contents()); got != "one\nthree" {
+		t.Errorf("got %q, want \"one\\nthree\"", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 0 {
+		t.Errorf("cursor at %d:%d, want 2:0", v.cursor.line_num, v.cursor.boffset)
+	}
+	if v.buf.lines_n != 2 {
+		t.Errorf("lines_n is %d, want 2", v.buf.lines_n)
+	}
+	if got := string(v.ctx.kill_ring.latest()); got != "two\n" {
+		t.Errorf("kill buffer: got %q, want \"two\\n\"", got)
+	}
+
+	// the last line is killed to the end of the buffer, the kills
+	// accumulate
+	v.on_vcommand(vcommand_kill_whole_line, 0)
+	if got := string(v.buf.contents()); got != "one\n" {
+		t.Errorf("got %q, want \"one\\n\"", got)
+	}
+	if got := string(v.ctx.kill_ring.latest()); got != "two\nthree" {
+		t.Errorf("kill buffer: got %q", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 0 {
+		t.Errorf("last line: cursor at %d:%d, want 2:0", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	// nothing is left to kill on the empty last line
+	v.on_vcommand(vcommand_kill_whole_line, 0)
+	if got := string(v.buf.contents()); got != "one\n" {
+		t.Errorf("empty last line: got %q", got)
+	}
+	check_buffer_lines(t, v.buf, "kill-whole-line")
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\nthree" {
+		t.Errorf("undo: got %q", got)
+	}
+}
+
+func TestViewOpenLine(t *testing.T) {
+	v := new_test_view(t, strings.Repeat("line\n", 30)+"abcd", 40, 10)
+	other := new_view(v.ctx, v.buf)
+	other.resize(40, 10)
+	other.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	top, cursor := other.top_line_num, other.cursor.line_num
+
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.on_vcommand(vcommand_open_line, 0)
+	if got := v.cursor.line.data; string(got) != "li" || v.cursor.boffset != 2 || v.cursor.line_num != 1 {
+		t.Errorf("cursor on %q at %d:%d, want \"li\" at 1:2", got, v.cursor.line_num, v.cursor.boffset)
+	}
+	if got := string(v.cursor.line.next.data); got != "ne" {
+		t.Errorf("next line: got %q, want \"ne\"", got)
+	}
+	if other.top_line_num != top+1 || other.cursor.line_num != cursor+1 {
+		t.Errorf("other view: top %d, cursor on %d, want %d and %d",
+			other.top_line_num, other.cursor.line_num, top+1, cursor+1)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if v.buf.lines_n != 31 || other.top_line_num != top {
+		t.Errorf("undo: %d lines, other view's top %d", v.buf.lines_n, other.top_line_num)
+	}
+}
+
+func TestViewReplaceAll(t *testing.T) {
+	v := new_test_view(t, "a b a\na", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	if n := v.replace_all([]byte("a"), []byte("xyz")); n != 2 {
+		t.Errorf("replaced %d, want 2", n)
+	}
+	if got := string(v.buf.contents()); got != "a b xyz\nxyz" {
+		t.Errorf("got %q", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 3 {
+		t.Errorf("cursor at %d:%d, want 2:3", v.cursor.line_num, v.cursor.boffset)
+	}
+	if n := v.replace_all([]byte("q"), []byte("r")); n != 0 {
+		t.Errorf("replaced %d without matches", n)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "a b a\na" {
+		t.Errorf("undo: got %q", got)
+	}
+}
+
+func TestViewRepeatWithPrefixArg(t *testing.T) {
+	v := new_test_view(t, strings.Repeat("line\n", 30), 40, 10)
+	v.prefix_arg = 20
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.prefix_arg = 0
+	if v.cursor.line_num != 21 {
+		t.Errorf("C-u 20 C-n: cursor on line %d, want 21", v.cursor.line_num)
+	}
+
+	v.prefix_arg = 2
+	v.on_vcommand(vcommand_kill_line, 0)
+	v.prefix_arg = 0
+	if got := string(v.ctx.kill_ring.latest()); got != "line\n" || len(v.ctx.kill_ring.entries) != 1 {
+		t.Errorf("C-u 2 C-k: killed %q in %d entries", got, len(v.ctx.kill_ring.entries))
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if v.buf.lines_n != 31 {
+		t.Errorf("undo: %d lines, want 31", v.buf.lines_n)
+	}
+}
+
+func TestViewInsertRuneWithPrefixArg(t *testing.T) {
+	v := new_test_view(t, "ab", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.prefix_arg = 40
+	v.on_vcommand(vcommand_insert_rune, '-')
+	v.prefix_arg = 0
+	want := "a" + strings.Repeat("-", 40) + "b"
+	if got := string(v.buf.contents()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if v.cursor.boffset != 41 {
+		t.Errorf("cursor at %d, want 41", v.cursor.boffset)
+	}
+	if n := len(v.buf.history.actions); n != 1 {
+		t.Errorf("%d actions in the undo group, want 1", n)
+	}
+
+	v.prefix_arg = 2
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	v.prefix_arg = 0
+	if v.buf.lines_n != 3 || v.cursor.line_num != 3 {
+		t.Errorf("newlines: %d lines, cursor on %d, want 3 and 3", v.buf.lines_n, v.cursor.line_num)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "ab" {
+		t.Errorf("undo: got %q, want \"ab\"", got)
+	}
+}
+
+func TestViewPreviousBuffer(t *testing.T) {
+	v := new_test_view(t, "a", 40, 10)
+	a := v.buf
+	b := new_test_buffer(t, "b")
+	c := new_test_buffer(t, "c")
+	if got := v.previous_buffer(); got != nil {
+		t.Errorf("previous buffer of a new view: got %v", got)
+	}
+
+	v.attach(b)
+	v.attach(c)
+	if got := v.previous_buffer(); got != b {
+		t.Errorf("after a, b, c: previous is not b")
+	}
+	v.attach(b)
+	if got := v.previous_buffer(); got != c {
+		t.Errorf("after a, b, c, b: previous is not c")
+	}
+	v.forget_buffer(c)
+	if got := v.previous_buffer(); got != a {
+		t.Errorf("after forgetting c: previous is not a")
+	}
+}
+
+func TestViewChangedLines(t *testing.T) {
+	v := new_test_view(t, "a\nb\nc\nd\ne", 40, 10)
+	changed := func() string {
+		s := ""
+		for l := v.buf.first_line; l != nil; l = l.next {
+			if l.changed {
+				s += "+"
+			} else {
+				s += "."
+			}
+		}
+		return s
+	}
+
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if got := changed(); got != ".+..++" {
+		t.Errorf("after the edits: got %s", got)
+	}
+
+	v.on_vcommand(vcommand_move_cursor_beginning_of_file, 0)
+	v.on_vcommand(vcommand_move_cursor_next_changed_line, 0)
+	if v.cursor.line_num != 2 {
+		t.Errorf("next changed line: got %d, want 2", v.cursor.line_num)
+	}
+	v.on_vcommand(vcommand_move_cursor_next_changed_line, 0)
+	if v.cursor.line_num != 5 {
+		t.Errorf("next changed line: got %d, want 5", v.cursor.line_num)
+	}
+	v.on_vcommand(vcommand_move_cursor_prev_changed_line, 0)
+	if v.cursor.line_num != 2 {
+		t.Errorf("previous changed line: got %d, want 2", v.cursor.line_num)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := changed(); got != ".+..." {
+		t.Errorf("after undo: got %s", got)
+	}
+	v.buf.mark_saved()
+	if got := changed(); got != "....." {
+		t.Errorf("after save: got %s", got)
+	}
+	v.on_vcommand(vcommand_redo, 0)
+	if got := changed(); got != "....++" {
+		t.Errorf("after redo: got %s", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := changed(); got != "....." {
+		t.Errorf("undo back to the saved state: got %s", got)
+	}
+
+	// the line was unchanged when 'x' was inserted, but it was saved with it
+	v.on_vcommand(vcommand_undo, 0)
+	if got := changed(); got != ".+..." {
+		t.Errorf("undo past the saved state: got %s", got)
+	}
+	v.on_vcommand(vcommand_redo, 0)
+	if got := changed(); got != "....." {
+		t.Errorf("redo to the saved state: got %s", got)
+	}
+}
+
+func TestViewGutter(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+	settings.changed_lines_gutter = true
+
+	v := new_test_view(t, "ab\ncd", 10, 5)
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	v.draw_contents()
+	row := func(y int) string {
+		s := ""
+		for x := 0; x < 4; x++ {
+			s += string(v.uibuf.Get(x, y).Ch)
+		}
+		return s
+	}
+	if got := row(0); got != "+xab" {
+		t.Errorf("changed line: got %q", got)
+	}
+	if got := row(1); got != " cd " {
+		t.Errorf("unchanged line: got %q", got)
+	}
+	if x, _ := v.cursor_position(); x != 2 {
+		t.Errorf("cursor x: got %d, want 2", x)
+	}
+	if loc := v.location_at(3, 1); loc.boffset != 2 {
+		t.Errorf("location at x=3: got %d, want 2", loc.boffset)
+	}
+}
+
+func TestViewLineNumbers(t *testing.T) {
+	defer func(s godit_settings) { settings = s }(settings)
+
+	v := new_test_view(t, strings.Repeat("ab\n", 11), 10, 5)
+	v.on_vcommand(vcommand_toggle_line_numbers, 0)
+	v.draw_contents()
+	row := func(y int) string {
+		s := ""
+		for x := 0; x < 5; x++ {
+			s += string(v.uibuf.Get(x, y).Ch)
+		}
+		return s
+	}
+	if v.gutter_width() != 3 {
+		t.Errorf("gutter width: got %d, want 3", v.gutter_width())
+	}
+	if got := row(1); got != " 2 ab" {
+		t.Errorf("second line: got %q", got)
+	}
+	if x, y := v.cursor_position(); x != 3 || y != 0 {
+		t.Errorf("cursor: got %d, %d, want 3, 0", x, y)
+	}
+
+	settings.changed_lines_gutter = true
+	v.invalidate_drawn_rows()
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	v.draw_contents()
+	if got := row(0); got != " 1+xa" {
+		t.Errorf("changed line: got %q", got)
+	}
+	if loc := v.location_at(4, 0); loc.boffset != 1 {
+		t.Errorf("location at x=4: got %d, want 1", loc.boffset)
+	}
+}
+
+func TestViewTransposeChars(t *testing.T) {
+	cases := []struct {
+		contents string
+		line_num int
+		boffset  int
+		want     string
+		at       int // the cursor offset after it
+	}{
+		{"abc", 1, 1, "bac", 2},
+		{"abc", 1, 3, "acb", 3},
+		{"añb", 1, 1, "ñab", 3},
+		{"ab\ncd", 2, 0, "abc\nd", 0},
+		{"abc", 1, 0, "abc", 0},
+		{"ab\n\ncd", 2, 0, "ab\n\ncd", 0},
+	}
+	for _, c := range cases {
+		v := new_test_view(t, c.contents, 40, 10)
+		loc := cursor_location{v.buf.first_line, 1, 0}
+		for loc.line_num < c.line_num {
+			loc = cursor_location{loc.line.next, loc.line_num + 1, 0}
+		}
+		loc.boffset = c.boffset
+		v.move_cursor_to(loc)
+		v.on_vcommand(vcommand_transpose_chars, 0)
+		if got := string(v.buf.contents()); got != c.want {
+			t.Errorf("%q at %d:%d: got %q, want %q",
+				c.contents, c.line_num, c.boffset, got, c.want)
+		}
+		if v.cursor.boffset != c.at {
+			t.Errorf("%q at %d:%d: cursor at %d, want %d",
+				c.contents, c.line_num, c.boffset, v.cursor.boffset, c.at)
+		}
+		v.on_vcommand(vcommand_undo, 0)
+		if got := string(v.buf.contents()); got != c.contents {
+			t.Errorf("%q: undo gave %q", c.contents, got)
+		}
+	}
+}
+
+func TestViewWordCase(t *testing.T) {
+	cases := []struct {
+		contents string
+		cmd      vcommand
+		want     string
+		at       int
+	}{
+		{"  dON't STOP", vcommand_word_to_title, "  Don't STOP", 5},
+		{"éCOLE 3rd", vcommand_word_to_title, "École 3rd", 6},
+		{"éCOLE", vcommand_word_to_lower, "école", 6},
+		{"ñu-x", vcommand_word_to_upper, "ÑU-x", 3},
+		{"\xffab", vcommand_word_to_title, "\xffAb", 3},
+	}
+	for _, c := range cases {
+		v := new_test_view(t, c.contents, 40, 10)
+		v.on_vcommand(c.cmd, 0)
+		if got := string(v.buf.contents()); got != c.want {
+			t.Errorf("%q: got %q, want %q", c.contents, got, c.want)
+		}
+		if v.cursor.boffset != c.at {
+			t.Errorf("%q: cursor at %d, want %d", c.contents, v.cursor.boffset, c.at)
+		}
+	}
+
+	v := new_test_view(t, "one two", 40, 10)
+	v.on_vcommand(vcommand_word_to_upper, 0)
+	v.on_vcommand(vcommand_word_to_title, 0)
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "ONE two" {
+		t.Errorf("undo of capitalize-word: got %q", got)
+	}
+}
+
+func TestViewUndoRedoStatus(t *testing.T) {
+	v := new_test_view(t, "a", 40, 10)
+	status := capture_status(v)
+
+	v.on_vcommand(vcommand_undo, 0)
+	if *status != "No further undo information" {
+		t.Errorf("undo at the sentinel: got %q", *status)
+	}
+	v.on_vcommand(vcommand_redo, 0)
+	if *status != "No further redo information" {
+		t.Errorf("redo without undo: got %q", *status)
+	}
+
+	v.on_vcommand(vcommand_insert_rune, 'b')
+	v.on_vcommand(vcommand_undo, 0)
+	v.on_vcommand(vcommand_redo, 0)
+	if *status != "Redo!" || string(v.buf.contents()) != "ba" {
+		t.Errorf("redo: got %q, contents %q", *status, v.buf.contents())
+	}
+	v.on_vcommand(vcommand_redo, 0)
+	if *status != "No further redo information" {
+		t.Errorf("redo past the last group: got %q", *status)
+	}
+}
+
+func TestViewAttachKeepsLocation(t *testing.T) {
+	v := new_test_view(t, strings.Repeat("a\n", 100), 40, 10)
+	a := v.buf
+	b := new_test_buffer(t, strings.Repeat("b\n", 100))
+
+	v.move_cursor_to(cursor_location{a.first_line, 1, 0})
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	a_cursor, a_top := v.cursor, v.top_line_num
+
+	v.attach(b)
+	for i := 0; i < 30; i++ {
+		v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	}
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	b_cursor, b_top := v.cursor, v.top_line_num
+
+	v.attach(a)
+	if v.cursor != a_cursor || v.top_line_num != a_top {
+		t.Errorf("back to a: cursor %d:%d, top %d, want %d:%d, top %d",
+			v.cursor.line_num, v.cursor.boffset, v.top_line_num,
+			a_cursor.line_num, a_cursor.boffset, a_top)
+	}
+	v.attach(b)
+	if v.cursor != b_cursor || v.top_line_num != b_top {
+		t.Errorf("back to b: cursor %d:%d, top %d, want %d:%d, top %d",
+			v.cursor.line_num, v.cursor.boffset, v.top_line_num,
+			b_cursor.line_num, b_cursor.boffset, b_top)
+	}
+}
+
+func TestViewRegisters(t *testing.T) {
+	v := new_test_view(t, "one two", 40, 10)
+	v.ctx.registers = make(map[rune][]byte)
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_word_forward, 0)
+	v.on_vcommand(vcommand_copy_to_register, 'a')
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_insert_register, 'a')
+	if got := string(v.buf.contents()); got != "one twoone" {
+		t.Errorf("insert register: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_insert_register, 'b')
+	if got := string(v.buf.contents()); got != "one twoone" {
+		t.Errorf("insert of an empty register changed the buffer: %q", got)
+	}
+
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	v.on_vcommand(vcommand_move_cursor_word_forward, 0)
+	v.on_vcommand(vcommand_kill_to_register, 'b')
+	if got := string(v.buf.contents()); got != "one" {
+		t.Errorf("kill to register: got %q", got)
+	}
+	if got := string(v.ctx.registers['b']); got != " twoone" {
+		t.Errorf("register b: got %q", got)
+	}
+	if got := string(v.ctx.registers['a']); got != "one" {
+		t.Errorf("register a: got %q", got)
+	}
+	if len(v.ctx.kill_ring.entries) != 0 {
+		t.Errorf("kill to register changed the kill ring")
+	}
+}
+
+func TestViewPointsFollowEdits(t *testing.T) {
+	v := new_test_view(t, "one\ntwo\nthree\nfour", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_to_line, 3)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.buf.points = map[rune]cursor_location{'a': v.cursor}
+
+	v.on_vcommand(vcommand_move_cursor_to_line, 2)
+	v.on_vcommand(vcommand_kill_whole_line, 0)
+	if c := v.buf.points['a']; c.line_num != 2 || string(c.line.data) != "three" || c.boffset != 1 {
+		t.Errorf("after deleting a line above: %d:%d %q", c.line_num, c.boffset, c.line.data)
+	}
+
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	v.on_vcommand(vcommand_kill_whole_line, 0)
+	if c := v.buf.points['a']; c.line_num != 2 || string(c.line.data) != "four" || c.boffset != 0 {
+		t.Errorf("after deleting its line: %d:%d %q", c.line_num, c.boffset, c.line.data)
+	}
+
+	v.buf.reload(strings.NewReader("short"))
+	if c := v.buf.points['a']; c.line != v.buf.first_line || c.line_num != 1 || c.boffset != 0 {
+		t.Errorf("after reload: %d:%d", c.line_num, c.boffset)
+	}
+}
+
+func TestViewDeleteBlankLines(t *testing.T) {
+	cases := []struct {
+		contents string
+		line_num int
+		want     string
+		cursor   int // line number after
+	}{
+		{"a\n\n  \n\t\nb", 3, "a\n\nb", 2},
+		{"a\n\t\nb", 2, "a\nb", 2},
+		{"a\n\n\nb\n", 1, "a\nb\n", 1},
+		{"a\nb", 1, "a\nb", 1},
+		{"a\n ", 2, "a", 1},
+		{"  ", 1, "", 1},
+	}
+	for _, c := range cases {
+		v := new_test_view(t, c.contents, 40, 10)
+		v.on_vcommand(vcommand_move_cursor_to_line, rune(c.line_num))
+		v.on_vcommand(vcommand_delete_blank_lines, 0)
+		if got := string(v.buf.contents()); got != c.want {
+			t.Errorf("%q at line %d: got %q, want %q", c.contents, c.line_num, got, c.want)
+		}
+		if v.cursor.line_num != c.cursor {
+			t.Errorf("%q at line %d: cursor on line %d, want %d",
+				c.contents, c.line_num, v.cursor.line_num, c.cursor)
+		}
+		check_buffer_lines(t, v.buf, "delete-blank-lines")
+	}
+}
+
+func TestViewQuotedInsert(t *testing.T) {
+	v := new_test_view(t, "\tab", 40, 10)
+	v.buf.electric_indent = true
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_quoted_insert, '\r')
+	v.on_vcommand(vcommand_quoted_insert, '\n')
+	v.prefix_arg = 2
+	v.on_vcommand(vcommand_quoted_insert, '\x1b')
+	v.prefix_arg = 0
+	if got := string(v.buf.contents()); got != "\tab\r\n\x1b\x1b" {
+		t.Errorf("got %q", got)
+	}
+	if v.cursor.line_num != 2 || v.cursor.boffset != 2 {
+		t.Errorf("cursor at %d:%d, want 2:2", v.cursor.line_num, v.cursor.boffset)
+	}
+}
+
+func TestViewCommentRegion(t *testing.T) {
+	src := "def f():\n\n    return 1\nx = f()"
+	v := new_test_view(t, src, 40, 10)
+	status := capture_status(v)
+	v.on_vcommand(vcommand_comment_region, 0)
+	if *status != "Unknown comment syntax" || string(v.buf.contents()) != src {
+		t.Fatalf("unknown file type: status %q, got %q", *status, v.buf.contents())
+	}
+
+	v.buf.filetype = "py"
+	v.set_mark()
+	v.move_cursor_to(cursor_location{v.buf.first_line.next.next, 3, 4})
+	v.on_vcommand(vcommand_comment_region, 0)
+	want := "# def f():\n\n    # return 1\nx = f()"
+	if got := string(v.buf.contents()); got != want {
+		t.Errorf("comment: got %q, want %q", got, want)
+	}
+	if v.cursor.line_num != 3 || v.cursor.boffset != 6 {
+		t.Errorf("comment: cursor at %d:%d, want 3:6", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != src {
+		t.Errorf("undo: got %q", got)
+	}
+	v.on_vcommand(vcommand_redo, 0)
+
+	// the first line is commented, so the region gets uncommented
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.set_mark()
+	v.on_vcommand(vcommand_move_cursor_beginning_of_file, 0)
+	v.on_vcommand(vcommand_comment_region, 0)
+	if got := string(v.buf.contents()); got != src {
+		t.Errorf("uncomment: got %q", got)
+	}
+}
+
+func TestViewAutoIndent(t *testing.T) {
+	v := new_test_view(t, "\tif x {", 40, 10)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if got := string(v.buf.contents()); got != "\tif x {\n" {
+		t.Fatalf("without auto indent: got %q", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+
+	v.buf.auto_indent = true
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if got := string(v.buf.contents()); got != "\tif x {\n\t" {
+		t.Errorf("auto indent: got %q", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "\tif x {" {
+		t.Errorf("undo: got %q", got)
+	}
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if v.cursor.line_num != 2 || v.cursor.boffset != 1 {
+		t.Errorf("cursor at %d:%d, want 2:1", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	// the indentation moves along, the blank line doesn't keep it
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if got := string(v.buf.contents()); got != "\tif x {\n\n\t" {
+		t.Errorf("second newline: got %q", got)
+	}
+	v.on_vcommand(vcommand_delete_rune_backward, 0)
+	if got := string(v.buf.contents()); got != "\tif x {\n\n" {
+		t.Errorf("backspace removes one character: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "\tif x {\n\n\t" {
+		t.Errorf("undo backspace: got %q", got)
+	}
+}
+
+func TestViewGotoMatchingBracket(t *testing.T) {
+	v := new_test_view(t, "f(a[0], {\n\n\tb()\n}) x", 40, 10)
+	status := capture_status(v)
+	check := func(what string, line_num, boffset int) {
+		if v.cursor.line_num != line_num || v.cursor.boffset != boffset {
+			t.Errorf("%s: cursor at %d:%d, want %d:%d", what,
+				v.cursor.line_num, v.cursor.boffset, line_num, boffset)
+		}
+	}
+
+	v.move_cursor_to(cursor_location{v.buf.first_line, 1, 1})
+	v.on_vcommand(vcommand_move_cursor_matching_bracket, 0)
+	check("forward", 4, 1)
+	v.on_vcommand(vcommand_move_cursor_matching_bracket, 0)
+	check("backward", 1, 1)
+	v.move_cursor_to(cursor_location{v.buf.first_line, 1, 8})
+	v.on_vcommand(vcommand_move_cursor_matching_bracket, 0)
+	check("across the empty line", 4, 0)
+
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	v.on_vcommand(vcommand_move_cursor_backward, 0)
+	v.on_vcommand(vcommand_move_cursor_matching_bracket, 0)
+	if *status != "No bracket at the cursor" {
+		t.Errorf("not a bracket: status %q", *status)
+	}
+	v.on_vcommand(vcommand_move_cursor_beginning_of_file, 0)
+	v.on_vcommand(vcommand_insert_rune, ')')
+	v.on_vcommand(vcommand_move_cursor_backward, 0)
+	v.on_vcommand(vcommand_move_cursor_matching_bracket, 0)
+	check("unmatched", 1, 0)
+	if *status != "No matching bracket" {
+		t.Errorf("unmatched: status %q", *status)
+	}
+}
+
+func TestViewHighlightsMatchingBracket(t *testing.T) {
+	v := new_test_view(t, "f(x)\n{"+strings.Repeat("\n", 10)+"}", 20, 5)
+	w := v.uibuf.Width
+	bg := func(x, y int) termbox.Attribute { return v.uibuf.Cells[y*w+x].Bg }
+	v.draw()
+	if bg(3, 0) == bracket_bg {
+		t.Error("highlighted without a bracket under the cursor")
+	}
+
+	v.move_cursor_to(cursor_location{v.buf.first_line, 1, 1})
+	v.draw()
+	if bg(3, 0) != bracket_bg {
+		t.Error("the matching bracket isn't highlighted")
+	}
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	v.draw()
+	if bg(3, 0) == bracket_bg {
+		t.Error("the highlight stays after the cursor left the bracket")
+	}
+
+	// the match is below the view
+	v.move_cursor_to(cursor_location{v.buf.first_line.next, 2, 0})
+	v.draw()
+	if v.bracket_match.line != nil {
+		t.Errorf("off-screen match at line %d", v.bracket_match.line_num)
+	}
+}
+
+func TestViewHighlightsRegion(t *testing.T) {
+	defer func(old bool) { settings.transient_mark = old }(settings.transient_mark)
+	settings.transient_mark = true
+	v := new_test_view(t, "one\ntwo\nthree", 20, 5)
+	w := v.uibuf.Width
+	reversed := func(x, y int) bool { return v.uibuf.Cells[y*w+x].Fg&termbox.AttrReverse != 0 }
+
+	v.move_cursor_to(cursor_location{v.buf.first_line.next.next, 3, 2})
+	v.set_mark()
+	v.move_cursor_to(cursor_location{v.buf.first_line, 1, 1})
+	v.draw()
+	for _, c := range []struct {
+		x, y int
+		in   bool
+	}{
+		{0, 0, false}, {1, 0, true}, {2, 0, true},
+		{0, 1, true}, {2, 1, true},
+		{1, 2, true}, {2, 2, false},
+	} {
+		if reversed(c.x, c.y) != c.in {
+			t.Errorf("cell %d:%d: reversed %v, want %v", c.x, c.y, !c.in, c.in)
+		}
+	}
+
+	// an edit deactivates the mark
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	v.draw()
+	if reversed(2, 1) {
+		t.Error("the region is drawn after the mark was deactivated")
+	}
+}
+
+func TestViewEditsAboveOtherViewTopLine(t *testing.T) {
+	src := strings.Repeat("line\n", 30)
+	a := new_test_view(t, src, 40, 6)
+	b := new_view(a.ctx, a.buf)
+	b.resize(40, 6)
+	b.on_vcommand(vcommand_move_cursor_to_line, 20)
+	top, top_num, cursor := b.top_line, b.top_line_num, b.cursor
+	if top_num <= 3 {
+		t.Fatalf("the second view's top line is %d", top_num)
+	}
+
+	// three lines inserted by the first view above the top line of the second
+	a.on_vcommand(vcommand_move_cursor_to_line, 2)
+	a.on_vcommand(vcommand_insert_rune, '\r')
+	a.on_vcommand(vcommand_insert_rune, '\r')
+	a.on_vcommand(vcommand_insert_rune, '\r')
+	if b.top_line != top || b.top_line_num != top_num+3 {
+		t.Errorf("insertion: top line %d, want %d", b.top_line_num, top_num+3)
+	}
+	if b.cursor.line != cursor.line || b.cursor.line_num != cursor.line_num+3 {
+		t.Errorf("insertion: cursor line %d, want %d", b.cursor.line_num, cursor.line_num+3)
+	}
+	check_view_invariants(t, b, "insertion above the top line")
+
+	a.on_vcommand(vcommand_undo, 0)
+	if b.top_line != top || b.top_line_num != top_num {
+		t.Errorf("undo: top line %d, want %d", b.top_line_num, top_num)
+	}
+	if b.cursor.line != cursor.line || b.cursor.line_num != cursor.line_num {
+		t.Errorf("undo: cursor line %d, want %d", b.cursor.line_num, cursor.line_num)
+	}
+	check_view_invariants(t, b, "deletion above the top line")
+}
+
+func TestViewCursorOffsetsWithTabs(t *testing.T) {
+	v := new_test_view(t, "a\tb\tc\nabcdefghijklmno", 40, 10)
+	check := func(what string, boffset, coffset, voffset int) {
+		if v.cursor.boffset != boffset || v.cursor_coffset != coffset || v.cursor_voffset != voffset {
+			t.Errorf("%s: offsets %d/%d/%d, want %d/%d/%d", what,
+				v.cursor.boffset, v.cursor_coffset, v.cursor_voffset,
+				boffset, coffset, voffset)
+		}
+	}
+
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	check("after 'a'", 1, 1, 1)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	check("after the first tab", 2, 2, 8)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	check("end of line", 5, 5, 17)
+	v.on_vcommand(vcommand_move_cursor_backward, 0)
+	check("before 'c'", 4, 4, 16)
+
+	// the visual column is kept across lines
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	check("next line", 15, 15, 15)
+	v.on_vcommand(vcommand_move_cursor_prev_line, 0)
+	check("back", 4, 4, 16)
+	v.on_vcommand(vcommand_move_cursor_beginning_of_line, 0)
+	check("beginning of line", 0, 0, 0)
+}
+
+func TestViewPositionStatus(t *testing.T) {
+	v := new_test_view(t, strings.Repeat("\n", 199), 60, 10)
+	v.on_vcommand(vcommand_move_cursor_to_line, 50)
+	if got := v.position_status(); got != "L50/200 25%" {
+		t.Errorf("got %q", got)
+	}
+
+	// drawn at the right end of the status bar
+	v.draw_status()
+	w, y := v.uibuf.Width, v.height()
+	row := make([]rune, w)
+	for x := range row {
+		row[x] = v.uibuf.Cells[y*w+x].Ch
+	}
+	if got := string(row[w-13 : w-2]); got != "L50/200 25%" {
+		t.Errorf("status bar: %q", string(row))
+	}
+
+	// there is no room in a narrow view
+	v.resize(30, 10)
+	v.draw_status()
+	w = v.uibuf.Width
+	for x := 0; x < w; x++ {
+		if v.uibuf.Cells[y*w+x].Ch == '%' {
+			t.Errorf("narrow status bar: the position is drawn")
+			break
+		}
+	}
+}
+
+func TestViewReadonly(t *testing.T) {
+	v := new_test_view(t, "one two", 40, 10)
+	status := capture_status(v)
+	v.on_vcommand(vcommand_toggle_readonly, 0)
+	if !v.buf.readonly {
+		t.Fatal("the buffer isn't read-only")
+	}
+
+	bytes_n, history := v.buf.bytes_n, v.buf.history
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	if *status != "Buffer is read-only" {
+		t.Errorf("insertion: status %q", *status)
+	}
+	v.on_vcommand(vcommand_kill_word, 0)
+	if v.buf.bytes_n != bytes_n || v.buf.history != history || len(v.buf.history.actions) != 0 {
+		t.Errorf("the buffer changed: %q", v.buf.contents())
+	}
+	v.on_vcommand(vcommand_toggle_crlf, 0)
+	if v.buf.eol != eol_lf || !v.buf.synced_with_disk() {
+		t.Errorf("the line endings changed")
+	}
+
+	// movement, the mark and copying still work
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_word_forward, 0)
+	v.on_vcommand(vcommand_copy_region, 0)
+	if got := string(v.ctx.kill_ring.latest()); got != "one" {
+		t.Errorf("copy: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_toggle_readonly, 0)
+	v.on_vcommand(vcommand_insert_rune, 'x')
+	if got := string(v.buf.contents()); got != "onex two" {
+		t.Errorf("after toggling back: got %q", got)
+	}
+}
+
+func TestViewUndoPause(t *testing.T) {
+	defer func(d time.Duration) { settings.undo_pause = d }(settings.undo_pause)
+	settings.undo_pause = time.Second
+	v := new_test_view(t, "", 40, 10)
+	for _, r := range "one " {
+		v.on_vcommand(vcommand_insert_rune, r)
+	}
+
+	// a pause, as if it was two seconds ago
+	v.buf.history.last = v.buf.history.last.Add(-2 * time.Second)
+	for _, r := range "two" {
+		v.on_vcommand(vcommand_insert_rune, r)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "one " {
+		t.Errorf("first undo: got %q", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "" {
+		t.Errorf("second undo: got %q", got)
+	}
+
+	// without the limit the typing is undone at once
+	settings.undo_pause = 0
+	for _, r := range "one " {
+		v.on_vcommand(vcommand_insert_rune, r)
+	}
+	v.buf.history.last = v.buf.history.last.Add(-2 * time.Second)
+	for _, r := range "two" {
+		v.on_vcommand(vcommand_insert_rune, r)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "" {
+		t.Errorf("no limit: got %q", got)
+	}
+}
+
+func TestViewUndoLimit(t *testing.T) {
+	defer func(n int) { settings.undo_limit = n }(settings.undo_limit)
+	settings.undo_limit = 3
+	v := new_test_view(t, "", 40, 10)
+	for _, r := range "abcde" {
+		v.on_vcommand(vcommand_insert_rune, r)
+		v.on_vcommand(vcommand_move_cursor_backward, 0)
+		v.on_vcommand(vcommand_move_cursor_forward, 0)
+	}
+
+	n := 0
+	for g := v.buf.history; g.prev != nil; g = g.prev {
+		if len(g.actions) == 0 {
+			t.Fatalf("empty action group %d steps back", n)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("%d undo steps kept, want 3", n)
+	}
+
+	for i := 0; i < 4; i++ {
+		v.on_vcommand(vcommand_undo, 0)
+	}
+	if got := string(v.buf.contents()); got != "ab" {
+		t.Errorf("undo to the oldest kept step: got %q", got)
+	}
+	if v.buf.synced_with_disk() {
+		t.Error("the buffer is unmodified at the oldest kept step")
+	}
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_redo, 0)
+	}
+	if got := string(v.buf.contents()); got != "abcde" {
+		t.Errorf("redo: got %q", got)
+	}
+	check_buffer_lines(t, v.buf, "trimmed history")
+}
+
+func TestViewPagingKeepsGoalColumn(t *testing.T) {
+	// every third line is too short for the goal column
+	lines := make([]string, 60)
+	for i := range lines {
+		if i%3 == 2 {
+			lines[i] = "ab"
+		} else {
+			lines[i] = "\t" + strings.Repeat("x", 20)
+		}
+	}
+	v := new_test_view(t, strings.Join(lines, "\n"), 40, 11)
+	v.move_cursor_to(cursor_location{v.buf.first_line, 1, 6})
+	if v.last_cursor_voffset != 13 {
+		t.Fatalf("goal column %d, want 13", v.last_cursor_voffset)
+	}
+
+	check := func(what string) {
+		check_view_invariants(t, v, what)
+		want := 13
+		if len(v.cursor.line.data) == 2 {
+			want = 2
+		}
+		if v.cursor_voffset != want || v.last_cursor_voffset != 13 {
+			t.Errorf("%s: cursor on line %d at column %d, goal %d, want %d/13", what,
+				v.cursor.line_num, v.cursor_voffset, v.last_cursor_voffset, want)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_move_view_page_forward, 0)
+		check("forward")
+	}
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_move_view_page_backward, 0)
+		check("backward")
+	}
+}
+
+func TestViewFillParagraph(t *testing.T) {
+	long := strings.Repeat("lorem ipsum dolor ", 10)
+	src := "first\n\n" + long + "\n\nlast"
+	v := new_test_view(t, src, 40, 10)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	saved := settings.fill_column
+	settings.fill_column = 30
+	defer func() { settings.fill_column = saved }()
+
+	v.on_vcommand(vcommand_fill_paragraph, 0)
+	lines := strings.Split(string(v.buf.contents()), "\n")
+	if lines[0] != "first" || lines[1] != "" || lines[len(lines)-1] != "last" {
+		t.Fatalf("fill changed the other paragraphs: %q", lines)
+	}
+	para := lines[2 : len(lines)-2]
+	if len(para) < 2 {
+		t.Fatalf("paragraph was not wrapped: %q", para)
+	}
+	for _, l := range para {
+		if len(l) > 30 {
+			t.Errorf("line %q is wider than the fill column", l)
+		}
+	}
+	if got := strings.Join(para, " "); got != strings.TrimSpace(long) {
+		t.Errorf("fill changed the words: %q", got)
+	}
+	check_view_invariants(t, v, "fill")
+
+	// one undo brings the paragraph back
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != src {
+		t.Errorf("undo: got %q", got)
+	}
+
+	// a comment prefix and the indentation are kept
+	v = new_test_view(t, "\t// "+long+"\n\t// end", 40, 10)
+	v.on_vcommand(vcommand_fill_paragraph, 0)
+	lines = strings.Split(string(v.buf.contents()), "\n")
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "\t// ") {
+			t.Errorf("line %q lost the prefix", l)
+		}
+	}
+	if len(lines) < 3 || !strings.HasSuffix(lines[len(lines)-1], " end") {
+		t.Errorf("prefixed paragraph: %q", lines)
+	}
+}
+
+func TestViewDeleteTrailingWhitespace(t *testing.T) {
+	src := "one  \n\t\ntwo\t \nthree"
+	v := new_test_view(t, src, 40, 10)
+	status := capture_status(v)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+
+	v.on_vcommand(vcommand_delete_trailing_whitespace, 0)
+	want := "one\n\ntwo\nthree"
+	if got := string(v.buf.contents()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if v.buf.bytes_n != len(want) {
+		t.Errorf("bytes_n is %d, want %d", v.buf.bytes_n, len(want))
+	}
+	if *status != "Deleted trailing whitespace on 3 line(s)" {
+		t.Errorf("status %q", *status)
+	}
+	if v.cursor.line_num != 3 || v.cursor.boffset != 3 {
+		t.Errorf("cursor at %d:%d, want 3:3", v.cursor.line_num, v.cursor.boffset)
+	}
+	check_view_invariants(t, v, "delete trailing whitespace")
+
+	v.on_vcommand(vcommand_delete_trailing_whitespace, 0)
+	if *status != "No trailing whitespace" {
+		t.Errorf("clean buffer: status %q", *status)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != src {
+		t.Errorf("undo: got %q", got)
+	}
+
+	// saving leaves the whitespace alone when trimming is off
+	saved := settings.trim_trailing_whitespace
+	settings.trim_trailing_whitespace = false
+	defer func() { settings.trim_trailing_whitespace = saved }()
+	v.presave_cleanup(false)
+	if got := string(v.buf.contents()); got != src+"\n" {
+		t.Errorf("save without trimming: got %q", got)
+	}
+}
+
+func TestViewJoinLine(t *testing.T) {
+	v := new_test_view(t, "one\n\t  two\n\nthree", 40, 10)
+	status := capture_status(v)
+
+	v.on_vcommand(vcommand_join_line, 0)
+	if got := string(v.buf.contents()); got != "one two\n\nthree" {
+		t.Errorf("join: got %q", got)
+	}
+	if v.cursor.line_num != 1 || v.cursor.boffset != 3 {
+		t.Errorf("cursor at %d:%d, want 1:3", v.cursor.line_num, v.cursor.boffset)
+	}
+
+	// an empty next line is just removed
+	v.on_vcommand(vcommand_join_line, 0)
+	if got := string(v.buf.contents()); got != "one two\nthree" {
+		t.Errorf("join with an empty line: got %q", got)
+	}
+	check_view_invariants(t, v, "join")
+
+	v.on_vcommand(vcommand_undo, 0)
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "one\n\t  two\n\nthree" {
+		t.Errorf("undo: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_join_line, 0)
+	if *status != "End of buffer" {
+		t.Errorf("last line: status %q", *status)
+	}
+}
+
+func TestViewDuplicateLine(t *testing.T) {
+	v := new_test_view(t, "one\ntwo\nthree", 40, 10)
+	other := new_view(v.ctx, v.buf)
+	other.resize(40, 10)
+	other.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+
+	v.on_vcommand(vcommand_duplicate_line, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\ntwo\nthree" {
+		t.Errorf("got %q", got)
+	}
+	if v.buf.lines_n != 4 {
+		t.Errorf("lines_n is %d, want 4", v.buf.lines_n)
+	}
+	if v.cursor.line_num != 3 || v.cursor.boffset != 1 {
+		t.Errorf("cursor at %d:%d, want 3:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	if other.cursor.line_num != 4 || string(other.cursor.line.data) != "three" {
+		t.Errorf("other view cursor on line %d %q", other.cursor.line_num, other.cursor.line.data)
+	}
+	check_view_invariants(t, v, "duplicate line")
+	check_view_invariants(t, other, "duplicate line, other view")
+
+	// the last line has no line break after it
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_duplicate_line, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\ntwo\nthree\nthree" {
+		t.Errorf("last line: got %q", got)
+	}
+
+	v.on_vcommand(vcommand_undo, 0)
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\nthree" || v.buf.lines_n != 3 {
+		t.Errorf("undo: got %q", got)
+	}
+}
+
+func TestViewMoveLines(t *testing.T) {
+	v := new_test_view(t, "one\ntwo\nthree", 40, 10)
+	status := capture_status(v)
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+
+	v.on_vcommand(vcommand_move_line_up, 0)
+	if *status != "Beginning of buffer" {
+		t.Errorf("top line up: status %q", *status)
+	}
+
+	// the top line goes to the bottom
+	v.on_vcommand(vcommand_move_line_down, 0)
+	v.on_vcommand(vcommand_move_line_down, 0)
+	if got := string(v.buf.contents()); got != "two\nthree\none" {
+		t.Errorf("top line down: got %q", got)
+	}
+	if v.cursor.line_num != 3 || v.cursor.boffset != 1 || v.cursor.line != v.buf.last_line {
+		t.Errorf("cursor at %d:%d, want 3:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	check_buffer_lines(t, v.buf, "top line down")
+	check_view_invariants(t, v, "top line down")
+
+	// the bottom line goes to the top
+	v.on_vcommand(vcommand_move_line_up, 0)
+	v.on_vcommand(vcommand_move_line_up, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\nthree" {
+		t.Errorf("bottom line up: got %q", got)
+	}
+	if v.cursor.line_num != 1 || v.cursor.boffset != 1 || v.cursor.line != v.buf.first_line {
+		t.Errorf("cursor at %d:%d, want 1:1", v.cursor.line_num, v.cursor.boffset)
+	}
+	check_buffer_lines(t, v.buf, "bottom line up")
+	check_view_invariants(t, v, "bottom line up")
+
+	// the lines of the region move together and it stays active
+	saved := settings.transient_mark
+	settings.transient_mark = true
+	defer func() { settings.transient_mark = saved }()
+	v.on_vcommand(vcommand_set_mark, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	v.on_vcommand(vcommand_move_line_down, 0)
+	if got := string(v.buf.contents()); got != "three\none\ntwo" {
+		t.Errorf("region down: got %q", got)
+	}
+	if !v.buf.mark_active || v.buf.mark.line_num != 2 || v.cursor.line_num != 3 {
+		t.Errorf("region down: mark on %d (active %v), cursor on %d",
+			v.buf.mark.line_num, v.buf.mark_active, v.cursor.line_num)
+	}
+
+	// each move is undone at once
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "one\ntwo\nthree" {
+		t.Errorf("undo: got %q", got)
+	}
+	v.on_vcommand(vcommand_undo, 0)
+	if got := string(v.buf.contents()); got != "two\none\nthree" {
+		t.Errorf("second undo: got %q", got)
+	}
+
+	// without the transient mark mode a mark left by a yank doesn't make the
+	// lines up to it move
+	settings.transient_mark = false
+	v = new_test_view(t, "a\nb\nc\nd\nf", 40, 10)
+	v.ctx.kill_ring.push([]byte("k"))
+	v.on_vcommand(vcommand_yank, 0)
+	for i := 0; i < 3; i++ {
+		v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	}
+	v.on_vcommand(vcommand_move_line_down, 0)
+	if got := string(v.buf.contents()); got != "ka\nb\nc\nf\nd" {
+		t.Errorf("after a yank: got %q", got)
+	}
+	if v.cursor.line_num != 5 {
+		t.Errorf("after a yank: cursor on %d, want 5", v.cursor.line_num)
+	}
+}
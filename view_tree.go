@@ -209,3 +209,41 @@ func (v *view_tree) first_leaf_node() *view_tree {
 	}
 	panic("unreachable")
 }
+
+// Reports whether 'node' is within the subtree of 'v'.
+func (v *view_tree) contains(node *view_tree) bool {
+	for ; node != nil; node = node.parent {
+		if node == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of views side by side along the direction of the split of 'v',
+// nested splits of the other direction count as one.
+func (v *view_tree) weight(horizontal bool) int {
+	switch {
+	case horizontal && v.left != nil:
+		return v.left.weight(true) + v.right.weight(true)
+	case !horizontal && v.top != nil:
+		return v.top.weight(false) + v.bottom.weight(false)
+	}
+	return 1
+}
+
+// Makes all the views of the subtree equally sized (as far as it's possible),
+// call 'resize' afterwards.
+func (v *view_tree) balance() {
+	if v.left != nil {
+		l, r := v.left.weight(true), v.right.weight(true)
+		v.split = float32(l) / float32(l+r)
+		v.left.balance()
+		v.right.balance()
+	} else if v.top != nil {
+		t, b := v.top.weight(false), v.bottom.weight(false)
+		v.split = float32(t) / float32(t+b)
+		v.top.balance()
+		v.bottom.balance()
+	}
+}
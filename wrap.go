@@ -0,0 +1,227 @@
+package main
+
+import (
+	"unicode/utf8"
+)
+
+//----------------------------------------------------------------------------
+// line wrapping
+//
+// With 'view.wrap' a line wider than the view continues on the next rows
+// instead of scrolling horizontally. The rows of a line are computed from
+// its contents when they're needed, there is nothing to keep in sync with the
+// edits. The top of the view is always the first row of 'top_line', a line
+// taller than the view shows its beginning only.
+//----------------------------------------------------------------------------
+
+// A row of a wrapped line starts at the byte offset 'bo', which is at the
+// visual offset 'vo' of the line.
+type wrap_row struct {
+	bo int
+	vo int
+}
+
+// the rows of a line which doesn't wrap
+var single_row = []wrap_row{{0, 0}}
+
+// Splits the line 'data' into rows 'w' cells wide. A rune which doesn't fit
+// the rest of a row starts the next one, unless it's the first one on its row.
+// A line which fills its last row up has one more empty row, for the cursor at
+// the end of it.
+func wrap_rows(data []byte, w, tabstop int) []wrap_row {
+	if w < 1 {
+		return single_row
+	}
+	rows := single_row
+	row := rows[0]
+	bo, vo := 0, 0
+	for bo < len(data) {
+		r, rlen := utf8.DecodeRune(data[bo:])
+		adv := rune_advance_len(r, vo, tabstop)
+		if vo+adv > row.vo+w && vo > row.vo {
+			row = wrap_row{bo, vo}
+			rows = append(rows[:len(rows):len(rows)], row)
+		}
+		bo += rlen
+		vo += adv
+	}
+	if vo >= row.vo+w {
+		rows = append(rows[:len(rows):len(rows)], wrap_row{bo, vo})
+	}
+	return rows
+}
+
+// The index of the row of 'rows' the byte offset 'bo' is on.
+func wrap_row_index(rows []wrap_row, bo int) int {
+	i := len(rows) - 1
+	for i > 0 && rows[i].bo > bo {
+		i--
+	}
+	return i
+}
+
+func (v *view) wraps() bool {
+	return v.wrap && !v.oneline
+}
+
+// The rows 'line' takes in the view, there is one unless the lines wrap.
+func (v *view) line_rows(line *line) []wrap_row {
+	if !v.wraps() {
+		return single_row
+	}
+	return wrap_rows(line.data, v.width(), v.buf.tabstop)
+}
+
+// The row of 'c' relative to the top of the view, negative above it. Without
+// wrapping it's the line distance.
+func (v *view) rows_to(c cursor_location) int {
+	if !v.wraps() {
+		return c.line_num - v.top_line_num
+	}
+	rows := wrap_row_index(v.line_rows(c.line), c.boffset)
+	if c.line_num < v.top_line_num {
+		for l, n := c.line, c.line_num; n < v.top_line_num; l, n = l.next, n+1 {
+			rows -= len(v.line_rows(l))
+		}
+		return rows
+	}
+	for l, n := v.top_line, v.top_line_num; n < c.line_num; l, n = l.next, n+1 {
+		rows += len(v.line_rows(l))
+	}
+	return rows
+}
+
+// Row and column of 'c' in the text area of the view.
+func (v *view) wrapped_position(c cursor_location, voffset int) (int, int) {
+	rows := v.line_rows(c.line)
+	row := rows[wrap_row_index(rows, c.boffset)]
+	y := v.rows_to(c)
+	if h := v.height(); y >= h && h > 0 {
+		// a line taller than the view, keep the cursor at the bottom
+		y = h - 1
+	}
+	return voffset - row.vo, y
+}
+
+// Moves 'top_line' up as long as the row 'co' of the cursor doesn't go past
+// 'target'.
+func (v *view) raise_top_line_to(co, target int) {
+	for v.top_line.prev != nil {
+		n := len(v.line_rows(v.top_line.prev))
+		if co+n > target {
+			break
+		}
+		co += n
+		v.move_top_line_n_times(-1)
+	}
+}
+
+// 'adjust_top_line' for wrapped lines.
+func (v *view) adjust_top_line_wrapped() {
+	vt := v.vertical_threshold()
+	h := v.height()
+
+	if d := v.cursor.line_num - v.top_line_num; d < 0 || d >= h {
+		// far from the view, every line takes a row at least
+		v.move_top_line_n_times(d)
+		target := vt
+		if d > 0 {
+			target = h - vt - 1
+		}
+		v.raise_top_line_to(v.rows_to(v.cursor), target)
+		v.dirty = dirty_everything
+		return
+	}
+
+	co := v.rows_to(v.cursor)
+	if co >= h-vt && v.top_line != v.cursor.line {
+		for v.top_line != v.cursor.line && co >= h-vt {
+			co -= len(v.line_rows(v.top_line))
+			v.move_top_line_n_times(1)
+		}
+		v.dirty = dirty_everything
+	}
+
+	if co < vt && v.top_line.prev != nil {
+		prev := v.top_line_num
+		v.raise_top_line_to(co, vt)
+		if prev != v.top_line_num {
+			v.dirty = dirty_everything
+		}
+	}
+}
+
+// 'adjust_cursor_line' for wrapped lines, the cursor moves by whole lines.
+func (v *view) adjust_cursor_line_wrapped() {
+	vt := v.vertical_threshold()
+	h := v.height()
+	line, line_num := v.cursor.line, v.cursor.line_num
+	beg := v.rows_to(cursor_location{line, line_num, 0})
+
+	for line.next != nil && (beg < 0 || beg < vt && beg+len(v.line_rows(line)) <= vt) {
+		beg += len(v.line_rows(line))
+		line, line_num = line.next, line_num+1
+	}
+	for line.prev != nil && beg >= h-vt {
+		line, line_num = line.prev, line_num-1
+		beg -= len(v.line_rows(line))
+	}
+
+	if line != v.cursor.line {
+		v.cursor.line, v.cursor.line_num = line, line_num
+		bo, co, vo := line.find_closest_offsets(v.last_cursor_voffset, v.buf.tabstop)
+		v.cursor.boffset = bo
+		v.cursor_coffset = co
+		v.cursor_voffset = vo
+		v.dirty = dirty_everything
+	}
+}
+
+// 'center_view_on_cursor' for wrapped lines.
+func (v *view) center_view_on_cursor_wrapped() {
+	v.top_line = v.cursor.line
+	v.top_line_num = v.cursor.line_num
+	v.raise_top_line_to(v.rows_to(v.cursor), v.height()/2)
+	v.dirty = dirty_everything
+}
+
+// 'line_is_visible' for wrapped lines, the first row of the line must be
+// visible.
+func (v *view) line_is_visible_wrapped(line_num int) bool {
+	if line_num < v.top_line_num {
+		return false
+	}
+	rows := 0
+	l := v.top_line
+	for n := v.top_line_num; n < line_num && l != nil; n++ {
+		rows += len(v.line_rows(l))
+		if rows >= v.height() {
+			return false
+		}
+		l = l.next
+	}
+	return l != nil
+}
+
+// 'location_at' for wrapped lines.
+func (v *view) location_at_wrapped(x, y int) cursor_location {
+	line, line_num := v.top_line, v.top_line_num
+	rows := v.line_rows(line)
+	for y >= len(rows) && line.next != nil {
+		y -= len(rows)
+		line, line_num = line.next, line_num+1
+		rows = v.line_rows(line)
+	}
+	if y >= len(rows) {
+		y = len(rows) - 1
+	}
+
+	row := rows[y]
+	bo, _, _ := line.find_closest_offsets(row.vo+x, v.buf.tabstop)
+	if y+1 < len(rows) && bo >= rows[y+1].bo {
+		// past the end of the row, to its last rune
+		_, rlen := utf8.DecodeLastRune(line.data[:rows[y+1].bo])
+		bo = rows[y+1].bo - rlen
+	}
+	return cursor_location{line, line_num, bo}
+}
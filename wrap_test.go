@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapRows(t *testing.T) {
+	cases := []struct {
+		data string
+		w    int
+		want []wrap_row
+	}{
+		{"abc", 4, []wrap_row{{0, 0}}},
+		{"abcd", 4, []wrap_row{{0, 0}, {4, 4}}},
+		{"abc\tde", 4, []wrap_row{{0, 0}, {3, 3}, {4, 8}}},
+		{"ab世", 3, []wrap_row{{0, 0}, {2, 2}}},
+		{"", 4, []wrap_row{{0, 0}}},
+	}
+	for _, c := range cases {
+		rows := wrap_rows([]byte(c.data), c.w, tabstop_length)
+		if len(rows) != len(c.want) {
+			t.Errorf("%q in %d: got %v, want %v", c.data, c.w, rows, c.want)
+			continue
+		}
+		for i := range rows {
+			if rows[i] != c.want[i] {
+				t.Errorf("%q in %d: got %v, want %v", c.data, c.w, rows, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestViewWrapLines(t *testing.T) {
+	v := new_test_view(t, "0123456789abcdefghij\nxy\n", 10, 6)
+	v.on_vcommand(vcommand_toggle_wrap_lines, 0)
+	v.draw_contents()
+	row := func(y int) string {
+		s := ""
+		for x := 0; x < 10; x++ {
+			s += string(v.uibuf.Get(x, y).Ch)
+		}
+		return strings.TrimRight(s, " ")
+	}
+	for y, want := range []string{"0123456789", "abcdefghij", "", "xy"} {
+		if got := row(y); got != want {
+			t.Errorf("row %d: got %q, want %q", y, got, want)
+		}
+	}
+	if loc := v.location_at(3, 1); loc.line_num != 1 || loc.boffset != 13 {
+		t.Errorf("location at 3, 1: got %d:%d, want 1:13", loc.line_num, loc.boffset)
+	}
+	if loc := v.location_at(5, 3); loc.line_num != 2 || loc.boffset != 2 {
+		t.Errorf("location at 5, 3: got %d:%d, want 2:2", loc.line_num, loc.boffset)
+	}
+
+	v.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	if x, y := v.cursor_position(); x != 0 || y != 2 {
+		t.Errorf("cursor at the end of the line: got %d, %d, want 0, 2", x, y)
+	}
+	v.on_vcommand(vcommand_move_cursor_forward, 0)
+	if x, y := v.cursor_position(); x != 0 || y != 0 {
+		t.Errorf("cursor on the next line: got %d, %d, want 0, 0", x, y)
+	}
+
+	// lines of three rows in a view of five, the cursor can't go past the
+	// third row (the vertical threshold is two rows)
+	v = new_test_view(t, strings.Repeat(strings.Repeat("x", 25)+"\n", 10), 10, 6)
+	v.on_vcommand(vcommand_toggle_wrap_lines, 0)
+	v.on_vcommand(vcommand_move_cursor_next_line, 0)
+	if v.top_line_num != 2 {
+		t.Errorf("top line: got %d, want 2", v.top_line_num)
+	}
+	if _, y := v.cursor_position(); y != 0 {
+		t.Errorf("cursor row: got %d, want 0", y)
+	}
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	if _, y := v.cursor_position(); y < 0 || y >= v.height() {
+		t.Errorf("cursor at the end of the buffer isn't visible: row %d", y)
+	}
+
+	// wrapping is a setting of the view, not of its buffer
+	if new_view(v.ctx, v.buf).wraps() {
+		t.Error("another view of the buffer wraps its lines")
+	}
+
+	v.on_vcommand(vcommand_toggle_wrap_lines, 0)
+	if v.wraps() {
+		t.Error("lines wrap after toggling wrapping off")
+	}
+}
+
+func TestViewWrapLinesGutterGrows(t *testing.T) {
+	// nine lines of nine characters fit into 10 columns right of a gutter
+	// of 2, with the tenth line the gutter takes 3 and they wrap
+	v := new_test_view(t, strings.TrimSuffix(strings.Repeat("xxxxxxxxx\n", 9), "\n"), 12, 6)
+	v.on_vcommand(vcommand_toggle_wrap_lines, 0)
+	v.on_vcommand(vcommand_toggle_line_numbers, 0)
+	other := new_view(v.ctx, v.buf)
+	other.resize(12, 6)
+	other.on_vcommand(vcommand_toggle_wrap_lines, 0)
+	other.on_vcommand(vcommand_toggle_line_numbers, 0)
+	for i := 0; i < 3; i++ {
+		other.on_vcommand(vcommand_move_cursor_next_line, 0)
+	}
+	other.on_vcommand(vcommand_move_cursor_end_of_line, 0)
+	if _, y := other.cursor_position(); y != 2 {
+		t.Fatalf("before: cursor row %d, want 2", y)
+	}
+
+	v.on_vcommand(vcommand_move_cursor_end_of_file, 0)
+	v.on_vcommand(vcommand_insert_rune, '\r')
+	if v.gutter_width() != 3 || len(other.line_rows(other.cursor.line)) != 2 {
+		t.Fatalf("gutter %d, the cursor line takes %d rows", v.gutter_width(),
+			len(other.line_rows(other.cursor.line)))
+	}
+	for _, v := range []*view{v, other} {
+		if y := v.rows_to(v.cursor); y < 0 || y >= v.height() {
+			t.Errorf("the cursor isn't visible: row %d", y)
+		}
+	}
+}